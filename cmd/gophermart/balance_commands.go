@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"math"
+	"strconv"
+	"time"
+)
+
+// adjustedBalance is the result of balance adjust.
+type adjustedBalance struct {
+	Login     string  `json:"login"`
+	Sum       float64 `json:"sum"`
+	Reason    string  `json:"reason"`
+	Balance   float64 `json:"balance"`
+	Withdrawn float64 `json:"withdrawn"`
+}
+
+func balanceAdjust() command {
+	var reason string
+	return command{
+		name:  "balance adjust",
+		args:  "<login> <amount>",
+		usage: "Credits the amount of points to the balance of the user, or debits it if the amount is negative.",
+		define: func(flags *flag.FlagSet) {
+			flags.StringVar(&reason, "reason", "", "Reason of the adjustment, e.g. the support ticket (required)")
+		},
+		run: func(ctx context.Context, database gophermart.Database, args []string) (result, error) {
+			if len(args) != 2 {
+				return result{}, errUsage
+			}
+			sum, parseError := strconv.ParseFloat(args[1], 64)
+			if parseError != nil || sum == 0 || math.IsNaN(sum) || math.IsInf(sum, 0) {
+				return result{}, fmt.Errorf("invalid amount %q, want a non-zero number of points", args[1])
+			}
+			if reason == "" {
+				return result{}, errors.New("missing -reason")
+			}
+			identity, findError := findUser(ctx, database, args[0])
+			if findError != nil {
+				return result{}, findError
+			}
+			if err := database.AdjustBalance(ctx, identity.ID(), sum, reason); err != nil {
+				if errors.Is(err, idp.ErrBalanceTooLow) {
+					return result{}, fmt.Errorf("the balance of %q is less than %s", args[0], formatPoints(-sum))
+				}
+				return result{}, err
+			}
+			balance, balanceError := identity.Balance(ctx)
+			if balanceError != nil {
+				return result{}, balanceError
+			}
+			adjusted := adjustedBalance{Login: args[0], Sum: sum, Reason: reason, Balance: balance.Current, Withdrawn: balance.Withdrawn}
+			return fields(
+				adjusted,
+				[]string{"login", "sum", "reason", "balance", "withdrawn"},
+				adjusted.Login,
+				formatPoints(adjusted.Sum),
+				adjusted.Reason,
+				formatPoints(adjusted.Balance),
+				formatPoints(adjusted.Withdrawn),
+			), nil
+		},
+	}
+}
+
+// withdrawal is a row of withdrawals list.
+type withdrawal struct {
+	Order string    `json:"order"`
+	Sum   float64   `json:"sum"`
+	Time  time.Time `json:"processed_at"`
+}
+
+func withdrawalsList() command {
+	var limit int
+	return command{
+		name:  "withdrawals list",
+		args:  "<login>",
+		usage: "Lists the withdrawals of the user, the newest first.",
+		define: func(flags *flag.FlagSet) {
+			flags.IntVar(&limit, "limit", 0, "Maximum number of withdrawals, all of them if 0")
+		},
+		run: func(ctx context.Context, database gophermart.Database, args []string) (result, error) {
+			if len(args) != 1 || limit < 0 {
+				return result{}, errUsage
+			}
+			identity, findError := findUser(ctx, database, args[0])
+			if findError != nil {
+				return result{}, findError
+			}
+			withdrawals, withdrawalsError := identity.Withdrawals(ctx, idp.ListQuery{Descending: true, Limit: limit})
+			if withdrawalsError != nil {
+				return result{}, withdrawalsError
+			}
+			listed := make([]withdrawal, len(withdrawals))
+			rows := make([][]string, len(withdrawals))
+			for i, w := range withdrawals {
+				listed[i] = withdrawal{Order: w.Order, Sum: w.Sum, Time: w.Time.UTC()}
+				rows[i] = []string{w.Order, formatPoints(w.Sum), formatTime(w.Time)}
+			}
+			return result{value: listed, header: []string{"ORDER", "SUM", "PROCESSED AT"}, rows: rows}, nil
+		},
+	}
+}
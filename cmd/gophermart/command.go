@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"github.com/pior/runnable"
+	"golang.org/x/exp/slog"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// databaseTimeout is how long a command waits for the database to connect.
+const databaseTimeout = 30 * time.Second
+
+// errUsage is returned by a command called with wrong arguments, its usage is printed.
+var errUsage = errors.New("usage")
+
+// command is an operator command, run against the configured database
+// instead of the API, e.g. "gophermart user show alice".
+type command struct {
+	// name is the words of the command, e.g. "user show".
+	name string
+
+	// args is the synopsis of the arguments after the flags.
+	args string
+
+	usage string
+
+	// define defines the flags of the command.
+	define func(flags *flag.FlagSet)
+
+	// run runs the command with the arguments after the flags.
+	run func(ctx context.Context, database gophermart.Database, args []string) (result, error)
+}
+
+// findCommand returns the command named by the first words of args and the rest of args.
+func findCommand(args []string) (command, []string, bool) {
+	for _, c := range commands() {
+		words := strings.Fields(c.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == c.name {
+			return c, args[len(words):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+// isCommandGroup reports whether name is the first word of a command, e.g. "user".
+func isCommandGroup(name string) bool {
+	for _, c := range commands() {
+		if group, _, _ := strings.Cut(c.name, " "); group == name {
+			return true
+		}
+	}
+	return false
+}
+
+// printCommands prints the synopses of the commands.
+func printCommands(out io.Writer) {
+	fmt.Fprintln(out, "Commands:")
+	for _, c := range commands() {
+		fmt.Fprintf(out, "  %s [flags] %s\n    \t%s\n", c.name, c.args, c.usage)
+	}
+}
+
+// runCommand parses the flags of the command along with the settings of the database,
+// runs the command and prints its result; it returns the exit code of the process.
+func runCommand(c command, args []string) int {
+	config := Config{}
+	flags := flag.NewFlagSet(os.Args[0]+" "+c.name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] %s\n\n%s\n\n", flags.Name(), c.args, c.usage)
+		flags.PrintDefaults()
+	}
+	format := flags.String("o", formatTable, "Output format, "+formatTable+" or "+formatJSON)
+	if c.define != nil {
+		c.define(flags)
+	}
+	line, parseError := parseCommandLine(&config, flags, args)
+	if errors.Is(parseError, flag.ErrHelp) {
+		return 0
+	}
+	if parseError != nil {
+		return 2
+	}
+	if *format != formatTable && *format != formatJSON {
+		fmt.Fprintf(os.Stderr, "unsupported output format %q\n", *format)
+		return 2
+	}
+	if err := errors.Join(config.load(line), config.validateDatabase()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if config.DatabaseDriver == gophermart.DatabaseDriverMemory {
+		fmt.Fprintln(os.Stderr, "the commands need a persistent database, the memory database is empty (-d|DATABASE_URI)")
+		return 2
+	}
+
+	// Only the problems of the database are logged, the output is the result.
+	logger, loggerError := logging.New(os.Stderr, slog.LevelWarn, config.LogFormat)
+	if loggerError != nil {
+		fmt.Fprintln(os.Stderr, loggerError)
+		return 2
+	}
+	slog.SetDefault(logger)
+	runnable.SetLogger(slog.NewLogLogger(logger.Handler(), slog.LevelDebug))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	r, runError := withDatabase(ctx, config.Gophermart(), func(database gophermart.Database) (result, error) {
+		return c.run(ctx, database, line.args)
+	})
+	if errors.Is(runError, errUsage) {
+		flags.Usage()
+		return 2
+	}
+	if runError != nil {
+		fmt.Fprintln(os.Stderr, runError)
+		return 1
+	}
+	if err := r.write(os.Stdout, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// withDatabase connects to the database, calls f and disconnects.
+func withDatabase(ctx context.Context, config gophermart.Config, f func(database gophermart.Database) (result, error)) (result, error) {
+	database, databaseError := gophermart.OpenDatabase(config)
+	if databaseError != nil {
+		return result{}, databaseError
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var runError error
+	go func() {
+		defer close(done)
+		runError = database.Run(runCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(databaseTimeout)
+	for database.Available() != nil {
+		select {
+		case <-ctx.Done():
+			return result{}, ctx.Err()
+		case <-done:
+			return result{}, fmt.Errorf("the database has stopped: %w", runError)
+		case <-timeout:
+			return result{}, fmt.Errorf("%w in %s", idp.ErrUnavailable, databaseTimeout)
+		case <-ticker.C:
+		}
+	}
+	return f(database)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"io"
+	"os"
+	"strings"
+)
+
+// commands returns the operator commands, each with flags of its own.
+func commands() []command {
+	return []command{
+		userCreate(),
+		userResetPassword(),
+		userShow(),
+		orderRecheck(),
+		balanceAdjust(),
+		withdrawalsList(),
+	}
+}
+
+// findUser returns the identity of the login.
+func findUser(ctx context.Context, database gophermart.Database, login string) (idp.Identity, error) {
+	identity, findError := database.Find(ctx, login)
+	if errors.Is(findError, idp.ErrUnknownIdentity) {
+		return nil, fmt.Errorf("unknown user %q", login)
+	}
+	return identity, findError
+}
+
+// password reads the password from the standard input if fromStdin is set,
+// or generates a random one otherwise; generated reports which one it is.
+func password(fromStdin bool) (_ string, generated bool, _ error) {
+	if fromStdin {
+		content, readError := io.ReadAll(os.Stdin)
+		if readError != nil {
+			return "", false, readError
+		}
+		read := strings.TrimRight(string(content), "\r\n")
+		if read == "" {
+			return "", false, errors.New("the password read from the standard input is empty")
+		}
+		return read, false, nil
+	}
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", false, err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), true, nil
+}
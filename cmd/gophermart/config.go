@@ -3,46 +3,332 @@ package main
 import (
 	"errors"
 	"flag"
-	"github.com/caarlos0/env/v8"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/clientip"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/ratelimit"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"github.com/kerelape/gophermart/internal/gophermart/tlsconfig"
+	"github.com/kerelape/gophermart/internal/gophermart/tracing"
+	"golang.org/x/exp/slog"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
 )
 
+// databaseDrivers maps database dsn uri schemes to the database drivers.
+var databaseDrivers = map[string]string{
+	"postgres":   gophermart.DatabaseDriverPostgres,
+	"postgresql": gophermart.DatabaseDriverPostgres,
+	"memory":     gophermart.DatabaseDriverMemory,
+	"sqlite":     gophermart.DatabaseDriverSQLite,
+	"sqlite3":    gophermart.DatabaseDriverSQLite,
+}
+
+// envConfigFile is the environment variable of the config file, -c overrides it.
+const envConfigFile = "CONFIG_FILE"
+
+// Config is the configuration of the gophermart command.
+//
+// Every setting is taken from, in the order of precedence, the flag, the environment variable,
+// the config file (by the lower case name of the environment variable) and the default,
+// see the setting type for the tags. The secrets may also be read from files (see setting.secret).
 type Config struct {
-	AddressRun           string `env:"RUN_ADDRESS"`
-	AddressAccrualSystem string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	AddressDatabase      string `env:"DATABASE_URI"`
-	JWTSecretKey         string `env:"JWT_SECRET_KEY"`
+	AddressRun           string `env:"RUN_ADDRESS" flag:"a" usage:"Server run address" required:"true"`
+	AddressGRPC          string `env:"GRPC_ADDRESS" flag:"g" usage:"gRPC server run address (the gRPC server is disabled if empty)"`
+	GRPCReflection       bool   `env:"GRPC_REFLECTION" flag:"grpc-reflection" usage:"Register the gRPC server reflection, which lets the clients list the services"`
+	AddressAdmin         string `env:"ADMIN_ADDRESS" flag:"admin-address" usage:"Admin server run address, serving POST /reload (the admin server is disabled if empty, a non-loopback one requires the admin token or the client CAs)"`
+	AdminToken           string `env:"ADMIN_TOKEN" flag:"admin-token" usage:"Bearer token of the admin server and /metrics (if neither it nor the client CAs are set, they are served only to the loopback clients)" secret:"true"`
+	AddressAccrualSystem string `env:"ACCRUAL_SYSTEM_ADDRESS" flag:"r" usage:"Accrual system address" required:"true"`
+	AddressDatabase      string `env:"DATABASE_URI" flag:"d" usage:"Database DSN URI" required:"true" secret:"password"`
+	JWTSecretKey         string `env:"JWT_SECRET_KEY" flag:"jwt-secret-key" usage:"Key the tokens are signed with" required:"true" secret:"true"`
+	TestMode             bool   `env:"TEST_MODE" flag:"test-mode" usage:"Validate responses against the OpenAPI specification"`
+
+	// TokenTTL is how long the issued tokens are valid for.
+	TokenTTL time.Duration `env:"TOKEN_TTL" flag:"token-ttl" usage:"How long the issued tokens are valid for" default:"24h"`
+
+	// IdempotencyWindow is how long the responses to the requests
+	// made with an Idempotency-Key are kept.
+	IdempotencyWindow time.Duration `env:"IDEMPOTENCY_WINDOW" flag:"idempotency-window" usage:"How long the responses to the requests with an Idempotency-Key are kept" default:"24h"`
+
+	// The rate limits in the format of ratelimit.ParseLimit.
+	RateLimitAuth   ratelimit.Limit `env:"RATE_LIMIT_AUTH" flag:"rate-limit-auth" usage:"Registrations and logins per client address, e.g. 60/m or off" default:"60/m"`
+	RateLimitOrders ratelimit.Limit `env:"RATE_LIMIT_ORDERS" flag:"rate-limit-orders" usage:"Order uploads per user" default:"60/m"`
+	RateLimitUser   ratelimit.Limit `env:"RATE_LIMIT_USER" flag:"rate-limit-user" usage:"Requests per user" default:"1200/m"`
+
+	// TrustedProxies is a comma separated list of the networks
+	// the forwarding headers of the requests are trusted from.
+	TrustedProxies string `env:"TRUSTED_PROXIES" flag:"trusted-proxies" usage:"Comma separated networks of the proxies trusted to set X-Forwarded-For"`
+
+	// The accrual system is polled every PollInterval with at most PollConcurrency requests at once.
+	PollInterval    time.Duration `env:"POLL_INTERVAL" flag:"poll-interval" usage:"How often the accrual system is polled for the orders being processed" default:"1s"`
+	PollConcurrency int           `env:"POLL_CONCURRENCY" flag:"poll-concurrency" usage:"Maximum number of concurrent requests to the accrual system" default:"16"`
+	AccrualTimeout  time.Duration `env:"ACCRUAL_TIMEOUT" flag:"accrual-timeout" usage:"Timeout of a request to the accrual system" default:"10s"`
+
+	// The webhooks are delivered with at most WebhookConcurrency requests at once.
+	WebhookTimeout     time.Duration `env:"WEBHOOK_TIMEOUT" flag:"webhook-timeout" usage:"Timeout of a webhook delivery request" default:"10s"`
+	WebhookConcurrency int           `env:"WEBHOOK_CONCURRENCY" flag:"webhook-concurrency" usage:"Maximum number of concurrent webhook delivery requests" default:"8"`
+
+	// The timeouts of the HTTP server, see http.Server.
+	HTTPReadTimeout time.Duration `env:"HTTP_READ_TIMEOUT" flag:"http-read-timeout" usage:"Maximum duration of reading a request" default:"30s"`
+	HTTPIdleTimeout time.Duration `env:"HTTP_IDLE_TIMEOUT" flag:"http-idle-timeout" usage:"How long an idle keep-alive connection is kept" default:"2m"`
+
+	// The servers serve TLS if the certificate and the key files are set, the files are
+	// reloaded when they change. The client certificates signed by the CAs of TLSClientCAFile
+	// are required by the admin server, and authorize the requests to /metrics as AdminToken does.
+	TLSCertFile     string `env:"TLS_CERT_FILE" flag:"tls-cert-file" usage:"PEM certificate file of the servers (TLS is disabled if empty)"`
+	TLSKeyFile      string `env:"TLS_KEY_FILE" flag:"tls-key-file" usage:"PEM private key file of the certificate"`
+	TLSMinVersion   string `env:"TLS_MIN_VERSION" flag:"tls-min-version" usage:"Minimum TLS version: 1.0, 1.1, 1.2 or 1.3" default:"1.2"`
+	TLSCipherSuites string `env:"TLS_CIPHER_SUITES" flag:"tls-cipher-suites" usage:"Comma separated cipher suites of TLS 1.0-1.2 (the Go defaults if empty)"`
+	TLSClientCAFile string `env:"TLS_CLIENT_CA_FILE" flag:"tls-client-ca-file" usage:"PEM CA certificates the client certificates of the admin server and /metrics are verified with"`
+
+	// AddressRedirect is the address of the server redirecting HTTP to HTTPS.
+	AddressRedirect string `env:"HTTP_REDIRECT_ADDRESS" flag:"http-redirect-address" usage:"Address of the server redirecting plain HTTP to HTTPS (disabled if empty)"`
+
+	// LogLevel is the minimum level of the logged records (debug, info, warn or error).
+	LogLevel slog.Level `env:"LOG_LEVEL" flag:"log-level" usage:"Minimum level of the logged records: debug, info, warn or error" default:"info"`
+
+	// LogFormat is the format of the logs, json or text.
+	LogFormat string `env:"LOG_FORMAT" flag:"log-format" usage:"Format of the logs: json or text" default:"json"`
+
+	// TracingExporter is where the spans are exported to, none, stdout or otlp.
+	// The OTLP exporter is configured by the standard OTEL_EXPORTER_OTLP_* variables.
+	TracingExporter string `env:"TRACING_EXPORTER" flag:"tracing-exporter" usage:"Where the spans are exported to: none, stdout or otlp" default:"none"`
+
+	// DatabaseDriver is the storage backend chosen by the scheme of AddressDatabase.
+	DatabaseDriver string
+
+	// TrustedProxyNetworks are the parsed TrustedProxies.
+	TrustedProxyNetworks []netip.Prefix
+
+	// TLSMinVersionID and TLSCipherSuiteIDs are the parsed TLSMinVersion and TLSCipherSuites.
+	TLSMinVersionID   uint16
+	TLSCipherSuiteIDs []uint16
+}
+
+// Gophermart returns the configuration of the gophermart service.
+func (c Config) Gophermart() gophermart.Config {
+	return gophermart.Config{
+		AddressAPIServer:     c.AddressRun,
+		AddressGRPCServer:    c.AddressGRPC,
+		GRPCReflection:       c.GRPCReflection,
+		AddressAdminServer:   c.AddressAdmin,
+		AdminToken:           c.AdminToken,
+		AddressAccrualSystem: c.AddressAccrualSystem,
+		DatabaseDriver:       c.DatabaseDriver,
+		AddressDatabase:      c.AddressDatabase,
+		JWTSecret:            c.JWTSecretKey,
+		TokenTTL:             c.TokenTTL,
+		IdempotencyWindow:    c.IdempotencyWindow,
+		RateLimits: user.RateLimits{
+			Auth:   c.RateLimitAuth,
+			Orders: c.RateLimitOrders,
+			User:   c.RateLimitUser,
+		},
+		TrustedProxies:        c.TrustedProxyNetworks,
+		PollInterval:          c.PollInterval,
+		PollConcurrency:       c.PollConcurrency,
+		AccrualTimeout:        c.AccrualTimeout,
+		WebhookTimeout:        c.WebhookTimeout,
+		WebhookConcurrency:    c.WebhookConcurrency,
+		ReadTimeout:           c.HTTPReadTimeout,
+		IdleTimeout:           c.HTTPIdleTimeout,
+		TLSCertFile:           c.TLSCertFile,
+		TLSKeyFile:            c.TLSKeyFile,
+		TLSMinVersion:         c.TLSMinVersionID,
+		TLSCipherSuites:       c.TLSCipherSuiteIDs,
+		TLSClientCAFile:       c.TLSClientCAFile,
+		AddressRedirectServer: c.AddressRedirect,
+		LogLevel:              c.LogLevel,
+		TracingExporter:       c.TracingExporter,
+		TestMode:              c.TestMode,
+	}
 }
 
-func ParseConfig() (Config, error) {
+// LoadConfig loads the configuration from the command line arguments (without the program name),
+// the environment and the config file, and validates it.
+//
+// All the invalid settings are reported at once, joined in the returned error.
+func LoadConfig(name string, args []string, output io.Writer) (Config, error) {
 	config := Config{}
-	if err := env.Parse(&config); err != nil {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(output)
+	line, parseError := parseCommandLine(&config, flags, args)
+	if parseError != nil {
+		return Config{}, parseError
+	}
+	if len(line.args) > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments %q", line.args)
+	}
+	if err := errors.Join(config.load(line), config.validate()); err != nil {
 		return Config{}, err
 	}
+	return config, nil
+}
+
+// commandLine is the parsed command line, the values of the flags are kept by the flag names
+// and args are the arguments after the flags.
+type commandLine struct {
+	configFile string
+	values     map[string]string
+	args       []string
+}
+
+// parseCommandLine parses the flags of the settings of config and -c with flags,
+// which may have been given flags of its own.
+func parseCommandLine(config *Config, flags *flag.FlagSet, args []string) (commandLine, error) {
+	configFile := flags.String("c", os.Getenv(envConfigFile), "Config file, YAML (.yaml or .yml) or TOML (.toml) (env "+envConfigFile+")")
+	values := make(map[string]string)
+	for _, s := range settingsOf(config) {
+		s.define(flags, values)
+	}
+	if err := flags.Parse(args); err != nil {
+		return commandLine{}, err
+	}
+	return commandLine{configFile: *configFile, values: values, args: flags.Args()}, nil
+}
+
+// load sets the settings to the defaults, the config file, the environment and the flags, in this order.
+func (c *Config) load(line commandLine) error {
+	settings := settingsOf(c)
+	errs := make([]error, 0)
+	for _, s := range settings {
+		if s.def == "" {
+			continue
+		}
+		if err := s.set(s.def); err != nil {
+			errs = append(errs, fmt.Errorf("default of %s: %w", s, err))
+		}
+	}
+	if line.configFile != "" {
+		errs = append(errs, loadConfigFile(settings, line.configFile))
+	}
+	for _, s := range settings {
+		errs = append(errs, s.load(os.Getenv(s.env), os.Getenv(s.env+"_FILE"), s.env))
+	}
+	for _, s := range settings {
+		value, valueSet := line.values[s.flag]
+		file, fileSet := line.values[s.fileFlag()]
+		if !valueSet && !fileSet {
+			continue
+		}
+		if valueSet && value == "" && !fileSet {
+			// An explicitly empty flag resets the setting (e.g. -g "" disables the gRPC server).
+			errs = append(errs, s.set(""))
+			continue
+		}
+		errs = append(errs, s.load(value, file, "-"+s.flag))
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the settings and resolves the derived ones.
+func (c *Config) validate() error {
+	errs := make([]error, 0)
+	for _, s := range settingsOf(c) {
+		errs = append(errs, s.validate())
+	}
 
-	addressRun := flag.String("a", "", "Server run address")
-	addressAccrualSystem := flag.String("r", "", "Accrual system address")
-	addressDatabase := flag.String("d", "", "Database DSN URI")
-	flag.Parse()
+	if c.LogFormat != logging.FormatJSON && c.LogFormat != logging.FormatText {
+		errs = append(errs, fmt.Errorf("unsupported log format %q (-log-format|LOG_FORMAT)", c.LogFormat))
+	}
 
-	if *addressRun != "" {
-		config.AddressRun = *addressRun
+	switch c.TracingExporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported tracing exporter %q (-tracing-exporter|TRACING_EXPORTER)", c.TracingExporter))
 	}
-	if *addressAccrualSystem != "" {
-		config.AddressAccrualSystem = *addressAccrualSystem
+
+	if c.AddressDatabase != "" {
+		errs = append(errs, c.validateDatabase())
 	}
-	if *addressDatabase != "" {
-		config.AddressDatabase = *addressDatabase
+
+	trustedProxyNetworks, trustedProxiesError := clientip.ParseTrusted(c.TrustedProxies)
+	if trustedProxiesError != nil {
+		errs = append(errs, fmt.Errorf("%w (-trusted-proxies|TRUSTED_PROXIES)", trustedProxiesError))
 	}
+	c.TrustedProxyNetworks = trustedProxyNetworks
 
-	if config.AddressRun == "" {
-		return Config{}, errors.New("missing server run address (-a|RUN_ADDRESS)")
+	errs = append(errs, c.validateTLS())
+
+	if c.AddressAdmin != "" && c.AdminToken == "" && c.TLSClientCAFile == "" && !loopbackAddress(c.AddressAdmin) {
+		errs = append(errs, errors.New("the admin server on a non-loopback address requires the admin token or the client CAs (-admin-address|ADMIN_ADDRESS, -admin-token|ADMIN_TOKEN, -tls-client-ca-file|TLS_CLIENT_CA_FILE)"))
 	}
-	if config.AddressAccrualSystem == "" {
-		return Config{}, errors.New("missing accrual system address (-r|ACCRUAL_SYSTEM_ADDRESS)")
+
+	return errors.Join(errs...)
+}
+
+// loopbackAddress reports whether the server address listens only on the loopback interface.
+func loopbackAddress(address string) bool {
+	host, _, splitError := net.SplitHostPort(address)
+	if splitError != nil {
+		return false
 	}
-	if config.AddressDatabase == "" {
-		return Config{}, errors.New("missing database dsn uri (-d|DATABASE_URI)")
+	if strings.EqualFold(host, "localhost") {
+		return true
 	}
+	ip, parseError := netip.ParseAddr(host)
+	return parseError == nil && ip.Unmap().IsLoopback()
+}
 
-	return config, nil
+// validateDatabase checks the database dsn and resolves the database driver.
+func (c *Config) validateDatabase() error {
+	if c.AddressDatabase == "" {
+		return errors.New("missing database_uri (-d|DATABASE_URI)")
+	}
+	databaseDriver, databaseDriverError := DatabaseDriver(c.AddressDatabase)
+	if databaseDriverError != nil {
+		return databaseDriverError
+	}
+	c.DatabaseDriver = databaseDriver
+	return nil
+}
+
+// validateTLS checks the TLS settings and resolves the parsed ones.
+func (c *Config) validateTLS() error {
+	errs := make([]error, 0)
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("the TLS certificate and key files are set together (-tls-cert-file|TLS_CERT_FILE, -tls-key-file|TLS_KEY_FILE)"))
+	}
+	if c.TLSCertFile == "" && c.TLSClientCAFile != "" {
+		errs = append(errs, errors.New("client certificates require TLS (-tls-client-ca-file|TLS_CLIENT_CA_FILE)"))
+	}
+	if c.TLSCertFile == "" && c.AddressRedirect != "" {
+		errs = append(errs, errors.New("redirection to HTTPS requires TLS (-http-redirect-address|HTTP_REDIRECT_ADDRESS)"))
+	}
+
+	minVersion, versionError := tlsconfig.ParseVersion(c.TLSMinVersion)
+	if versionError != nil {
+		errs = append(errs, fmt.Errorf("%w (-tls-min-version|TLS_MIN_VERSION)", versionError))
+	}
+	c.TLSMinVersionID = minVersion
+
+	cipherSuites, cipherSuitesError := tlsconfig.ParseCipherSuites(c.TLSCipherSuites)
+	if cipherSuitesError != nil {
+		errs = append(errs, fmt.Errorf("%w (-tls-cipher-suites|TLS_CIPHER_SUITES)", cipherSuitesError))
+	}
+	if len(cipherSuites) > 0 {
+		c.TLSCipherSuiteIDs = cipherSuites
+	}
+	return errors.Join(errs...)
+}
+
+// DatabaseDriver returns the database driver for the dsn.
+//
+// A dsn without a scheme (e.g. "host=localhost dbname=gophermart")
+// is treated as a PostgreSQL connection string.
+func DatabaseDriver(dsn string) (string, error) {
+	scheme, _, found := strings.Cut(dsn, "://")
+	if !found {
+		return gophermart.DatabaseDriverPostgres, nil
+	}
+	driver, ok := databaseDrivers[strings.ToLower(scheme)]
+	if !ok {
+		return "", fmt.Errorf("unsupported database dsn uri scheme %q (-d|DATABASE_URI)", scheme)
+	}
+	return driver, nil
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errUnknownKey is returned for the keys of a config file that are not settings.
+var errUnknownKey = errors.New("unknown key")
+
+// loadConfigFile sets the settings to the values of the config file at path,
+// a YAML file (.yaml or .yml) or a TOML file (.toml) with a key per setting.
+//
+// Lists (e.g. of the trusted proxies) are joined with commas.
+func loadConfigFile(settings []setting, path string) error {
+	content, readError := os.ReadFile(path)
+	if readError != nil {
+		return readError
+	}
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(content), &values); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported config file format %q, expected .yaml, .yml or .toml", filepath.Ext(path))
+	}
+
+	bySecretFile := make(map[string]setting)
+	byKey := make(map[string]setting)
+	for _, s := range settings {
+		byKey[s.key()] = s
+		if s.secret != "" {
+			bySecretFile[s.key()+"_file"] = s
+		}
+	}
+	errs := make([]error, 0)
+	for key := range values {
+		if _, ok := byKey[key]; !ok {
+			if _, ok := bySecretFile[key]; !ok {
+				errs = append(errs, fmt.Errorf("%s: %w %q", path, errUnknownKey, key))
+			}
+		}
+	}
+	for _, s := range settings {
+		value, file := configFileValue(values[s.key()]), configFileValue(values[s.key()+"_file"])
+		if err := s.load(value, file, path+": "+s.key()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// configFileValue formats a value decoded from a config file as the settings parse it.
+func configFileValue(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"gopkg.in/yaml.v3"
+	"io"
+)
+
+// printConfig writes the effective settings of the config to out as a YAML config file,
+// with the secrets redacted.
+func printConfig(out io.Writer, config *Config) error {
+	document := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range settingsOf(config) {
+		value := &yaml.Node{}
+		if err := value.Encode(s.redactedValue()); err != nil {
+			return err
+		}
+		document.Content = append(document.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: s.key()}, value)
+	}
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,27 @@
+package main
+
+// flagValue is a flag.Value that stores the value of the flag in values by the name of the flag,
+// so that only the flags given on the command line override the other sources.
+type flagValue struct {
+	name   string
+	values map[string]string
+	def    string
+	bool   bool
+}
+
+func (f flagValue) String() string {
+	if value, ok := f.values[f.name]; ok {
+		return value
+	}
+	return f.def
+}
+
+func (f flagValue) Set(value string) error {
+	f.values[f.name] = value
+	return nil
+}
+
+// IsBoolFlag lets the boolean flags be given without a value.
+func (f flagValue) IsBoolFlag() bool {
+	return f.bool
+}
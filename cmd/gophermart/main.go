@@ -1,23 +1,84 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/kerelape/gophermart/internal/gophermart"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
 	"github.com/pior/runnable"
+	"golang.org/x/exp/slog"
+	"io"
 	"log"
+	"os"
 )
 
 func main() {
-	config, parseConfigError := ParseConfig()
-	if parseConfigError != nil {
-		log.Fatal(parseConfigError)
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "print" {
+		configPrint(os.Args[3:])
+		return
 	}
+	if c, args, ok := findCommand(os.Args[1:]); ok {
+		os.Exit(runCommand(c, args))
+	}
+	if len(os.Args) > 1 && isCommandGroup(os.Args[1]) {
+		printCommands(os.Stderr)
+		os.Exit(2)
+	}
+
+	config, loadConfigError := LoadConfig(os.Args[0], os.Args[1:], os.Stderr)
+	if errors.Is(loadConfigError, flag.ErrHelp) {
+		return
+	}
+	if loadConfigError != nil {
+		log.Fatal(loadConfigError)
+	}
+
+	logLevel := &slog.LevelVar{}
+	logLevel.Set(config.LogLevel)
+	logger, loggerError := logging.New(os.Stderr, logLevel, config.LogFormat)
+	if loggerError != nil {
+		log.Fatal(loggerError)
+	}
+	slog.SetDefault(logger)
+	runnable.SetLogger(slog.NewLogLogger(logger.Handler(), slog.LevelInfo))
 
-	runnable.Run(
-		gophermart.New(
-			config.AddressRun,
-			config.AddressAccrualSystem,
-			config.AddressDatabase,
-			config.JWTSecretKey,
-		),
-	)
+	service := gophermart.New(config.Gophermart())
+	service.LogLevel = logLevel
+	service.Source = func() (gophermart.Config, error) {
+		// The flags and the environment are the same, so the changes come from the config file.
+		reloaded, reloadError := LoadConfig(os.Args[0], os.Args[1:], io.Discard)
+		if reloadError != nil {
+			return gophermart.Config{}, reloadError
+		}
+		return reloaded.Gophermart(), nil
+	}
+	runnable.Run(service)
+}
+
+// configPrint prints the effective config, loaded from the args as gophermart loads it,
+// and then reports the invalid settings, if there are any.
+func configPrint(args []string) {
+	config := Config{}
+	flags := flag.NewFlagSet(os.Args[0]+" config print", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	line, parseError := parseCommandLine(&config, flags, args)
+	if errors.Is(parseError, flag.ErrHelp) {
+		return
+	}
+	if parseError != nil {
+		os.Exit(2)
+	}
+	if len(line.args) > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", line.args)
+		os.Exit(2)
+	}
+	configError := errors.Join(config.load(line), config.validate())
+	if err := printConfig(os.Stdout, &config); err != nil {
+		log.Fatal(err)
+	}
+	if configError != nil {
+		fmt.Fprintln(os.Stderr, configError)
+		os.Exit(1)
+	}
 }
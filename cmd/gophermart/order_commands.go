@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+)
+
+// recheckedOrder is the result of order recheck.
+type recheckedOrder struct {
+	Order  string          `json:"order"`
+	Status idp.OrderStatus `json:"status"`
+}
+
+func orderRecheck() command {
+	return command{
+		name:  "order recheck",
+		args:  "<order>",
+		usage: "Makes an order that has not been processed new again, so that the running service asks the accrual system for it once more.",
+		run: func(ctx context.Context, database gophermart.Database, args []string) (result, error) {
+			if len(args) != 1 {
+				return result{}, errUsage
+			}
+			order := args[0]
+			switch err := database.RecheckOrder(ctx, order); {
+			case errors.Is(err, idp.ErrUnknownOrder):
+				return result{}, fmt.Errorf("unknown order %q", order)
+			case errors.Is(err, idp.ErrOrderProcessed):
+				return result{}, fmt.Errorf("order %q has been processed, its accrual is already credited", order)
+			case err != nil:
+				return result{}, err
+			}
+			rechecked := recheckedOrder{Order: order, Status: idp.OrderStatusNew}
+			return fields(rechecked, []string{"order", "status"}, rechecked.Order, string(rechecked.Status)), nil
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// formatTable prints the result as a table with a header row.
+	formatTable = "table"
+
+	// formatJSON prints the result as indented JSON.
+	formatJSON = "json"
+)
+
+// result is the output of a command, value is printed as JSON
+// and header and rows as a table.
+type result struct {
+	value  any
+	header []string
+	rows   [][]string
+}
+
+// fields returns the result of a single record listed as the rows of its fields and values,
+// the fields are the keys of value in the same order.
+func fields(value any, names []string, values ...string) result {
+	rows := make([][]string, len(names))
+	for i, name := range names {
+		rows[i] = []string{name, values[i]}
+	}
+	return result{value: value, header: []string{"FIELD", "VALUE"}, rows: rows}
+}
+
+func (r result) write(out io.Writer, format string) error {
+	if format == formatJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r.value)
+	}
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(r.header, "\t"))
+	for _, row := range r.rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+func formatPoints(points float64) string {
+	return strconv.FormatFloat(points, 'f', -1, 64)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
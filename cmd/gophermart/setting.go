@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redacted replaces the secrets in the printed config.
+const redacted = "xxxxx"
+
+// dsnPassword matches the password of a key=value connection string.
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// setting is a field of Config described by its tags:
+//
+//   - env is the environment variable, its lower case is the key in the config file;
+//   - flag is the command line flag;
+//   - usage is the description of the flag;
+//   - default is the value of the setting if it is not set;
+//   - required reports that the setting must not be empty;
+//   - secret is "true" if the setting is redacted when printed, or "password" if only
+//     the password of the dsn is. The secrets may be read from the files named by
+//     the _FILE environment variable, the -file flag or the _file key.
+type setting struct {
+	field    reflect.Value
+	env      string
+	flag     string
+	usage    string
+	def      string
+	required bool
+	secret   string
+}
+
+// settingsOf returns the settings of the config, in the order of the fields.
+func settingsOf(config *Config) []setting {
+	value := reflect.ValueOf(config).Elem()
+	settings := make([]setting, 0, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		env, ok := field.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		settings = append(settings, setting{
+			field:    value.Field(i),
+			env:      env,
+			flag:     field.Tag.Get("flag"),
+			usage:    field.Tag.Get("usage"),
+			def:      field.Tag.Get("default"),
+			required: field.Tag.Get("required") == "true",
+			secret:   field.Tag.Get("secret"),
+		})
+	}
+	return settings
+}
+
+// key returns the key of the setting in the config file.
+func (s setting) key() string {
+	return strings.ToLower(s.env)
+}
+
+// fileFlag returns the flag of the file the secret is read from, or "" if the setting is not a secret.
+func (s setting) fileFlag() string {
+	if s.secret == "" {
+		return ""
+	}
+	return strings.ReplaceAll(s.key(), "_", "-") + "-file"
+}
+
+func (s setting) String() string {
+	return fmt.Sprintf("%s (-%s|%s)", s.key(), s.flag, s.env)
+}
+
+// define defines the flags of the setting, their values are stored in values by the flag names.
+func (s setting) define(flags *flag.FlagSet, values map[string]string) {
+	usage := s.usage + " (env " + s.env + ")"
+	flags.Var(flagValue{name: s.flag, values: values, def: s.def, bool: s.isBool()}, s.flag, usage)
+	if s.secret != "" {
+		flags.Var(flagValue{name: s.fileFlag(), values: values}, s.fileFlag(), "File "+s.key()+" is read from (env "+s.env+"_FILE)")
+	}
+}
+
+func (s setting) isBool() bool {
+	return s.field.Kind() == reflect.Bool
+}
+
+// load sets the setting to value, or to the content of the file if the setting is a secret,
+// nothing is set if both are empty. The errors name the source of the value.
+func (s setting) load(value, file, source string) error {
+	if s.secret == "" {
+		file = ""
+	}
+	switch {
+	case value != "" && file != "":
+		return fmt.Errorf("both %s and its file are set", source)
+	case file != "":
+		content, readError := os.ReadFile(file)
+		if readError != nil {
+			return fmt.Errorf("%w (%s)", readError, source)
+		}
+		value = strings.TrimRight(string(content), "\r\n")
+	case value == "":
+		return nil
+	}
+	if err := s.set(value); err != nil {
+		return fmt.Errorf("%w (%s)", err, source)
+	}
+	return nil
+}
+
+// set parses the value into the field.
+func (s setting) set(value string) error {
+	switch target := s.field.Addr().Interface().(type) {
+	case encoding.TextUnmarshaler:
+		return target.UnmarshalText([]byte(value))
+	case *string:
+		*target = value
+	case *bool:
+		if value == "" {
+			*target = false
+			return nil
+		}
+		parsed, parseError := strconv.ParseBool(value)
+		if parseError != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*target = parsed
+	case *int:
+		parsed, parseError := strconv.Atoi(value)
+		if parseError != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*target = parsed
+	case *time.Duration:
+		parsed, parseError := time.ParseDuration(value)
+		if parseError != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*target = parsed
+	default:
+		return fmt.Errorf("unsupported setting type %T", target)
+	}
+	return nil
+}
+
+// value returns the value of the setting as it is written in the config file.
+func (s setting) value() any {
+	switch value := s.field.Interface().(type) {
+	case encoding.TextMarshaler:
+		text, _ := value.MarshalText()
+		return string(text)
+	case time.Duration:
+		return value.String()
+	default:
+		return value
+	}
+}
+
+// redactedValue returns the value of the setting with the secret redacted.
+func (s setting) redactedValue() any {
+	value := s.value()
+	text, isString := value.(string)
+	if !isString || text == "" {
+		return value
+	}
+	switch s.secret {
+	case "true":
+		return redacted
+	case "password":
+		if parsed, parseError := url.Parse(text); parseError == nil && parsed.Scheme != "" {
+			if _, hasPassword := parsed.User.Password(); hasPassword {
+				return parsed.Redacted()
+			}
+			return text
+		}
+		return dsnPassword.ReplaceAllString(text, "${1}"+redacted)
+	}
+	return value
+}
+
+// validate checks that a required setting is set and that a number or a duration is positive.
+func (s setting) validate() error {
+	switch value := s.field.Interface().(type) {
+	case string:
+		if s.required && value == "" {
+			return fmt.Errorf("missing %s", s)
+		}
+	case int:
+		if value <= 0 {
+			return fmt.Errorf("%s must be positive", s)
+		}
+	case time.Duration:
+		if value <= 0 {
+			return fmt.Errorf("%s must be positive", s)
+		}
+	}
+	return nil
+}
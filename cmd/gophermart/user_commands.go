@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// credentials is the result of the commands setting a password,
+// the password is only printed if it has been generated.
+type credentials struct {
+	ID       int64  `json:"id"`
+	Login    string `json:"login"`
+	Password string `json:"password,omitempty"`
+}
+
+func (c credentials) result() result {
+	names := []string{"id", "login"}
+	values := []string{strconv.FormatInt(c.ID, 10), c.Login}
+	if c.Password != "" {
+		names = append(names, "password")
+		values = append(values, c.Password)
+	}
+	return fields(c, names, values...)
+}
+
+func userCreate() command {
+	var passwordStdin bool
+	return command{
+		name:  "user create",
+		args:  "<login>",
+		usage: "Creates a user with a generated password, which is printed, or with the password read from the standard input.",
+		define: func(flags *flag.FlagSet) {
+			flags.BoolVar(&passwordStdin, "password-stdin", false, "Read the password from the standard input")
+		},
+		run: func(ctx context.Context, database gophermart.Database, args []string) (result, error) {
+			if len(args) != 1 || args[0] == "" {
+				return result{}, errUsage
+			}
+			login := args[0]
+			secret, generated, passwordError := password(passwordStdin)
+			if passwordError != nil {
+				return result{}, passwordError
+			}
+			if err := database.Create(ctx, login, secret); err != nil {
+				if errors.Is(err, idp.ErrDuplicateUsername) {
+					return result{}, fmt.Errorf("user %q already exists", login)
+				}
+				return result{}, err
+			}
+			identity, findError := findUser(ctx, database, login)
+			if findError != nil {
+				return result{}, findError
+			}
+			created := credentials{ID: identity.ID(), Login: login}
+			if generated {
+				created.Password = secret
+			}
+			return created.result(), nil
+		},
+	}
+}
+
+func userResetPassword() command {
+	var passwordStdin bool
+	return command{
+		name:  "user reset-password",
+		args:  "<login>",
+		usage: "Sets a generated password, which is printed, or the password read from the standard input. The tokens issued before are revoked.",
+		define: func(flags *flag.FlagSet) {
+			flags.BoolVar(&passwordStdin, "password-stdin", false, "Read the password from the standard input")
+		},
+		run: func(ctx context.Context, database gophermart.Database, args []string) (result, error) {
+			if len(args) != 1 {
+				return result{}, errUsage
+			}
+			identity, findError := findUser(ctx, database, args[0])
+			if findError != nil {
+				return result{}, findError
+			}
+			secret, generated, passwordError := password(passwordStdin)
+			if passwordError != nil {
+				return result{}, passwordError
+			}
+			if err := identity.SetPassword(ctx, secret); err != nil {
+				return result{}, err
+			}
+			reset := credentials{ID: identity.ID(), Login: args[0]}
+			if generated {
+				reset.Password = secret
+			}
+			return reset.result(), nil
+		},
+	}
+}
+
+// userSummary is the result of user show.
+type userSummary struct {
+	ID          int64                   `json:"id"`
+	Login       string                  `json:"login"`
+	Balance     float64                 `json:"balance"`
+	Withdrawn   float64                 `json:"withdrawn"`
+	Orders      map[idp.OrderStatus]int `json:"orders"`
+	Withdrawals int                     `json:"withdrawals"`
+	Adjustments int                     `json:"adjustments"`
+	Webhooks    int                     `json:"webhooks"`
+	Revision    int64                   `json:"revision"`
+	Modified    *time.Time              `json:"modified,omitempty"`
+}
+
+func userShow() command {
+	return command{
+		name:  "user show",
+		args:  "<login>",
+		usage: "Prints the balance of the user and the numbers of the orders by status, the withdrawals, the adjustments and the webhooks.",
+		run: func(ctx context.Context, database gophermart.Database, args []string) (result, error) {
+			if len(args) != 1 {
+				return result{}, errUsage
+			}
+			identity, findError := findUser(ctx, database, args[0])
+			if findError != nil {
+				return result{}, findError
+			}
+			balance, balanceError := identity.Balance(ctx)
+			if balanceError != nil {
+				return result{}, balanceError
+			}
+			orders, ordersError := identity.Orders(ctx, idp.OrderQuery{})
+			if ordersError != nil {
+				return result{}, ordersError
+			}
+			withdrawals, withdrawalsError := identity.Withdrawals(ctx, idp.ListQuery{})
+			if withdrawalsError != nil {
+				return result{}, withdrawalsError
+			}
+			adjustments, adjustmentsError := database.Adjustments(ctx, identity.ID())
+			if adjustmentsError != nil {
+				return result{}, adjustmentsError
+			}
+			webhooks, webhooksError := identity.Webhooks(ctx)
+			if webhooksError != nil {
+				return result{}, webhooksError
+			}
+			revision, revisionError := identity.Revision(ctx)
+			if revisionError != nil {
+				return result{}, revisionError
+			}
+
+			statuses := []idp.OrderStatus{idp.OrderStatusNew, idp.OrderStatusProcessing, idp.OrderStatusInvalid, idp.OrderStatusProcessed}
+			summary := userSummary{
+				ID:          identity.ID(),
+				Login:       args[0],
+				Balance:     balance.Current,
+				Withdrawn:   balance.Withdrawn,
+				Orders:      make(map[idp.OrderStatus]int, len(statuses)),
+				Withdrawals: len(withdrawals),
+				Adjustments: len(adjustments),
+				Webhooks:    len(webhooks),
+				Revision:    revision.Number,
+			}
+			for _, status := range statuses {
+				summary.Orders[status] = 0
+			}
+			for _, order := range orders {
+				summary.Orders[order.Status]++
+			}
+			modified := ""
+			if !revision.Time.IsZero() {
+				summary.Modified = &revision.Time
+				modified = formatTime(revision.Time)
+			}
+
+			counts := make([]string, len(statuses))
+			for i, status := range statuses {
+				counts[i] = fmt.Sprintf("%s %d", status, summary.Orders[status])
+			}
+			return fields(
+				summary,
+				[]string{"id", "login", "balance", "withdrawn", "orders", "withdrawals", "adjustments", "webhooks", "revision", "modified"},
+				strconv.FormatInt(summary.ID, 10),
+				summary.Login,
+				formatPoints(summary.Balance),
+				formatPoints(summary.Withdrawn),
+				strings.Join(counts, ", "),
+				strconv.Itoa(summary.Withdrawals),
+				strconv.Itoa(summary.Adjustments),
+				strconv.Itoa(summary.Webhooks),
+				strconv.FormatInt(summary.Revision, 10),
+				modified,
+			), nil
+		},
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,9 +16,20 @@ import (
 
 var ErrUnknownOrder = errors.New("unknown order")
 
+const (
+	// circuitThreshold is the number of consecutive failures that open the circuit.
+	circuitThreshold = 5
+
+	// circuitCooldown is how long the circuit stays open.
+	circuitCooldown = 30 * time.Second
+)
+
 type Accrual struct {
 	Address string
 	Client  *http.Client
+
+	// Circuit stops the requests while the accrual system is failing, it is not used if nil.
+	Circuit *Circuit
 }
 
 // New creates a new Accrual.
@@ -22,10 +37,32 @@ func New(address string, client *http.Client) Accrual {
 	return Accrual{
 		Address: address,
 		Client:  client,
+		Circuit: NewCircuit(circuitThreshold, circuitCooldown),
 	}
 }
 
-func (a Accrual) OrderInfo(ctx context.Context, order string) (OrderInfo, error) {
+// tracer starts the spans of the requests to the accrual system.
+var tracer = otel.Tracer("github.com/kerelape/gophermart/internal/accrual")
+
+func (a Accrual) OrderInfo(ctx context.Context, order string) (_ OrderInfo, err error) {
+	ctx, span := tracer.Start(ctx, "accrual.OrderInfo", trace.WithAttributes(attribute.String("order", order)))
+	defer func() {
+		// An unknown order is an answer of the accrual system, not a failure.
+		if err != nil && !errors.Is(err, ErrUnknownOrder) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	if a.Circuit != nil {
+		if !a.Circuit.allow() {
+			return OrderInfo{}, ErrCircuitOpen
+		}
+		defer func() {
+			a.Circuit.record(err)
+		}()
+	}
+
 	out, outError := http.NewRequestWithContext(ctx, http.MethodGet, a.Address+"/api/orders/"+order, nil)
 	if outError != nil {
 		return OrderInfo{}, outError
@@ -0,0 +1,99 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of requesting the accrual system while the circuit is open.
+var ErrCircuitOpen = errors.New("the accrual circuit is open")
+
+type CircuitState string
+
+const (
+	// CircuitClosed lets the requests through.
+	CircuitClosed = CircuitState("closed")
+
+	// CircuitOpen fails the requests without making them.
+	CircuitOpen = CircuitState("open")
+
+	// CircuitHalfOpen lets a single request through to probe the accrual system.
+	CircuitHalfOpen = CircuitState("half-open")
+)
+
+// Circuit stops the requests to the accrual system for Cooldown after Threshold
+// consecutive failures, then lets a single request through, which closes the circuit
+// if it succeeds and opens it again if it fails.
+type Circuit struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuit creates a new Circuit.
+func NewCircuit(threshold int, cooldown time.Duration) *Circuit {
+	return &Circuit{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// State returns the state of the circuit.
+func (c *Circuit) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state(time.Now())
+}
+
+// allow reports whether a request may be made, the request must be recorded if it may.
+func (c *Circuit) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state(time.Now()) {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if c.probing {
+			return false
+		}
+		c.probing = true
+	}
+	return true
+}
+
+// record records the outcome of a request allowed by allow.
+//
+// The answers of the accrual system, including the unknown orders and the requests
+// to slow down, close the circuit, the canceled requests are not counted.
+func (c *Circuit) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	tooManyRequestsError := new(TooManyRequestsError)
+	switch {
+	case err == nil, errors.Is(err, ErrUnknownOrder), errors.As(err, tooManyRequestsError):
+		c.failures = 0
+	case errors.Is(err, context.Canceled):
+	default:
+		c.failures++
+		if c.failures >= c.Threshold {
+			c.openedAt = time.Now()
+		}
+	}
+}
+
+func (c *Circuit) state(now time.Time) CircuitState {
+	if c.failures < c.Threshold {
+		return CircuitClosed
+	}
+	if now.Sub(c.openedAt) < c.Cooldown {
+		return CircuitOpen
+	}
+	return CircuitHalfOpen
+}
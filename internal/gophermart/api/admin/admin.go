@@ -0,0 +1,55 @@
+// Package admin serves the administrative endpoints, which are not a part of the public API
+// and are served on an address of their own.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"github.com/kerelape/gophermart/internal/gophermart/reload"
+	"net/http"
+)
+
+type Admin struct {
+	reload func(ctx context.Context) ([]reload.Change, error)
+}
+
+// New creates a new Admin reloading the configuration with reload.
+func New(reload func(ctx context.Context) ([]reload.Change, error)) Admin {
+	return Admin{
+		reload: reload,
+	}
+}
+
+// reloadResult is the body of the responses to the reloads.
+type reloadResult struct {
+	Changes []reload.Change `json:"changes"`
+	Error   string          `json:"error,omitempty"`
+}
+
+func (a Admin) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Post("/reload", a.Reload)
+	return router
+}
+
+// Reload reloads the configuration and answers 200 OK with the changes,
+// or 422 Unprocessable Entity if the configuration is invalid and has not been reloaded.
+func (a Admin) Reload(out http.ResponseWriter, in *http.Request) {
+	changes, reloadError := a.reload(in.Context())
+	if reloadError != nil {
+		write(out, in, http.StatusUnprocessableEntity, reloadResult{Changes: []reload.Change{}, Error: reloadError.Error()})
+		return
+	}
+	write(out, in, http.StatusOK, reloadResult{Changes: changes})
+}
+
+func write(out http.ResponseWriter, in *http.Request, status int, body any) {
+	out.Header().Set("Content-Type", "application/json")
+	out.Header().Set("Cache-Control", "no-store")
+	out.WriteHeader(status)
+	if err := json.NewEncoder(out).Encode(body); err != nil {
+		logging.FromContext(in.Context()).Error("failed to write admin response", "error", err)
+	}
+}
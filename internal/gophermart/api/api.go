@@ -2,40 +2,183 @@ package api
 
 import (
 	"context"
-	"github.com/go-chi/chi/v5/middleware"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/admin"
+	"github.com/kerelape/gophermart/internal/gophermart/api/health"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/clientip"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/decompression"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc"
 	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"github.com/kerelape/gophermart/internal/gophermart/metrics"
+	"github.com/kerelape/gophermart/internal/gophermart/tracing"
 	"github.com/pior/runnable"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type API struct {
-	rest rest.REST
+	rest   rest.REST
+	rpc    rpc.RPC
+	health health.Health
 
 	ServerAddress string
+
+	// GRPCServerAddress is the address of the gRPC server,
+	// the gRPC server is not run if it is empty.
+	GRPCServerAddress string
+
+	// GRPCReflection registers the gRPC server reflection.
+	GRPCReflection bool
+
+	// ReadTimeout and IdleTimeout are the timeouts of the HTTP server, see http.Server.
+	ReadTimeout time.Duration
+	IdleTimeout time.Duration
+
+	// Admin is served on AdminAddress, the admin server is not run if it is empty.
+	Admin        admin.Admin
+	AdminAddress string
+
+	// TLS is the TLS configuration of the servers, they serve plain HTTP and gRPC if it is nil.
+	TLS *tls.Config
+
+	// ClientCAs verify the client certificates, which are required by the admin server
+	// if ClientCAs is not nil. The client certificates are verified only over TLS.
+	ClientCAs *x509.CertPool
+
+	// AdminToken is the bearer token of the operators. The admin server and /metrics
+	// are served to the requests with a verified client certificate or the token,
+	// or only to the loopback clients if neither ClientCAs nor AdminToken is set.
+	AdminToken string
+
+	// RedirectAddress is the address of the server redirecting the plain HTTP requests to HTTPS,
+	// it is not run if it is empty or TLS is nil.
+	RedirectAddress string
 }
 
 // New creates a new API.
-func New(idp idp.IdentityProvider, address string) API {
+func New(
+	idp idp.IdentityProvider,
+	broker idp.Broker,
+	store idp.IdempotencyStore,
+	adjustments idp.AdjustmentHistory,
+	idempotencyWindow time.Duration,
+	rateLimiters user.RateLimiters,
+	clientIP clientip.Resolver,
+	database idp.Health,
+	circuit *accrual.Circuit,
+	address, grpcAddress string,
+	testMode bool,
+) API {
 	return API{
-		rest: rest.New(idp),
+		rest:   rest.New(idp, broker, store, adjustments, idempotencyWindow, maxRequestSize, rateLimiters, clientIP, testMode),
+		rpc:    rpc.New(idp, rateLimiters),
+		health: health.New(database, circuit),
 
-		ServerAddress: address,
+		ServerAddress:     address,
+		GRPCServerAddress: grpcAddress,
 	}
 }
 
 func (a API) Run(ctx context.Context) error {
-	router := chi.NewRouter().Group(func(router chi.Router) {
-		router.Use(middleware.Logger)
+	router := chi.NewRouter()
+	// The probes are not logged, traced nor counted, they are made every few seconds.
+	router.Get("/healthz", a.health.Live)
+	router.Get("/readyz", a.health.Ready)
+	router.Group(func(router chi.Router) {
+		router.Use(metrics.HTTP)
+		router.Use(tracing.Middleware)
+		router.Use(logging.Middleware)
+		router.Use(compression())
+		router.Use(limitRequestSize(maxRequestSize))
+		router.Use(decompression.Decompression(maxRequestSize))
 		router.Mount("/api", a.rest.Route())
+		router.With(a.operator).Handle("/metrics", metrics.Handler())
 	})
-	server := http.Server{
-		Addr:    a.ServerAddress,
-		Handler: router,
-	}
+	serverTLS, adminTLS := a.tlsConfigs()
 	manager := runnable.NewManager()
-	manager.Add(runnable.HTTPServer(&server))
+	manager.Add(httpServer(&http.Server{
+		Addr:        a.ServerAddress,
+		Handler:     router,
+		ReadTimeout: a.ReadTimeout,
+		IdleTimeout: a.IdleTimeout,
+		TLSConfig:   serverTLS,
+	}))
+	if a.AdminAddress != "" {
+		adminRouter := chi.NewRouter()
+		adminRouter.Use(logging.Middleware)
+		adminRouter.Use(a.operator)
+		adminRouter.Mount("/", a.Admin.Route())
+		manager.Add(httpServer(&http.Server{
+			Addr:        a.AdminAddress,
+			Handler:     adminRouter,
+			ReadTimeout: a.ReadTimeout,
+			IdleTimeout: a.IdleTimeout,
+			TLSConfig:   adminTLS,
+		}))
+	}
+	if a.RedirectAddress != "" && a.TLS != nil {
+		manager.Add(runnable.HTTPServer(&http.Server{
+			Addr:        a.RedirectAddress,
+			Handler:     redirect(a.ServerAddress),
+			ReadTimeout: a.ReadTimeout,
+			IdleTimeout: a.IdleTimeout,
+		}))
+	}
+	if a.GRPCServerAddress != "" {
+		options := make([]grpc.ServerOption, 0)
+		if a.TLS != nil {
+			options = append(options, grpc.Creds(credentials.NewTLS(a.TLS)))
+		}
+		a.rpc.Reflection = a.GRPCReflection
+		manager.Add(grpcServer(a.rpc.Server(options...), a.GRPCServerAddress))
+	}
 	return manager.Build().Run(ctx)
 }
+
+// tlsConfigs returns the TLS configurations of the server and of the admin server,
+// the client certificates are optional for the server and required by the admin server.
+func (a API) tlsConfigs() (server, admin *tls.Config) {
+	if a.TLS == nil {
+		return nil, nil
+	}
+	server, admin = a.TLS.Clone(), a.TLS.Clone()
+	if a.ClientCAs != nil {
+		server.ClientCAs, server.ClientAuth = a.ClientCAs, tls.VerifyClientCertIfGiven
+		admin.ClientCAs, admin.ClientAuth = a.ClientCAs, tls.RequireAndVerifyClientCert
+	}
+	return server, admin
+}
+
+// grpcServer serves the gRPC server on the address until the context is done,
+// then stops it gracefully.
+func grpcServer(server *grpc.Server, address string) runnable.Runnable {
+	return runnable.Func(func(ctx context.Context) error {
+		listener, listenError := net.Listen("tcp", address)
+		if listenError != nil {
+			return listenError
+		}
+
+		serveError := make(chan error, 1)
+		go func() {
+			serveError <- server.Serve(listener)
+		}()
+
+		select {
+		case err := <-serveError:
+			return err
+		case <-ctx.Done():
+			server.GracefulStop()
+			return <-serveError
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"github.com/andybalholm/brotli"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+)
+
+const (
+	// compressionLevel is the level of the response compression, from 1 (fastest) to 9 (smallest).
+	compressionLevel = 5
+
+	// maxRequestSize is the maximum size of a request body, before and after the decompression,
+	// and of a body read whole by the idempotency middleware.
+	maxRequestSize = 1 << 20
+)
+
+// compressibleContentTypes are the content types of the responses that are compressed,
+// the event streams are not as they are flushed event by event.
+var compressibleContentTypes = []string{
+	"application/json",
+	"application/problem+json",
+	"text/plain",
+	"text/csv",
+}
+
+// compression returns a middleware that compresses the responses with brotli,
+// zstd, gzip or deflate, whichever the client accepts in that order.
+func compression() func(http.Handler) http.Handler {
+	compressor := middleware.NewCompressor(compressionLevel, compressibleContentTypes...)
+	// The encoders set later take precedence.
+	compressor.SetEncoder("zstd", func(w io.Writer, level int) io.Writer {
+		encoder, err := zstd.NewWriter(
+			w,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderConcurrency(1),
+		)
+		if err != nil {
+			return nil
+		}
+		return encoder
+	})
+	compressor.SetEncoder("br", func(w io.Writer, level int) io.Writer {
+		return brotli.NewWriterLevel(w, level)
+	})
+	return compressor.Handler
+}
@@ -0,0 +1,75 @@
+// Package health answers the liveness and the readiness probes of the orchestrators.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"net/http"
+	"time"
+)
+
+// checkTimeout is how long the database is checked for.
+const checkTimeout = 2 * time.Second
+
+type Health struct {
+	database idp.Health
+	circuit  *accrual.Circuit
+}
+
+// New creates a new Health reporting the state of the database
+// and of the circuit of the accrual system, which may be nil.
+func New(database idp.Health, circuit *accrual.Circuit) Health {
+	return Health{
+		database: database,
+		circuit:  circuit,
+	}
+}
+
+// readiness is the body of the responses to the readiness probes.
+type readiness struct {
+	Ready    bool   `json:"ready"`
+	Database string `json:"database"`
+	Accrual  string `json:"accrual,omitempty"`
+}
+
+// Live answers 200 OK as long as the process serves the requests.
+func (h Health) Live(out http.ResponseWriter, in *http.Request) {
+	write(out, in, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Ready answers 200 OK if the database is reachable and migrated,
+// and 503 Service Unavailable otherwise.
+//
+// The state of the circuit of the accrual system is reported but does not
+// make the service unready, since the users are served while it is open.
+func (h Health) Ready(out http.ResponseWriter, in *http.Request) {
+	ctx, cancel := context.WithTimeout(in.Context(), checkTimeout)
+	defer cancel()
+
+	body := readiness{Ready: true, Database: "ok"}
+	if err := h.database.Check(ctx); err != nil {
+		body.Ready = false
+		body.Database = err.Error()
+	}
+	if h.circuit != nil {
+		body.Accrual = string(h.circuit.State())
+	}
+
+	status := http.StatusOK
+	if !body.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	write(out, in, status, body)
+}
+
+func write(out http.ResponseWriter, in *http.Request, status int, body any) {
+	out.Header().Set("Content-Type", "application/json")
+	out.Header().Set("Cache-Control", "no-store")
+	out.WriteHeader(status)
+	if err := json.NewEncoder(out).Encode(body); err != nil {
+		logging.FromContext(in.Context()).Error("failed to write health", "error", err)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pior/runnable"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout is how long the requests in progress are waited for on shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// httpServer serves the server until the context is done, then shuts it down gracefully.
+//
+// The server serves HTTPS if its TLSConfig is not nil, the certificates are taken
+// from the TLSConfig, and plain HTTP otherwise.
+func httpServer(server *http.Server) runnable.Runnable {
+	if server.TLSConfig == nil {
+		return runnable.HTTPServer(server)
+	}
+	return runnable.Func(func(ctx context.Context) error {
+		serveError := make(chan error, 1)
+		go func() {
+			slog.Info("https server listening", "address", server.Addr)
+			serveError <- server.ListenAndServeTLS("", "")
+		}()
+
+		var err error
+		select {
+		case <-ctx.Done():
+			slog.Info("https server shutting down", "address", server.Addr)
+			err = shutdown(server)
+			if serveErr := <-serveError; !errors.Is(serveErr, http.ErrServerClosed) && err == nil {
+				err = serveErr
+			}
+		case err = <-serveError:
+			if shutdownError := shutdown(server); shutdownError != nil {
+				slog.Error("failed to shut the https server down", "address", server.Addr, "error", shutdownError)
+			}
+		}
+		return err
+	})
+}
+
+func shutdown(server *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("server shutdown: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// operator authorizes the requests of the operators to the admin server and to /metrics.
+//
+// A request is authorized by a verified client certificate if ClientCAs is set,
+// or by the bearer AdminToken if it is set; if neither is, only the requests
+// made from the loopback addresses are, except the ones forwarded by a proxy.
+func (a API) operator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		switch {
+		case a.authorizedOperator(in):
+			next.ServeHTTP(out, in)
+		case a.AdminToken != "":
+			out.Header().Set("WWW-Authenticate", `Bearer realm="gophermart"`)
+			http.Error(out, "admin token required", http.StatusUnauthorized)
+		case a.ClientCAs != nil:
+			http.Error(out, "client certificate required", http.StatusForbidden)
+		default:
+			http.Error(out, "only served to the loopback clients", http.StatusForbidden)
+		}
+	})
+}
+
+func (a API) authorizedOperator(in *http.Request) bool {
+	if a.ClientCAs != nil && in.TLS != nil && len(in.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if a.AdminToken != "" {
+		token, found := strings.CutPrefix(in.Header.Get("Authorization"), "Bearer ")
+		return found && subtle.ConstantTimeCompare([]byte(token), []byte(a.AdminToken)) == 1
+	}
+	if a.ClientCAs != nil || in.Header.Get("X-Forwarded-For") != "" || in.Header.Get("Forwarded") != "" {
+		return false
+	}
+	remote, parseError := netip.ParseAddrPort(in.RemoteAddr)
+	return parseError == nil && remote.Addr().Unmap().IsLoopback()
+}
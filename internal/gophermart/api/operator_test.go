@@ -0,0 +1,51 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOperator(t *testing.T) {
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	tests := []struct {
+		name   string
+		api    API
+		remote string
+		header http.Header
+		tls    *tls.ConnectionState
+		status int
+	}{
+		{"Loopback", API{}, "127.0.0.1:1234", nil, nil, http.StatusOK},
+		{"LoopbackIPv6", API{}, "[::1]:1234", nil, nil, http.StatusOK},
+		{"Remote", API{}, "192.0.2.1:1234", nil, nil, http.StatusForbidden},
+		{"Proxied", API{}, "127.0.0.1:1234", http.Header{"X-Forwarded-For": {"192.0.2.1"}}, nil, http.StatusForbidden},
+		{"Token", API{AdminToken: "secret"}, "192.0.2.1:1234", http.Header{"Authorization": {"Bearer secret"}}, nil, http.StatusOK},
+		{"WrongToken", API{AdminToken: "secret"}, "192.0.2.1:1234", http.Header{"Authorization": {"Bearer wrong"}}, nil, http.StatusUnauthorized},
+		{"LoopbackWithoutToken", API{AdminToken: "secret"}, "127.0.0.1:1234", nil, nil, http.StatusUnauthorized},
+		{"Certificate", API{ClientCAs: x509.NewCertPool()}, "192.0.2.1:1234", nil, verified, http.StatusOK},
+		{"NoCertificate", API{ClientCAs: x509.NewCertPool()}, "127.0.0.1:1234", nil, nil, http.StatusForbidden},
+		{"CertificateOrToken", API{ClientCAs: x509.NewCertPool(), AdminToken: "secret"}, "192.0.2.1:1234", http.Header{"Authorization": {"Bearer secret"}}, nil, http.StatusOK},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			handler := test.api.operator(http.HandlerFunc(func(out http.ResponseWriter, _ *http.Request) {
+				out.WriteHeader(http.StatusOK)
+			}))
+			request := httptest.NewRequest(http.MethodPost, "/reload", nil)
+			request.RemoteAddr = test.remote
+			for name, values := range test.header {
+				request.Header[name] = values
+			}
+			request.TLS = test.tls
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// redirect redirects the requests to the same URLs over HTTPS on the port of the server address.
+func redirect(serverAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(serverAddress)
+	return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		host := strings.Trim(in.Host, "[]")
+		if hostname, _, splitError := net.SplitHostPort(in.Host); splitError == nil {
+			host = hostname
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(out, in, "https://"+host+in.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"net/http"
+)
+
+// limitRequestSize returns a middleware that limits the request bodies to maxSize bytes,
+// so that none of them is buffered whole beyond it, e.g. by the validation of the requests.
+// The requests declaring a larger Content-Length are answered with 413 Request Entity Too Large
+// right away, the reads of the larger chunked bodies fail with *http.MaxBytesError.
+func limitRequestSize(maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+			if in.ContentLength > maxSize {
+				problem.Write(out, in, problem.CodeRequestTooLarge, fmt.Sprintf("the body must be at most %d bytes", maxSize))
+				return
+			}
+			if in.Body != nil && in.Body != http.NoBody {
+				in.Body = http.MaxBytesReader(out, in.Body, maxSize)
+			}
+			next.ServeHTTP(out, in)
+		})
+	}
+}
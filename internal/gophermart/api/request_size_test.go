@@ -0,0 +1,57 @@
+package api
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/openapi"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem/problemtest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimitRequestSize(t *testing.T) {
+	const maxSize = 64
+	register := `{"login":"alice","password":"password"}`
+	large := `{"login":"alice","password":"` + strings.Repeat("a", maxSize) + `"}`
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{"Small", register, false, http.StatusNoContent},
+		{"Large", large, false, http.StatusRequestEntityTooLarge},
+		{"LargeChunked", large, true, http.StatusRequestEntityTooLarge},
+	}
+	next := http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		if _, err := io.ReadAll(in.Body); err != nil {
+			t.Errorf("the body of a valid request failed to read: %v", err)
+		}
+		out.WriteHeader(http.StatusNoContent)
+	})
+	// The validation reads the bodies whole before the authorization and the handlers.
+	handler := limitRequestSize(maxSize)(openapi.New(false).Validation(next))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(test.body)
+			if test.chunked {
+				body = io.MultiReader(body)
+			}
+			request := httptest.NewRequest(http.MethodPost, "/api/user/register", body)
+			request.Header.Set("Content-Type", "application/json")
+			if test.chunked {
+				request.ContentLength = -1
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if test.status == http.StatusRequestEntityTooLarge {
+				problemtest.Check(t, recorder, problem.CodeRequestTooLarge)
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package authorization
 import (
 	"context"
 	"errors"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
 	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
 	"net/http"
 )
 
@@ -17,14 +19,15 @@ func Authorization(identityProvider idp.IdentityProvider) func(http.Handler) htt
 			token := in.Header.Get("Authorization")
 			user, err := identityProvider.User(in.Context(), idp.Token(token))
 			if err != nil {
-				status := http.StatusInternalServerError
 				if errors.Is(err, idp.ErrBadCredentials) {
-					status = http.StatusUnauthorized
+					problem.Write(out, in, problem.CodeUnauthorized, "")
+					return
 				}
-				http.Error(out, http.StatusText(status), status)
+				problem.Error(out, in, err)
 				return
 			}
-			next.ServeHTTP(out, in.WithContext(context.WithValue(in.Context(), ContextKeyUser, user)))
+			ctx := logging.With(in.Context(), "user", user.ID())
+			next.ServeHTTP(out, in.WithContext(context.WithValue(ctx, ContextKeyUser, user)))
 		})
 	}
 }
@@ -0,0 +1,41 @@
+package authorization_test
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizationOfDeletedUser(t *testing.T) {
+	ctx := context.Background()
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+	token, identity := idptest.Authenticate(t, provider, database, "alice")
+
+	handler := authorization.Authorization(provider)(http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		authorization.User(in)
+		out.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func() int {
+		request := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+		request.Header.Set("Authorization", string(token))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := serve(); code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", code, http.StatusNoContent)
+	}
+	if err := identity.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(); code != http.StatusUnauthorized {
+		t.Fatalf("status with the token of a deleted user = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,106 @@
+// Package clientip resolves the address of the client that made a request,
+// trusting the forwarding headers only when they are set by a trusted proxy.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver resolves the addresses of the clients.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New creates a new Resolver that trusts the X-Forwarded-For
+// and X-Real-IP headers set by the proxies in the trusted networks.
+func New(trusted []netip.Prefix) Resolver {
+	return Resolver{trusted: trusted}
+}
+
+// ParseTrusted parses a comma separated list of networks (e.g. "10.0.0.0/8, 127.0.0.1"),
+// a single address is treated as a network of one address.
+func ParseTrusted(list string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, parseError := netip.ParseAddr(item)
+			if parseError != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", item, parseError)
+			}
+			networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		network, parseError := netip.ParsePrefix(item)
+		if parseError != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", item, parseError)
+		}
+		networks = append(networks, network.Masked())
+	}
+	return networks, nil
+}
+
+// IP returns the address of the client that made the request.
+//
+// If the request comes from a trusted proxy, the rightmost address
+// of X-Forwarded-For that is not a trusted proxy is returned,
+// or X-Real-IP if there is no X-Forwarded-For.
+func (r Resolver) IP(in *http.Request) netip.Addr {
+	remote := remoteAddr(in)
+	if !r.isTrusted(remote) {
+		return remote
+	}
+
+	forwarded := make([]netip.Addr, 0)
+	for _, header := range in.Header.Values("X-Forwarded-For") {
+		for _, item := range strings.Split(header, ",") {
+			addr, parseError := netip.ParseAddr(strings.TrimSpace(item))
+			if parseError != nil {
+				return remote // a malformed chain can not be trusted
+			}
+			forwarded = append(forwarded, addr.Unmap())
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if !r.isTrusted(forwarded[i]) {
+			return forwarded[i]
+		}
+	}
+	if len(forwarded) > 0 {
+		return forwarded[0]
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(in.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap()
+	}
+	return remote
+}
+
+func (r Resolver) isTrusted(addr netip.Addr) bool {
+	for _, network := range r.trusted {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr returns the address of the peer, or the zero address if it is not an IP address.
+func remoteAddr(in *http.Request) netip.Addr {
+	host, _, splitError := net.SplitHostPort(in.RemoteAddr)
+	if splitError != nil {
+		host = in.RemoteAddr
+	}
+	addr, parseError := netip.ParseAddr(host)
+	if parseError != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,89 @@
+package clientip_test
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/clientip"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestResolverIP(t *testing.T) {
+	trusted, parseError := clientip.ParseTrusted("10.0.0.0/8, 192.0.2.1")
+	if parseError != nil {
+		t.Fatal(parseError)
+	}
+	resolver := clientip.New(trusted)
+
+	tests := []struct {
+		name   string
+		remote string
+		header http.Header
+		want   string
+	}{
+		{"Direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"SpoofedByUntrustedPeer", "203.0.113.7:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"RealIPFromUntrustedPeer", "203.0.113.7:1234", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "203.0.113.7"},
+		{"Proxied", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"RightmostUntrustedHop", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.66, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"SeveralHeaders", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.66", "198.51.100.1, 192.0.2.1"}}, "198.51.100.1"},
+		{"OnlyTrustedHops", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"MalformedChain", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1, not-an-ip"}}, "10.0.0.1"},
+		{"EmptyHop", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1,,10.0.0.2"}}, "10.0.0.1"},
+		{"MappedHop", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"::ffff:198.51.100.1"}}, "198.51.100.1"},
+		{"RealIP", "10.0.0.1:1234", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+		{"ForwardedForOverRealIP", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.2"}}, "198.51.100.1"},
+		{"MalformedRealIP", "10.0.0.1:1234", http.Header{"X-Real-Ip": {"not-an-ip"}}, "10.0.0.1"},
+		{"MappedPeer", "[::ffff:203.0.113.7]:1234", nil, "203.0.113.7"},
+		{"NotAnAddress", "pipe", nil, "invalid IP"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = test.remote
+			for key, values := range test.header {
+				request.Header[key] = values
+			}
+			if ip := resolver.IP(request); ip.String() != test.want {
+				t.Fatalf("IP() = %s, want %s", ip, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTrusted(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []netip.Prefix
+		invalid bool
+	}{
+		{list: "", want: []netip.Prefix{}},
+		{list: "10.1.2.3/8", want: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+		{list: " 127.0.0.1 , ::1", want: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32"), netip.MustParsePrefix("::1/128")}},
+		{list: "::ffff:10.0.0.1", want: []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}},
+		{list: "10.0.0.0/33", invalid: true},
+		{list: "proxy", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.list, func(t *testing.T) {
+			trusted, err := clientip.ParseTrusted(test.list)
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("ParseTrusted() = %v, want an error", trusted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTrusted() = %v, want nil", err)
+			}
+			if len(trusted) != len(test.want) {
+				t.Fatalf("ParseTrusted() = %v, want %v", trusted, test.want)
+			}
+			for i := range trusted {
+				if trusted[i] != test.want[i] {
+					t.Fatalf("ParseTrusted() = %v, want %v", trusted, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Package conditional answers the conditional GET requests for the data of the users
+// with 304 Not Modified when the data has not changed since the previous response.
+package conditional
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etagVersion is changed whenever the representations change,
+// so that the ETags sent by older versions no longer match.
+const etagVersion = "1"
+
+// Conditional is a middleware that validates the GET requests by the revision of the user,
+// the responses are marked with an ETag and a Last-Modified derived from the revision
+// and the requests with a matching If-None-Match or If-Modified-Since are answered
+// with 304 Not Modified.
+//
+// The revision is read before the request is handled, so a change made meanwhile
+// makes the next request be answered in full rather than the other way round.
+// The middleware must be used after the authorization one.
+func Conditional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		if in.Method != http.MethodGet {
+			next.ServeHTTP(out, in)
+			return
+		}
+		user := authorization.User(in)
+		revision, revisionError := user.Revision(in.Context())
+		if revisionError != nil {
+			problem.Error(out, in, revisionError)
+			return
+		}
+
+		etag := makeETag(in, user, revision)
+		if notModified(in, etag, revision.Time) {
+			setValidators(out.Header(), etag, revision.Time)
+			out.WriteHeader(http.StatusNotModified)
+			return
+		}
+		next.ServeHTTP(&validatingWriter{ResponseWriter: out, etag: etag, modified: revision.Time}, in)
+	})
+}
+
+// makeETag makes a weak ETag of the representation requested by the user at the revision.
+func makeETag(in *http.Request, user idp.User, revision idp.Revision) string {
+	hash := sha256.New()
+	hash.Write([]byte(etagVersion + "\n"))
+	hash.Write([]byte(strconv.FormatInt(user.ID(), 10) + "\n"))
+	hash.Write([]byte(strconv.FormatInt(revision.Number, 10) + "\n"))
+	hash.Write([]byte(in.URL.RequestURI() + "\n"))
+	hash.Write([]byte(in.Header.Get("Accept")))
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// notModified reports whether the validators of the request match the current ones.
+//
+// If-Modified-Since is only evaluated without If-None-Match, as it is precise
+// only to a second, while the ETag changes with every change.
+func notModified(in *http.Request, etag string, modified time.Time) bool {
+	if match := in.Header.Get("If-None-Match"); match != "" {
+		return matchETag(match, etag)
+	}
+	since := in.Header.Get("If-Modified-Since")
+	if since == "" || modified.IsZero() {
+		return false
+	}
+	sinceTime, parseError := http.ParseTime(since)
+	if parseError != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(sinceTime)
+}
+
+// matchETag reports whether an If-None-Match header matches the etag,
+// the ETags are compared weakly.
+func matchETag(match, etag string) bool {
+	for _, candidate := range strings.Split(match, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// setValidators sets the validators of the response, the responses differ
+// between the users and the requested formats, so the shared caches must not store them.
+func setValidators(header http.Header, etag string, modified time.Time) {
+	header.Set("ETag", etag)
+	if !modified.IsZero() {
+		header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	header.Set("Cache-Control", "private, no-cache")
+	header.Add("Vary", "Authorization, Accept")
+}
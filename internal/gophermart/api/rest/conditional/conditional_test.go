@@ -0,0 +1,97 @@
+package conditional_test
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/conditional"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConditional(t *testing.T) {
+	ctx := context.Background()
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+	token, identity := idptest.Authenticate(t, provider, database, "alice")
+	if err := database.AdjustBalance(ctx, identity.ID(), 100, "test"); err != nil {
+		t.Fatal(err)
+	}
+	handler := authorization.Authorization(provider)(conditional.Conditional(http.HandlerFunc(
+		func(out http.ResponseWriter, in *http.Request) {
+			out.Header().Set("Content-Type", "application/json")
+			out.WriteHeader(http.StatusOK)
+			out.Write([]byte(`{}`))
+		},
+	)))
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		request.Header.Set("Authorization", string(token))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := serve(nil)
+	if first.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", first.Code, http.StatusOK)
+	}
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("ETag = %q, Last-Modified = %q, want both", etag, lastModified)
+	}
+	modified, parseError := http.ParseTime(lastModified)
+	if parseError != nil {
+		t.Fatal(parseError)
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{name: "matching If-None-Match", header: http.Header{"If-None-Match": {etag}}, want: http.StatusNotModified},
+		{name: "strong If-None-Match", header: http.Header{"If-None-Match": {`"other", ` + etag[len("W/"):]}}, want: http.StatusNotModified},
+		{name: "other If-None-Match", header: http.Header{"If-None-Match": {`W/"other"`}}, want: http.StatusOK},
+		// The revision is precise to a millisecond while the header is precise to a second.
+		{name: "If-Modified-Since of the same second", header: http.Header{"If-Modified-Since": {lastModified}}, want: http.StatusNotModified},
+		{name: "If-Modified-Since of the second before", header: http.Header{"If-Modified-Since": {modified.Add(-time.Second).Format(http.TimeFormat)}}, want: http.StatusOK},
+		{
+			name:   "If-None-Match over If-Modified-Since",
+			header: http.Header{"If-None-Match": {`W/"other"`}, "If-Modified-Since": {lastModified}},
+			want:   http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(test.header)
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.want)
+			}
+			if got := recorder.Header().Get("ETag"); got != etag {
+				t.Fatalf("ETag = %q, want %q", got, etag)
+			}
+			if test.want == http.StatusNotModified && recorder.Body.Len() != 0 {
+				t.Fatalf("body of 304 = %q, want empty", recorder.Body)
+			}
+		})
+	}
+
+	if err := database.AdjustBalance(ctx, identity.ID(), 10, "test"); err != nil {
+		t.Fatal(err)
+	}
+	changed := serve(http.Header{"If-None-Match": {etag}})
+	if changed.Code != http.StatusOK {
+		t.Fatalf("status after a change = %d, want %d", changed.Code, http.StatusOK)
+	}
+	if got := changed.Header().Get("ETag"); got == "" || got == etag {
+		t.Fatalf("ETag after a change = %q, want a new one", got)
+	}
+}
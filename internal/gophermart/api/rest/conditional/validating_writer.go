@@ -0,0 +1,33 @@
+package conditional
+
+import (
+	"net/http"
+	"time"
+)
+
+// validatingWriter sets the validators on the successful responses.
+type validatingWriter struct {
+	http.ResponseWriter
+
+	etag        string
+	modified    time.Time
+	wroteHeader bool
+}
+
+func (w *validatingWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status == http.StatusOK || status == http.StatusNoContent {
+		setValidators(w.Header(), w.etag, w.modified)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *validatingWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
@@ -0,0 +1,51 @@
+// Package decompression decodes the compressed request bodies.
+package decompression
+
+import (
+	"compress/gzip"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Decompression returns a middleware that decodes the gzip encoded request bodies,
+// the decoded bodies larger than maxSize fail to read.
+//
+// The requests with other encodings are answered with 415 Unsupported Media Type.
+func Decompression(maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+			encoding := strings.ToLower(strings.TrimSpace(in.Header.Get("Content-Encoding")))
+			switch encoding {
+			case "", "identity":
+				next.ServeHTTP(out, in)
+				return
+			case "gzip", "x-gzip":
+			default:
+				out.Header().Set("Accept-Encoding", "gzip")
+				problem.Write(out, in, problem.CodeUnsupportedContentEncoding, "only gzip request bodies are supported")
+				return
+			}
+
+			reader, readerError := gzip.NewReader(in.Body)
+			if readerError != nil {
+				problem.Write(out, in, problem.CodeMalformedRequest, "invalid gzip body: "+readerError.Error())
+				return
+			}
+			defer reader.Close()
+
+			in.Body = http.MaxBytesReader(out, readCloser{Reader: reader, Closer: in.Body}, maxSize)
+			in.ContentLength = -1
+			in.Header.Del("Content-Encoding")
+			in.Header.Del("Content-Length")
+			next.ServeHTTP(out, in)
+		})
+	}
+}
+
+// readCloser reads the decoded body and closes the original one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,132 @@
+// Package idempotency makes the POST requests with an Idempotency-Key header
+// safe to retry: a repeated request is answered with the stored response
+// instead of being handled again.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// HeaderKey is the header the clients send the idempotency key in.
+	HeaderKey = "Idempotency-Key"
+
+	// HeaderReplayed is set on the responses sent again to a repeated request.
+	HeaderReplayed = "Idempotent-Replayed"
+
+	// maxKeyLength is the maximum length of an idempotency key.
+	maxKeyLength = 255
+)
+
+// Idempotency returns a middleware that stores the responses to the POST requests
+// of the user made with an idempotency key for the window and sends them again
+// when the requests are repeated with the same key and the same body.
+//
+// A key reused with another body is answered with 422 Unprocessable Entity,
+// a key of a request in progress with 409 Conflict and a body larger than
+// maxBodySize with 413 Content Too Large. The requests that fail with
+// a 5xx status are not stored, so they may be retried with the same key.
+// The middleware must be used after the authorization one.
+func Idempotency(store idp.IdempotencyStore, window time.Duration, maxBodySize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+			key := in.Header.Get(HeaderKey)
+			if in.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(out, in)
+				return
+			}
+			if !validKey(key) {
+				problem.Write(out, in, problem.CodeInvalidIdempotencyKey, "the key must be 1 to 255 printable ASCII characters")
+				return
+			}
+			user := authorization.User(in)
+
+			body, readBodyError := io.ReadAll(http.MaxBytesReader(out, in.Body, maxBodySize))
+			if readBodyError != nil {
+				problem.Body(out, in, readBodyError)
+				return
+			}
+			in.Body = io.NopCloser(bytes.NewReader(body))
+
+			fingerprint := fingerprint(in, body)
+			request, created, beginError := store.BeginIdempotentRequest(in.Context(), user.ID(), key, fingerprint, time.Now().Add(-window))
+			if beginError != nil {
+				problem.Error(out, in, beginError)
+				return
+			}
+			if !created {
+				replay(out, in, request, fingerprint)
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: out, status: http.StatusOK}
+			next.ServeHTTP(recorder, in)
+
+			// The request has been handled, so its outcome is stored even if the client is gone.
+			ctx := withoutCancel{in.Context()}
+			if recorder.status >= http.StatusInternalServerError {
+				if err := store.CancelIdempotentRequest(ctx, user.ID(), key); err != nil {
+					logging.FromContext(ctx).Error("failed to cancel idempotent request", "error", err)
+				}
+				return
+			}
+			response := idp.IdempotentResponse{
+				Status:      recorder.status,
+				ContentType: recorder.Header().Get("Content-Type"),
+				Body:        recorder.body.Bytes(),
+			}
+			if err := store.CompleteIdempotentRequest(ctx, user.ID(), key, response); err != nil {
+				logging.FromContext(ctx).Error("failed to store idempotent response", "error", err)
+			}
+		})
+	}
+}
+
+// replay answers a repeated request with the response to the stored one.
+func replay(out http.ResponseWriter, in *http.Request, request idp.IdempotentRequest, fingerprint string) {
+	if request.Fingerprint != fingerprint {
+		problem.Write(out, in, problem.CodeIdempotencyKeyReused, "the key has been used for another request")
+		return
+	}
+	if request.Response == nil {
+		problem.Write(out, in, problem.CodeIdempotencyKeyInUse, "the request with the key is still in progress")
+		return
+	}
+	if request.Response.ContentType != "" {
+		out.Header().Set("Content-Type", request.Response.ContentType)
+	}
+	out.Header().Set(HeaderReplayed, "true")
+	out.WriteHeader(request.Response.Status)
+	if _, err := out.Write(request.Response.Body); err != nil {
+		logging.FromContext(in.Context()).Error("failed to write idempotent response", "error", err)
+	}
+}
+
+// fingerprint identifies the method, the path and the body of the request.
+func fingerprint(in *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(in.Method + " " + in.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func validKey(key string) bool {
+	if len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
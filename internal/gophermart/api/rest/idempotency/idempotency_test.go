@@ -0,0 +1,70 @@
+package idempotency_test
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/idempotency"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	ctx := context.Background()
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	if err := database.Create(ctx, "alice", "password"); err != nil {
+		t.Fatal(err)
+	}
+	user, findError := database.Find(ctx, "alice")
+	if findError != nil {
+		t.Fatal(findError)
+	}
+
+	handled := 0
+	handler := idempotency.Idempotency(database, time.Hour, 16)(http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		handled++
+		body, _ := io.ReadAll(in.Body)
+		out.WriteHeader(http.StatusAccepted)
+		out.Write(body)
+	}))
+	serve := func(key, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), authorization.ContextKeyUser, idp.User(user)))
+		request.Header.Set(idempotency.HeaderKey, key)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		body     string
+		status   int
+		replayed bool
+		handled  int
+	}{
+		{"First", "a", "12345678903", http.StatusAccepted, false, 1},
+		{"Repeated", "a", "12345678903", http.StatusAccepted, true, 1},
+		{"Reused", "a", "79927398713", http.StatusUnprocessableEntity, false, 1},
+		{"TooLarge", "b", strings.Repeat("1", 17), http.StatusRequestEntityTooLarge, false, 1},
+		{"Limit", "b", strings.Repeat("1", 16), http.StatusAccepted, false, 2},
+	}
+	for _, test := range tests {
+		response := serve(test.key, test.body)
+		if response.Code != test.status {
+			t.Fatalf("%s: status = %d, want %d: %s", test.name, response.Code, test.status, response.Body)
+		}
+		if replayed := response.Header().Get(idempotency.HeaderReplayed) == "true"; replayed != test.replayed {
+			t.Fatalf("%s: replayed = %t, want %t", test.name, replayed, test.replayed)
+		}
+		if handled != test.handled {
+			t.Fatalf("%s: the handler is called %d times, want %d", test.name, handled, test.handled)
+		}
+	}
+}
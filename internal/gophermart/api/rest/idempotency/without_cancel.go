@@ -0,0 +1,27 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// withoutCancel is a context that keeps the values of its parent but is never done.
+type withoutCancel struct {
+	parent context.Context
+}
+
+func (withoutCancel) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (withoutCancel) Done() <-chan struct{} {
+	return nil
+}
+
+func (withoutCancel) Err() error {
+	return nil
+}
+
+func (w withoutCancel) Value(key any) any {
+	return w.parent.Value(key)
+}
@@ -0,0 +1,87 @@
+// Package ledger merges the changes of the balance of a user into statements.
+package ledger
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"sort"
+	"time"
+)
+
+const (
+	// EntryCredit is an entry of the points accrued for a processed order, dated when it was processed.
+	EntryCredit = "credit"
+
+	// EntryDebit is an entry of the points withdrawn towards an order.
+	EntryDebit = "debit"
+
+	// EntryAdjustment is an entry of the points credited or debited by an operator, it has no order.
+	EntryAdjustment = "adjustment"
+)
+
+// Entry is a change of the balance, with the balance right after the change.
+type Entry struct {
+	Type    string
+	Order   string
+	Amount  float64
+	Balance float64
+	Time    time.Time
+}
+
+// Statement is the chronological list of the changes of the balance in a period.
+type Statement struct {
+	From time.Time
+	To   time.Time
+
+	OpeningBalance float64
+	ClosingBalance float64
+	Credited       float64
+	Debited        float64
+
+	Entries []Entry
+}
+
+// Make merges the processed orders, the withdrawals and the adjustments into the statement
+// of the period from (inclusive) to (exclusive), a zero time leaves the period open.
+// The changes made before the period make up the opening balance.
+func Make(orders []idp.Order, withdrawals []idp.Withdrawal, adjustments []idp.Adjustment, from, to time.Time) Statement {
+	entries := make([]Entry, 0, len(orders)+len(withdrawals)+len(adjustments))
+	for _, o := range orders {
+		if o.Status == idp.OrderStatusProcessed && o.Accrual != 0 {
+			entries = append(entries, Entry{Type: EntryCredit, Order: o.ID, Amount: o.Accrual, Time: o.Processed})
+		}
+	}
+	for _, w := range withdrawals {
+		entries = append(entries, Entry{Type: EntryDebit, Order: w.Order, Amount: -w.Sum, Time: w.Time})
+	}
+	for _, a := range adjustments {
+		entries = append(entries, Entry{Type: EntryAdjustment, Amount: a.Sum, Time: a.Time})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	result := Statement{From: from, To: to, Entries: make([]Entry, 0)}
+	balance := 0.0
+	for _, e := range entries {
+		balance += e.Amount
+		e.Balance = balance
+		switch {
+		case !from.IsZero() && e.Time.Before(from):
+			result.OpeningBalance = balance
+		case !to.IsZero() && !e.Time.Before(to):
+			continue
+		default:
+			result.Entries = append(result.Entries, e)
+			if e.Amount > 0 {
+				result.Credited += e.Amount
+			} else {
+				result.Debited -= e.Amount
+			}
+		}
+	}
+	result.ClosingBalance = result.OpeningBalance
+	if len(result.Entries) > 0 {
+		result.ClosingBalance = result.Entries[len(result.Entries)-1].Balance
+	}
+	return result
+}
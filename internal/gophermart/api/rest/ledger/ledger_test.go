@@ -0,0 +1,91 @@
+package ledger_test
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/ledger"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMake(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2024, time.January, d, 12, 0, 0, 0, time.UTC)
+	}
+	orders := []idp.Order{
+		// Uploaded before the period but processed in it, so credited in it.
+		{ID: "12345678903", Status: idp.OrderStatusProcessed, Accrual: 100, Time: day(1), Processed: day(3)},
+		{ID: "2377225624", Status: idp.OrderStatusProcessed, Accrual: 50, Time: day(1), Processed: day(1)},
+		{ID: "79927398713", Status: idp.OrderStatusProcessed, Accrual: 0, Time: day(4), Processed: day(4)},
+		{ID: "4561261212345467", Status: idp.OrderStatusProcessing, Time: day(4)},
+	}
+	withdrawals := []idp.Withdrawal{
+		{Order: "49927398716", Sum: 30, Time: day(2)},
+		{Order: "1234567812345670", Sum: 20, Time: day(5)},
+	}
+	adjustments := []idp.Adjustment{
+		{Sum: 10, Reason: "bonus", Time: day(4)},
+		{Sum: -5, Reason: "correction", Time: day(6)},
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     ledger.Statement
+	}{
+		{
+			name: "open period",
+			want: ledger.Statement{
+				OpeningBalance: 0,
+				ClosingBalance: 105,
+				Credited:       160,
+				Debited:        55,
+				Entries: []ledger.Entry{
+					{Type: ledger.EntryCredit, Order: "2377225624", Amount: 50, Balance: 50, Time: day(1)},
+					{Type: ledger.EntryDebit, Order: "49927398716", Amount: -30, Balance: 20, Time: day(2)},
+					{Type: ledger.EntryCredit, Order: "12345678903", Amount: 100, Balance: 120, Time: day(3)},
+					{Type: ledger.EntryAdjustment, Amount: 10, Balance: 130, Time: day(4)},
+					{Type: ledger.EntryDebit, Order: "1234567812345670", Amount: -20, Balance: 110, Time: day(5)},
+					{Type: ledger.EntryAdjustment, Amount: -5, Balance: 105, Time: day(6)},
+				},
+			},
+		},
+		{
+			name: "closed period",
+			from: day(2),
+			to:   day(5),
+			want: ledger.Statement{
+				From:           day(2),
+				To:             day(5),
+				OpeningBalance: 50,
+				ClosingBalance: 130,
+				Credited:       110,
+				Debited:        30,
+				Entries: []ledger.Entry{
+					{Type: ledger.EntryDebit, Order: "49927398716", Amount: -30, Balance: 20, Time: day(2)},
+					{Type: ledger.EntryCredit, Order: "12345678903", Amount: 100, Balance: 120, Time: day(3)},
+					{Type: ledger.EntryAdjustment, Amount: 10, Balance: 130, Time: day(4)},
+				},
+			},
+		},
+		{
+			name: "period without entries",
+			from: day(5).Add(time.Second),
+			to:   day(6),
+			want: ledger.Statement{
+				From:           day(5).Add(time.Second),
+				To:             day(6),
+				OpeningBalance: 110,
+				ClosingBalance: 110,
+				Entries:        []ledger.Entry{},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ledger.Make(orders, withdrawals, adjustments, test.from, test.to); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("Make() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package listing
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxLimit is the maximum number of records in one page.
+const MaxLimit = 1000
+
+// ErrBadQuery is returned when the list query parameters are malformed.
+var ErrBadQuery = errors.New("bad list query")
+
+// Query parses the list query parameters of the request:
+//
+//	from   - RFC 3339 time, the records older than it are skipped
+//	to     - RFC 3339 time, the records not older than it are skipped
+//	sort   - "asc" (oldest first, the default) or "desc" (newest first)
+//	limit  - maximum number of records in the page, 1 to MaxLimit
+//	cursor - the cursor of the next page returned with the previous page
+func Query(in *http.Request) (idp.ListQuery, error) {
+	parameters := in.URL.Query()
+	query := idp.ListQuery{}
+
+	from, to, rangeError := Range(in)
+	if rangeError != nil {
+		return idp.ListQuery{}, rangeError
+	}
+	query.From = from
+	query.To = to
+
+	switch parameters.Get("sort") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return idp.ListQuery{}, fmt.Errorf("%w: sort must be asc or desc", ErrBadQuery)
+	}
+
+	if limit := parameters.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxLimit {
+			return idp.ListQuery{}, fmt.Errorf("%w: limit must be from 1 to %d", ErrBadQuery, MaxLimit)
+		}
+		query.Limit = n
+	}
+
+	if cursor := parameters.Get("cursor"); cursor != "" {
+		c, err := idp.ParseCursor(cursor)
+		if err != nil {
+			return idp.ListQuery{}, fmt.Errorf("%w: cursor: %v", ErrBadQuery, err)
+		}
+		query.After = &c
+	}
+
+	return query, nil
+}
+
+// Range parses the "from" and "to" RFC 3339 query parameters of the request,
+// the times of the missing parameters are zero.
+func Range(in *http.Request) (from, to time.Time, err error) {
+	parameters := in.URL.Query()
+	if parameter := parameters.Get("from"); parameter != "" {
+		from, err = time.Parse(time.RFC3339, parameter)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: from: %v", ErrBadQuery, err)
+		}
+	}
+	if parameter := parameters.Get("to"); parameter != "" {
+		to, err = time.Parse(time.RFC3339, parameter)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: to: %v", ErrBadQuery, err)
+		}
+	}
+	return from, to, nil
+}
+
+// Statuses parses the comma separated "status" query parameter of the request.
+func Statuses(in *http.Request) ([]idp.OrderStatus, error) {
+	parameter := in.URL.Query().Get("status")
+	if parameter == "" {
+		return nil, nil
+	}
+
+	statuses := make([]idp.OrderStatus, 0)
+	for _, s := range strings.Split(parameter, ",") {
+		status := idp.OrderStatus(strings.ToUpper(strings.TrimSpace(s)))
+		switch status {
+		case idp.OrderStatusNew, idp.OrderStatusProcessing, idp.OrderStatusInvalid, idp.OrderStatusProcessed:
+			statuses = append(statuses, status)
+		default:
+			return nil, fmt.Errorf("%w: unknown status %q", ErrBadQuery, s)
+		}
+	}
+	return statuses, nil
+}
+
+// Page asks the database for one more record than the query limit,
+// so that Paginate can tell whether there is a next page.
+func Page(query idp.ListQuery) idp.ListQuery {
+	if query.Limit > 0 {
+		query.Limit++
+	}
+	return query
+}
+
+// Paginate drops the extra record requested by Page and, if there is a next page,
+// sets the Link and X-Next-Cursor headers to the cursor of the next page.
+func Paginate[T any](out http.ResponseWriter, in *http.Request, query idp.ListQuery, records []T, cursor func(T) idp.Cursor) []T {
+	if query.Limit == 0 || len(records) <= query.Limit {
+		return records
+	}
+	records = records[:query.Limit]
+
+	next := cursor(records[len(records)-1]).String()
+	link := *in.URL
+	parameters := link.Query()
+	parameters.Set("cursor", next)
+	link.RawQuery = parameters.Encode()
+
+	out.Header().Set("X-Next-Cursor", next)
+	out.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, link.RequestURI()))
+	return records
+}
@@ -0,0 +1,189 @@
+// Package openapi serves the OpenAPI specification of the REST API
+// and validates requests and responses against it.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"io"
+	"net/http"
+	"strings"
+)
+
+//go:embed openapi.yaml
+var specification []byte
+
+type OpenAPI struct {
+	document *openapi3.T
+	router   routers.Router
+	json     []byte
+
+	validateResponses bool
+}
+
+// New creates a new OpenAPI.
+//
+// If validateResponses is set, the responses are validated too, which is
+// meant for tests as it buffers every response.
+func New(validateResponses bool) OpenAPI {
+	document, loadError := openapi3.NewLoader().LoadFromData(specification)
+	if loadError != nil {
+		panic(fmt.Sprintf("invalid openapi specification: %v", loadError))
+	}
+	if err := document.Validate(context.Background()); err != nil {
+		panic(fmt.Sprintf("invalid openapi specification: %v", err))
+	}
+	router, routerError := gorillamux.NewRouter(document)
+	if routerError != nil {
+		panic(fmt.Sprintf("invalid openapi specification: %v", routerError))
+	}
+	encoded, encodeError := json.Marshal(document)
+	if encodeError != nil {
+		panic(fmt.Sprintf("invalid openapi specification: %v", encodeError))
+	}
+	return OpenAPI{
+		document: document,
+		router:   router,
+		json:     encoded,
+
+		validateResponses: validateResponses,
+	}
+}
+
+// ServeHTTP responds with the specification in JSON.
+func (o OpenAPI) ServeHTTP(out http.ResponseWriter, in *http.Request) {
+	out.Header().Set("Content-Type", "application/json")
+	out.WriteHeader(http.StatusOK)
+	if _, err := out.Write(o.json); err != nil {
+		logging.FromContext(in.Context()).Error("failed to write openapi specification", "error", err)
+	}
+}
+
+// Validation is a middleware that rejects the requests that do not match
+// the specification, the requests to the paths the specification does not
+// describe are passed as is.
+func (o OpenAPI) Validation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		route, pathParameters, findRouteError := o.router.FindRoute(in)
+		if findRouteError != nil {
+			next.ServeHTTP(out, in)
+			return
+		}
+
+		validated := withDeclaredContentType(in, route)
+		options := &openapi3filter.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		}
+		options.WithCustomSchemaErrorFunc(schemaErrorMessage)
+		requestValidationInput := &openapi3filter.RequestValidationInput{
+			Request:    validated,
+			PathParams: pathParameters,
+			Route:      route,
+			Options:    options,
+		}
+		validationError := openapi3filter.ValidateRequest(in.Context(), requestValidationInput)
+		// The validation consumes the body and replaces it with a copy.
+		in.Body = validated.Body
+		if tooLarge := new(http.MaxBytesError); errors.As(validationError, &tooLarge) {
+			problem.Body(out, in, tooLarge)
+			return
+		}
+		if validationError != nil {
+			problem.Write(out, in, problem.CodeInvalidRequest, validationError.Error())
+			return
+		}
+
+		if !o.validateResponses {
+			next.ServeHTTP(out, in)
+			return
+		}
+
+		recorder := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(recorder, in)
+
+		responseValidationInput := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: requestValidationInput,
+			Status:                 recorder.status,
+			Header:                 recorder.header,
+			Body:                   io.NopCloser(bytes.NewReader(recorder.body.Bytes())),
+			Options: &openapi3filter.Options{
+				IncludeResponseStatus: true,
+			},
+		}
+		if err := openapi3filter.ValidateResponse(in.Context(), responseValidationInput); err != nil {
+			logging.FromContext(in.Context()).Error("response does not match the openapi specification", "error", err)
+			problem.Write(out, in, problem.CodeInvalidResponse, err.Error())
+			return
+		}
+
+		for key, values := range recorder.header {
+			out.Header()[key] = values
+		}
+		out.WriteHeader(recorder.status)
+		if _, err := out.Write(recorder.body.Bytes()); err != nil {
+			logging.FromContext(in.Context()).Error("failed to write response", "error", err)
+		}
+	})
+}
+
+// schemaErrorMessage describes the schema error without dumping the schema.
+func schemaErrorMessage(err *openapi3.SchemaError) string {
+	if pointer := err.JSONPointer(); len(pointer) > 0 {
+		return fmt.Sprintf("/%s: %s", strings.Join(pointer, "/"), err.Reason)
+	}
+	return err.Reason
+}
+
+// withDeclaredContentType returns the request to validate instead of in.
+//
+// Clients are not required to set the Content-Type, so if the operation accepts a single
+// media type and the request has another one, the body is validated as the declared type.
+func withDeclaredContentType(in *http.Request, route *routers.Route) *http.Request {
+	if route.Operation == nil || route.Operation.RequestBody == nil || route.Operation.RequestBody.Value == nil {
+		return in
+	}
+	content := route.Operation.RequestBody.Value.Content
+	if len(content) != 1 || content.Get(in.Header.Get("Content-Type")) != nil {
+		return in
+	}
+	validated := in.Clone(in.Context())
+	for mediaType := range content {
+		validated.Header.Set("Content-Type", mediaType)
+	}
+	return validated
+}
+
+// responseRecorder keeps a response to be validated before sending it.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.status = status
+	r.wroteHeader = true
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(data)
+}
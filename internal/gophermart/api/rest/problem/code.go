@@ -0,0 +1,87 @@
+package problem
+
+import "net/http"
+
+// Code is a stable machine-readable identifier of a problem,
+// the codes are documented in docs/problems.md.
+type Code string
+
+const (
+	CodeMalformedRequest           = Code("malformed_request")
+	CodeEmptyBody                  = Code("empty_body")
+	CodeInvalidQuery               = Code("invalid_query")
+	CodeInvalidRequest             = Code("invalid_request")
+	CodeInvalidLogin               = Code("invalid_login")
+	CodeInvalidIdempotencyKey      = Code("invalid_idempotency_key")
+	CodeUnauthorized               = Code("unauthorized")
+	CodeBadCredentials             = Code("bad_credentials")
+	CodeInsufficientBalance        = Code("insufficient_balance")
+	CodeNotFound                   = Code("not_found")
+	CodeMethodNotAllowed           = Code("method_not_allowed")
+	CodeNotAcceptable              = Code("not_acceptable")
+	CodeUsernameTaken              = Code("username_taken")
+	CodeOrderUnowned               = Code("order_unowned")
+	CodeWithdrawalDuplicate        = Code("withdrawal_duplicate")
+	CodeIdempotencyKeyInUse        = Code("idempotency_key_in_use")
+	CodeInvalidOrderNumber         = Code("invalid_order_number")
+	CodeInvalidWithdrawalSum       = Code("invalid_withdrawal_sum")
+	CodeInvalidWebhookURL          = Code("invalid_webhook_url")
+	CodeIdempotencyKeyReused       = Code("idempotency_key_reused")
+	CodeTooManyRequests            = Code("too_many_requests")
+	CodeRequestTooLarge            = Code("request_too_large")
+	CodeUnsupportedContentEncoding = Code("unsupported_content_encoding")
+	CodeInternalServerError        = Code("internal_server_error")
+	CodeInvalidResponse            = Code("invalid_response")
+	CodeServiceUnavailable         = Code("service_unavailable")
+)
+
+type definition struct {
+	status int
+	title  string
+}
+
+var definitions = map[Code]definition{
+	CodeMalformedRequest:           {http.StatusBadRequest, "The request body is malformed"},
+	CodeEmptyBody:                  {http.StatusBadRequest, "The request body is empty"},
+	CodeInvalidQuery:               {http.StatusBadRequest, "The query parameters are invalid"},
+	CodeInvalidRequest:             {http.StatusBadRequest, "The request does not match the OpenAPI specification"},
+	CodeInvalidLogin:               {http.StatusBadRequest, "The login is invalid"},
+	CodeInvalidIdempotencyKey:      {http.StatusBadRequest, "The idempotency key is invalid"},
+	CodeUnauthorized:               {http.StatusUnauthorized, "The authorization token is missing, invalid or expired"},
+	CodeBadCredentials:             {http.StatusUnauthorized, "The login or password is wrong"},
+	CodeInsufficientBalance:        {http.StatusPaymentRequired, "The balance is too low"},
+	CodeNotFound:                   {http.StatusNotFound, "The resource does not exist"},
+	CodeMethodNotAllowed:           {http.StatusMethodNotAllowed, "The method is not allowed for the resource"},
+	CodeNotAcceptable:              {http.StatusNotAcceptable, "None of the media types in Accept is available"},
+	CodeUsernameTaken:              {http.StatusConflict, "The login is taken"},
+	CodeOrderUnowned:               {http.StatusConflict, "The order has been uploaded by another user"},
+	CodeWithdrawalDuplicate:        {http.StatusConflict, "Points have already been withdrawn towards the order"},
+	CodeIdempotencyKeyInUse:        {http.StatusConflict, "A request with the idempotency key is in progress"},
+	CodeInvalidOrderNumber:         {http.StatusUnprocessableEntity, "The order number is invalid"},
+	CodeInvalidWithdrawalSum:       {http.StatusUnprocessableEntity, "The sum of the withdrawal is not positive"},
+	CodeInvalidWebhookURL:          {http.StatusUnprocessableEntity, "The webhook URL is not an absolute http(s) URL or its host is not public"},
+	CodeIdempotencyKeyReused:       {http.StatusUnprocessableEntity, "The idempotency key has been used for another request"},
+	CodeTooManyRequests:            {http.StatusTooManyRequests, "The rate limit is exceeded"},
+	CodeRequestTooLarge:            {http.StatusRequestEntityTooLarge, "The request body is too large"},
+	CodeUnsupportedContentEncoding: {http.StatusUnsupportedMediaType, "The Content-Encoding of the request is not supported"},
+	CodeInternalServerError:        {http.StatusInternalServerError, "Internal server error"},
+	CodeInvalidResponse:            {http.StatusInternalServerError, "The response does not match the OpenAPI specification"},
+	CodeServiceUnavailable:         {http.StatusServiceUnavailable, "The service is temporarily unavailable"},
+}
+
+// Status returns the HTTP status of the problem.
+func (c Code) Status() int {
+	return c.definition().status
+}
+
+// Title returns the human-readable summary of the problem.
+func (c Code) Title() string {
+	return c.definition().title
+}
+
+func (c Code) definition() definition {
+	if d, ok := definitions[c]; ok {
+		return d
+	}
+	return definitions[CodeInternalServerError]
+}
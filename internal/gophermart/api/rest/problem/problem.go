@@ -0,0 +1,116 @@
+// Package problem writes RFC 7807 problem details as error responses.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/listing"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"net/http"
+	"strconv"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// retryAfterUnavailable is the number of seconds the clients are asked
+// to wait for before retrying when the service is unavailable.
+const retryAfterUnavailable = 5
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     Code   `json:"code"`
+}
+
+// New creates a new Problem.
+func New(code Code, detail string, instance string) Problem {
+	return Problem{
+		Type:     "urn:gophermart:problem:" + string(code),
+		Title:    code.Title(),
+		Status:   code.Status(),
+		Detail:   detail,
+		Instance: instance,
+		Code:     code,
+	}
+}
+
+// Write responds with the problem with the code.
+func Write(out http.ResponseWriter, in *http.Request, code Code, detail string) {
+	problem := New(code, detail, in.URL.Path)
+	if problem.Status < http.StatusInternalServerError {
+		logging.FromContext(in.Context()).Debug("request rejected", "code", string(code), "detail", detail)
+	}
+	body, marshalError := json.Marshal(problem)
+	if marshalError != nil {
+		http.Error(out, http.StatusText(problem.Status), problem.Status)
+		return
+	}
+
+	out.Header().Set("Content-Type", ContentType)
+	out.Header().Set("X-Content-Type-Options", "nosniff")
+	if code == CodeServiceUnavailable {
+		out.Header().Set("Retry-After", strconv.Itoa(retryAfterUnavailable))
+	}
+	out.WriteHeader(problem.Status)
+	if _, err := out.Write(body); err != nil {
+		logging.FromContext(in.Context()).Error("failed to write problem", "error", err)
+	}
+}
+
+// Body responds with the problem of a request body that failed to be read or decoded:
+// request_too_large if the body is larger than the limit, malformed_request otherwise.
+func Body(out http.ResponseWriter, in *http.Request, err error) {
+	if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+		Write(out, in, CodeRequestTooLarge, fmt.Sprintf("the body must be at most %d bytes", tooLarge.Limit))
+		return
+	}
+	Write(out, in, CodeMalformedRequest, err.Error())
+}
+
+// Error responds with the problem err corresponds to,
+// the errors that do not correspond to any problem are logged.
+func Error(out http.ResponseWriter, in *http.Request, err error) {
+	code := FromError(err)
+	if code == CodeInternalServerError {
+		logging.FromContext(in.Context()).Error("request failed", "error", err)
+		Write(out, in, code, "")
+		return
+	}
+	Write(out, in, code, err.Error())
+}
+
+// FromError returns the code of the problem err corresponds to.
+func FromError(err error) Code {
+	switch {
+	case errors.Is(err, idp.ErrDuplicateUsername):
+		return CodeUsernameTaken
+	case errors.Is(err, idp.ErrBadCredentials), errors.Is(err, idp.ErrUnknownIdentity):
+		return CodeBadCredentials
+	case errors.Is(err, idp.ErrOrderInvalid):
+		return CodeInvalidOrderNumber
+	case errors.Is(err, idp.ErrOrderUnowned):
+		return CodeOrderUnowned
+	case errors.Is(err, idp.ErrBalanceTooLow):
+		return CodeInsufficientBalance
+	case errors.Is(err, idp.ErrWithdrawalDuplicate):
+		return CodeWithdrawalDuplicate
+	case errors.Is(err, idp.ErrWithdrawalSumInvalid):
+		return CodeInvalidWithdrawalSum
+	case errors.Is(err, idp.ErrWebhookURLInvalid):
+		return CodeInvalidWebhookURL
+	case errors.Is(err, idp.ErrUnknownWebhook):
+		return CodeNotFound
+	case errors.Is(err, listing.ErrBadQuery):
+		return CodeInvalidQuery
+	case errors.Is(err, idp.ErrUnavailable):
+		return CodeServiceUnavailable
+	}
+	return CodeInternalServerError
+}
@@ -0,0 +1,27 @@
+// Package problemtest checks the problem details written by the handlers in tests.
+package problemtest
+
+import (
+	"encoding/json"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"net/http/httptest"
+	"testing"
+)
+
+// Check fails the test unless the recorded response is the problem with the code.
+func Check(t *testing.T, recorder *httptest.ResponseRecorder, code problem.Code) {
+	t.Helper()
+	if recorder.Code != code.Status() {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, code.Status(), recorder.Body)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != problem.ContentType {
+		t.Fatalf("Content-Type = %q, want %q", contentType, problem.ContentType)
+	}
+	var body problem.Problem
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("problem %q: %v", recorder.Body.String(), err)
+	}
+	if body.Code != code || body.Status != code.Status() || body.Title != code.Title() {
+		t.Fatalf("problem = %+v, want code %q, status %d and title %q", body, code, code.Status(), code.Title())
+	}
+}
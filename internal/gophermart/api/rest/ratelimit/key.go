@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/clientip"
+	"net/http"
+	"net/netip"
+	"strconv"
+)
+
+// Key returns the key the request is limited by,
+// the requests with the empty key are not limited.
+type Key func(in *http.Request) string
+
+// ByIP limits the requests by the address of the client.
+func ByIP(resolver clientip.Resolver) Key {
+	return func(in *http.Request) string {
+		return IPKey(resolver.IP(in))
+	}
+}
+
+// ByUser limits the requests by the authorized user,
+// it must be used after the authorization middleware.
+func ByUser(in *http.Request) string {
+	return UserKey(authorization.User(in).ID())
+}
+
+// IPKey returns the key of the requests of the client address,
+// the same for the REST and the gRPC requests.
+func IPKey(addr netip.Addr) string {
+	return "ip:" + addr.String()
+}
+
+// UserKey returns the key of the requests of the user,
+// the same for the REST and the gRPC requests.
+func UserKey(user int64) string {
+	return "user:" + strconv.FormatInt(user, 10)
+}
+
+// ForMethods limits only the requests with the methods by the key.
+func ForMethods(key Key, methods ...string) Key {
+	return func(in *http.Request) string {
+		for _, method := range methods {
+			if in.Method == method {
+				return key(in)
+			}
+		}
+		return ""
+	}
+}
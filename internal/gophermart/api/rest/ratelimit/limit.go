@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is the number of requests allowed per period, the requests may
+// come in a burst as long as their average rate is within the limit.
+// The zero Limit does not limit the requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses a limit in the "<requests>/<period>" format, where the period
+// is "s", "m", "h" or a duration (e.g. "10/s", "100/m", "5/30s"), "off" disables the limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" || s == "0" {
+		return Limit{}, nil
+	}
+	requests, period, found := strings.Cut(s, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected <requests>/<period>", s)
+	}
+	n, parseRequestsError := strconv.Atoi(requests)
+	if parseRequestsError != nil || n < 0 {
+		return Limit{}, fmt.Errorf("invalid number of requests in rate limit %q", s)
+	}
+	var per time.Duration
+	switch period {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		d, parsePeriodError := time.ParseDuration(period)
+		if parsePeriodError != nil || d <= 0 {
+			return Limit{}, fmt.Errorf("invalid period in rate limit %q", s)
+		}
+		per = d
+	}
+	if n == 0 {
+		return Limit{}, nil
+	}
+	return Limit{Requests: n, Per: per}, nil
+}
+
+// Enabled reports whether the limit limits the requests.
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	switch l.Per {
+	case time.Second:
+		return fmt.Sprintf("%d/s", l.Requests)
+	case time.Minute:
+		return fmt.Sprintf("%d/m", l.Requests)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", l.Requests)
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// UnmarshalText parses the limit with ParseLimit.
+func (l *Limit) UnmarshalText(text []byte) error {
+	parsed, parseError := ParseLimit(string(text))
+	if parseError != nil {
+		return parseError
+	}
+	*l = parsed
+	return nil
+}
+
+// MarshalText formats the limit as ParseLimit expects it.
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
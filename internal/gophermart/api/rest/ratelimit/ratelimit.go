@@ -0,0 +1,137 @@
+// Package ratelimit limits the rate of the requests with token buckets.
+package ratelimit
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// minSweep is the number of buckets after which the full buckets are dropped.
+const minSweep = 1024
+
+// RateLimit returns a middleware that limits the requests with the same key
+// by the limiter, the requests over the limit are answered with 429 Too Many Requests
+// and a Retry-After header.
+//
+// The route groups using different limiters are limited separately.
+func RateLimit(limiter *Limiter, key Key) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+			k := key(in)
+			if k == "" {
+				next.ServeHTTP(out, in)
+				return
+			}
+			limit, remaining, wait, limited := limiter.Take(k, time.Now())
+			if !limited {
+				next.ServeHTTP(out, in)
+				return
+			}
+			out.Header().Set("X-RateLimit-Limit", limit.String())
+			out.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			if wait > 0 {
+				out.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				problem.Write(out, in, problem.CodeTooManyRequests, "retry in "+wait.Round(time.Millisecond).String())
+				return
+			}
+			next.ServeHTTP(out, in)
+		})
+	}
+}
+
+// Limiter keeps a token bucket of every key.
+type Limiter struct {
+	limit Limit
+
+	mutex     *sync.Mutex
+	buckets   map[string]*bucket
+	nextSweep int
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewLimiter creates a new Limiter.
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{
+		limit:     limit,
+		mutex:     &sync.Mutex{},
+		buckets:   make(map[string]*bucket),
+		nextSweep: minSweep,
+	}
+}
+
+// Limit returns the limit of the limiter.
+func (l *Limiter) Limit() Limit {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limit
+}
+
+// SetLimit changes the limit of the limiter, the buckets start full with the new limit.
+func (l *Limiter) SetLimit(limit Limit) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if limit == l.limit {
+		return
+	}
+	l.limit = limit
+	l.buckets = make(map[string]*bucket)
+	l.nextSweep = minSweep
+}
+
+// Take takes a token from the bucket of the key and returns the limit and the number of the tokens left,
+// or how long to wait for a token if the bucket is empty; limited is false if the limit is disabled.
+func (l *Limiter) Take(key string, now time.Time) (limit Limit, remaining int, wait time.Duration, limited bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.limit.Enabled() {
+		return l.limit, 0, 0, false
+	}
+
+	capacity := float64(l.limit.Requests)
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.nextSweep {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: capacity, updated: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	if b.tokens < 1 {
+		return l.limit, 0, time.Duration((1 - b.tokens) / capacity * float64(l.limit.Per)), true
+	}
+	b.tokens--
+	return l.limit, int(b.tokens), 0, true
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	capacity := float64(l.limit.Requests)
+	elapsed := now.Sub(b.updated)
+	if elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed.Seconds()/l.limit.Per.Seconds()*capacity)
+		b.updated = now
+	}
+}
+
+// sweep drops the full buckets, as they are the same as the missing ones.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= float64(l.limit.Requests) {
+			delete(l.buckets, key)
+		}
+	}
+	l.nextSweep = 2 * len(l.buckets)
+	if l.nextSweep < minSweep {
+		l.nextSweep = minSweep
+	}
+}
@@ -0,0 +1,123 @@
+package ratelimit_test
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterRefill(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{Requests: 2, Per: time.Second})
+	start := time.Now()
+
+	tests := []struct {
+		name      string
+		elapsed   time.Duration
+		key       string
+		remaining int
+		wait      time.Duration
+	}{
+		{"First", 0, "a", 1, 0},
+		{"Burst", 0, "a", 0, 0},
+		{"Empty", 0, "a", 0, 500 * time.Millisecond},
+		{"OtherKey", 0, "b", 1, 0},
+		{"HalfRefilled", 250 * time.Millisecond, "a", 0, 250 * time.Millisecond},
+		{"Refilled", 500 * time.Millisecond, "a", 0, 0},
+		{"NotOverfilled", time.Hour, "a", 1, 0},
+	}
+	for _, test := range tests {
+		limit, remaining, wait, limited := limiter.Take(test.key, start.Add(test.elapsed))
+		if !limited || limit != (ratelimit.Limit{Requests: 2, Per: time.Second}) {
+			t.Fatalf("%s: Take() limit = %v, %v, want 2/s", test.name, limit, limited)
+		}
+		if remaining != test.remaining || wait != test.wait {
+			t.Fatalf("%s: Take() = %d remaining, wait %s, want %d, %s", test.name, remaining, wait, test.remaining, test.wait)
+		}
+	}
+}
+
+func TestLimiterSetLimit(t *testing.T) {
+	now := time.Now()
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{Requests: 1, Per: time.Minute})
+	if _, _, wait, _ := limiter.Take("a", now); wait != 0 {
+		t.Fatalf("Take() wait = %s, want 0", wait)
+	}
+
+	limiter.SetLimit(ratelimit.Limit{Requests: 1, Per: time.Minute})
+	if _, _, wait, _ := limiter.Take("a", now); wait == 0 {
+		t.Fatal("Take() after SetLimit() of the same limit has not waited, want the bucket kept")
+	}
+
+	limiter.SetLimit(ratelimit.Limit{Requests: 3, Per: time.Minute})
+	if _, remaining, wait, _ := limiter.Take("a", now); wait != 0 || remaining != 2 {
+		t.Fatalf("Take() after SetLimit() = %d remaining, wait %s, want a full bucket of 3", remaining, wait)
+	}
+
+	limiter.SetLimit(ratelimit.Limit{})
+	if _, _, _, limited := limiter.Take("a", now); limited {
+		t.Fatal("Take() of a disabled limit is limited")
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      ratelimit.Limit
+		requests   int
+		status     int
+		retryAfter string
+	}{
+		{"WithinLimit", ratelimit.Limit{Requests: 2, Per: time.Minute}, 2, http.StatusNoContent, ""},
+		{"WholeSeconds", ratelimit.Limit{Requests: 1, Per: time.Minute}, 2, http.StatusTooManyRequests, "60"},
+		{"RoundedUp", ratelimit.Limit{Requests: 3, Per: 10 * time.Second}, 4, http.StatusTooManyRequests, "4"},
+		{"SubSecond", ratelimit.Limit{Requests: 10, Per: time.Second}, 11, http.StatusTooManyRequests, "1"},
+		{"Disabled", ratelimit.Limit{}, 100, http.StatusNoContent, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := func(*http.Request) string { return "key" }
+			handler := ratelimit.RateLimit(ratelimit.NewLimiter(test.limit), key)(http.HandlerFunc(func(out http.ResponseWriter, _ *http.Request) {
+				out.WriteHeader(http.StatusNoContent)
+			}))
+			var recorder *httptest.ResponseRecorder
+			for i := 0; i < test.requests; i++ {
+				recorder = httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+			if recorder.Code != test.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.status)
+			}
+			if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != test.retryAfter {
+				t.Fatalf("Retry-After = %q, want %q", retryAfter, test.retryAfter)
+			}
+		})
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    ratelimit.Limit
+		invalid bool
+	}{
+		{s: "10/s", want: ratelimit.Limit{Requests: 10, Per: time.Second}},
+		{s: " 100/m ", want: ratelimit.Limit{Requests: 100, Per: time.Minute}},
+		{s: "5/30s", want: ratelimit.Limit{Requests: 5, Per: 30 * time.Second}},
+		{s: "off"},
+		{s: "0/s"},
+		{s: "10", invalid: true},
+		{s: "-1/s", invalid: true},
+		{s: "10/0s", invalid: true},
+		{s: "10/week", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			limit, err := ratelimit.ParseLimit(test.s)
+			if test.invalid != (err != nil) || limit != test.want {
+				t.Fatalf("ParseLimit() = %v, %v, want %v, invalid %v", limit, err, test.want, test.invalid)
+			}
+		})
+	}
+}
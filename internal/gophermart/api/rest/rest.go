@@ -2,25 +2,51 @@ package rest
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/clientip"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/openapi"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user"
 	"github.com/kerelape/gophermart/internal/gophermart/idp"
 )
 
 type REST struct {
-	user user.User
+	user    user.User
+	openapi openapi.OpenAPI
 }
 
 // New creates a new REST.
-func New(idp idp.IdentityProvider) REST {
+//
+// In test mode the responses are validated against the OpenAPI specification.
+func New(
+	idp idp.IdentityProvider,
+	broker idp.Broker,
+	store idp.IdempotencyStore,
+	adjustments idp.AdjustmentHistory,
+	idempotencyWindow time.Duration,
+	maxRequestSize int64,
+	rateLimiters user.RateLimiters,
+	clientIP clientip.Resolver,
+	testMode bool,
+) REST {
 	return REST{
-		user: user.New(idp),
+		user:    user.New(idp, broker, store, adjustments, idempotencyWindow, maxRequestSize, rateLimiters, clientIP),
+		openapi: openapi.New(testMode),
 	}
 }
 
 func (r REST) Route() http.Handler {
 	router := chi.NewRouter()
+	router.NotFound(func(out http.ResponseWriter, in *http.Request) {
+		problem.Write(out, in, problem.CodeNotFound, "")
+	})
+	router.MethodNotAllowed(func(out http.ResponseWriter, in *http.Request) {
+		problem.Write(out, in, problem.CodeMethodNotAllowed, "")
+	})
+	router.Use(r.openapi.Validation)
+	router.Get("/openapi.json", r.openapi.ServeHTTP)
 	router.Mount("/user", r.user.Route())
 	return router
 }
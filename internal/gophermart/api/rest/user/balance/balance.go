@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"github.com/go-chi/chi/v5"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/balance/withdraw"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
 	"net/http"
 )
 
@@ -27,14 +29,11 @@ func (b Balance) Route() http.Handler {
 }
 
 func (b Balance) ServeHTTP(out http.ResponseWriter, in *http.Request) {
-	out.Header().Set("Content-Type", "application/json")
-
 	user := authorization.User(in)
 
 	balance, balanceError := user.Balance(in.Context())
 	if balanceError != nil {
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, balanceError)
 		return
 	}
 
@@ -45,16 +44,13 @@ func (b Balance) ServeHTTP(out http.ResponseWriter, in *http.Request) {
 
 	responseBody, marshalResponseBodyError := json.Marshal(response)
 	if marshalResponseBodyError != nil {
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, marshalResponseBodyError)
 		return
 	}
 
+	out.Header().Set("Content-Type", "application/json")
+	out.WriteHeader(http.StatusOK)
 	if _, err := out.Write(responseBody); err != nil {
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
-		return
+		logging.FromContext(in.Context()).Error("failed to write balance", "error", err)
 	}
-
-	out.WriteHeader(http.StatusOK)
 }
@@ -2,10 +2,9 @@ package withdraw
 
 import (
 	"encoding/json"
-	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
-	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
 	"net/http"
 )
 
@@ -32,21 +31,13 @@ func (w Withdraw) ServeHTTP(out http.ResponseWriter, in *http.Request) {
 	}
 	decodeRequestError := json.NewDecoder(in.Body).Decode(&request)
 	if decodeRequestError != nil {
-		status := http.StatusBadRequest
-		http.Error(out, http.StatusText(status), status)
+		problem.Body(out, in, decodeRequestError)
 		return
 	}
 
 	withdrawError := user.Withdraw(in.Context(), request.Order, request.Sum)
 	if withdrawError != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(withdrawError, idp.ErrBalanceTooLow) {
-			status = http.StatusPaymentRequired
-		}
-		if errors.Is(withdrawError, idp.ErrOrderInvalid) {
-			status = http.StatusUnprocessableEntity
-		}
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, withdrawError)
 		return
 	}
 
@@ -0,0 +1,66 @@
+package withdraw_test
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem/problemtest"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/balance/withdraw"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithdraw(t *testing.T) {
+	ctx := context.Background()
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+	token, identity := idptest.Authenticate(t, provider, database, "alice")
+	if err := database.AdjustBalance(ctx, identity.ID(), 100, "test"); err != nil {
+		t.Fatal(err)
+	}
+	handler := authorization.Authorization(provider)(withdraw.New().Route())
+
+	tests := []struct {
+		name string
+		body string
+		want int
+		code problem.Code
+	}{
+		{name: "withdrawal", body: `{"order":"2377225624","sum":40}`, want: http.StatusOK},
+		{name: "second withdrawal towards the order", body: `{"order":"2377225624","sum":10}`, want: http.StatusConflict, code: problem.CodeWithdrawalDuplicate},
+		{name: "more than the balance", body: `{"order":"12345678903","sum":60.01}`, want: http.StatusPaymentRequired, code: problem.CodeInsufficientBalance},
+		{name: "invalid order", body: `{"order":"12345678902","sum":10}`, want: http.StatusUnprocessableEntity, code: problem.CodeInvalidOrderNumber},
+		{name: "zero sum", body: `{"order":"12345678903","sum":0}`, want: http.StatusUnprocessableEntity, code: problem.CodeInvalidWithdrawalSum},
+		{name: "negative sum", body: `{"order":"12345678903","sum":-10}`, want: http.StatusUnprocessableEntity, code: problem.CodeInvalidWithdrawalSum},
+		{name: "malformed body", body: `{"order":12345678903}`, want: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("Authorization", string(token))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.want, recorder.Body)
+			}
+			if test.code != "" {
+				problemtest.Check(t, recorder, test.code)
+			}
+		})
+	}
+
+	balance, balanceError := identity.Balance(ctx)
+	if balanceError != nil {
+		t.Fatal(balanceError)
+	}
+	if want := (idp.Balance{Current: 60, Withdrawn: 40}); balance != want {
+		t.Fatalf("Balance() = %v, want %v", balance, want)
+	}
+}
@@ -0,0 +1,26 @@
+package deletion
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"net/http"
+)
+
+type Deletion struct {
+}
+
+// New creates a new Deletion.
+func New() Deletion {
+	return Deletion{}
+}
+
+func (d Deletion) ServeHTTP(out http.ResponseWriter, in *http.Request) {
+	user := authorization.User(in)
+
+	if err := user.Delete(in.Context()); err != nil {
+		problem.Error(out, in, err)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,147 @@
+// Package events streams the changes of the user's orders and balance as Server-Sent Events.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"net/http"
+	"time"
+)
+
+// keepAlive is the period of the comments sent to keep idle connections open.
+const keepAlive = 15 * time.Second
+
+type Events struct {
+	broker idp.Broker
+}
+
+// New creates a new Events.
+func New(broker idp.Broker) Events {
+	return Events{
+		broker: broker,
+	}
+}
+
+func (e Events) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Get("/", e.ServeHTTP)
+	return router
+}
+
+// ServeHTTP streams the events of the user:
+//
+//	event: balance
+//	data: {"current":500.5,"withdrawn":42}
+//
+//	event: order
+//	data: {"number":"9278923470","status":"PROCESSED","accrual":500,"uploaded_at":"2020-12-10T15:15:45+03:00"}
+//
+//	event: withdrawal
+//	data: {"order":"2377225624","sum":500,"processed_at":"2020-12-09T16:09:57+03:00"}
+//
+// The stream starts with the current balance.
+func (e Events) ServeHTTP(out http.ResponseWriter, in *http.Request) {
+	user := authorization.User(in)
+
+	flusher, ok := out.(http.Flusher)
+	if !ok {
+		problem.Error(out, in, errors.New("streaming is not supported by the response writer"))
+		return
+	}
+
+	// Subscribe before reading the balance, so that no change is missed in between.
+	events := e.broker.Subscribe(in.Context(), user.ID())
+	balance, balanceError := user.Balance(in.Context())
+	if balanceError != nil {
+		problem.Error(out, in, balanceError)
+		return
+	}
+
+	out.Header().Set("Content-Type", "text/event-stream")
+	out.Header().Set("Cache-Control", "no-cache")
+	out.Header().Set("X-Accel-Buffering", "no")
+	out.WriteHeader(http.StatusOK)
+	if err := writeEvent(out, "balance", balanceJSON(balance)); err != nil {
+		logging.FromContext(in.Context()).Debug("failed to write event", "error", err)
+		return
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvents(out, event); err != nil {
+				logging.FromContext(in.Context()).Debug("failed to write event", "error", err)
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(out, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvents(out http.ResponseWriter, event idp.Event) error {
+	if event.Order != nil {
+		if err := writeEvent(out, "order", orderJSON(*event.Order)); err != nil {
+			return err
+		}
+	}
+	if w := event.Withdrawal; w != nil {
+		withdrawal := map[string]any{
+			"order":        w.Order,
+			"sum":          w.Sum,
+			"processed_at": w.Time.Format(time.RFC3339),
+		}
+		if err := writeEvent(out, "withdrawal", withdrawal); err != nil {
+			return err
+		}
+	}
+	if event.Balance != nil {
+		if err := writeEvent(out, "balance", balanceJSON(*event.Balance)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeEvent(out http.ResponseWriter, name string, data any) error {
+	encoded, encodeError := json.Marshal(data)
+	if encodeError != nil {
+		return encodeError
+	}
+	_, writeError := fmt.Fprintf(out, "event: %s\ndata: %s\n\n", name, encoded)
+	return writeError
+}
+
+func orderJSON(o idp.Order) map[string]any {
+	order := map[string]any{
+		"number":      o.ID,
+		"uploaded_at": o.Time.Format(time.RFC3339),
+		"status":      string(o.Status),
+	}
+	if o.Accrual > 0 {
+		order["accrual"] = o.Accrual
+	}
+	return order
+}
+
+func balanceJSON(b idp.Balance) map[string]any {
+	return map[string]any{
+		"current":   b.Current,
+		"withdrawn": b.Withdrawn,
+	}
+}
@@ -0,0 +1,132 @@
+package export
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/ledger"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"net/http"
+	"time"
+)
+
+type Export struct {
+	adjustments idp.AdjustmentHistory
+}
+
+// New creates a new Export.
+func New(adjustments idp.AdjustmentHistory) Export {
+	return Export{adjustments: adjustments}
+}
+
+func (e Export) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Get("/", e.ServeHTTP)
+	return router
+}
+
+func (e Export) ServeHTTP(out http.ResponseWriter, in *http.Request) {
+	user := authorization.User(in)
+
+	username, usernameError := user.Username(in.Context())
+	if usernameError != nil {
+		problem.Error(out, in, usernameError)
+		return
+	}
+
+	orders, ordersError := user.Orders(in.Context(), idp.OrderQuery{})
+	if ordersError != nil {
+		problem.Error(out, in, ordersError)
+		return
+	}
+
+	withdrawals, withdrawalsError := user.Withdrawals(in.Context(), idp.ListQuery{})
+	if withdrawalsError != nil {
+		problem.Error(out, in, withdrawalsError)
+		return
+	}
+
+	adjustments, adjustmentsError := e.adjustments.Adjustments(in.Context(), user.ID())
+	if adjustmentsError != nil {
+		problem.Error(out, in, adjustmentsError)
+		return
+	}
+
+	balance, balanceError := user.Balance(in.Context())
+	if balanceError != nil {
+		problem.Error(out, in, balanceError)
+		return
+	}
+
+	response := map[string]any{
+		"exported_at": time.Now().Format(time.RFC3339),
+		"identity": map[string]any{
+			"login": username,
+		},
+		"balance": map[string]any{
+			"current":   balance.Current,
+			"withdrawn": balance.Withdrawn,
+		},
+		"orders":          formatOrders(orders),
+		"withdrawals":     formatWithdrawals(withdrawals),
+		"balance_history": balanceHistory(ledger.Make(orders, withdrawals, adjustments, time.Time{}, time.Time{})),
+	}
+
+	responseBody, marshalResponseBodyError := json.Marshal(response)
+	if marshalResponseBodyError != nil {
+		problem.Error(out, in, marshalResponseBodyError)
+		return
+	}
+
+	out.Header().Set("Content-Type", "application/json")
+	out.Header().Set("Content-Disposition", `attachment; filename="gophermart-export.json"`)
+	out.WriteHeader(http.StatusOK)
+	if _, err := out.Write(responseBody); err != nil {
+		logging.FromContext(in.Context()).Error("failed to write export", "error", err)
+	}
+}
+
+func formatOrders(orders []idp.Order) []map[string]any {
+	result := make([]map[string]any, len(orders))
+	for i, o := range orders {
+		result[i] = map[string]any{
+			"number":      o.ID,
+			"status":      string(o.Status),
+			"accrual":     o.Accrual,
+			"uploaded_at": o.Time.Format(time.RFC3339),
+		}
+		if !o.Processed.IsZero() {
+			result[i]["processed_at"] = o.Processed.Format(time.RFC3339)
+		}
+	}
+	return result
+}
+
+func formatWithdrawals(withdrawals []idp.Withdrawal) []map[string]any {
+	result := make([]map[string]any, len(withdrawals))
+	for i, w := range withdrawals {
+		result[i] = map[string]any{
+			"order":        w.Order,
+			"sum":          w.Sum,
+			"processed_at": w.Time.Format(time.RFC3339),
+		}
+	}
+	return result
+}
+
+// balanceHistory formats the entries of the statement of the whole history of the balance.
+func balanceHistory(statement ledger.Statement) []map[string]any {
+	result := make([]map[string]any, len(statement.Entries))
+	for i, e := range statement.Entries {
+		result[i] = map[string]any{
+			"type":    e.Type,
+			"order":   e.Order,
+			"amount":  e.Amount,
+			"balance": e.Balance,
+			"time":    e.Time.Format(time.RFC3339),
+		}
+	}
+	return result
+}
@@ -2,10 +2,10 @@ package login
 
 import (
 	"encoding/json"
-	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
 	"github.com/kerelape/gophermart/internal/gophermart/idp"
 )
 
@@ -33,18 +33,13 @@ func (l Login) ServeHTTP(out http.ResponseWriter, in *http.Request) {
 	}
 	decodeRequestError := json.NewDecoder(in.Body).Decode(&request)
 	if decodeRequestError != nil {
-		status := http.StatusBadRequest
-		http.Error(out, http.StatusText(status), status)
+		problem.Body(out, in, decodeRequestError)
 		return
 	}
 
 	token, authenticateError := l.IdentityProvider.Authenticate(in.Context(), request.Login, request.Password)
 	if authenticateError != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(authenticateError, idp.ErrBadCredentials) {
-			status = http.StatusUnauthorized
-		}
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, authenticateError)
 		return
 	}
 	out.Header().Set("Authorization", string(token))
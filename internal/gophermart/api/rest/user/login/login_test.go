@@ -0,0 +1,52 @@
+package login_test
+
+import (
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem/problemtest"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/login"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+	idptest.Authenticate(t, provider, database, "alice")
+	handler := login.New(provider).Route()
+
+	tests := []struct {
+		name string
+		body string
+		want int
+		code problem.Code
+	}{
+		{name: "right password", body: `{"login":"alice","password":"password"}`, want: http.StatusOK},
+		{name: "wrong password", body: `{"login":"alice","password":"wrong"}`, want: http.StatusUnauthorized, code: problem.CodeBadCredentials},
+		{name: "unknown login", body: `{"login":"bob","password":"password"}`, want: http.StatusUnauthorized, code: problem.CodeBadCredentials},
+		{name: "malformed body", body: `[]`, want: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.want)
+			}
+			if test.code == "" {
+				if recorder.Header().Get("Authorization") == "" {
+					t.Fatal("no Authorization header in the response")
+				}
+				return
+			}
+			problemtest.Check(t, recorder, test.code)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package modification
+
+import (
+	"encoding/json"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"net/http"
+)
+
+type Modification struct {
+}
+
+// New creates a new Modification.
+func New() Modification {
+	return Modification{}
+}
+
+func (m Modification) ServeHTTP(out http.ResponseWriter, in *http.Request) {
+	user := authorization.User(in)
+
+	var request struct {
+		Login string `json:"login"`
+	}
+	decodeRequestError := json.NewDecoder(in.Body).Decode(&request)
+	if decodeRequestError != nil {
+		problem.Body(out, in, decodeRequestError)
+		return
+	}
+	if request.Login == "" {
+		problem.Write(out, in, problem.CodeInvalidLogin, "the login must not be empty")
+		return
+	}
+
+	setUsernameError := user.SetUsername(in.Context(), request.Login)
+	if setUsernameError != nil {
+		problem.Error(out, in, setUsernameError)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
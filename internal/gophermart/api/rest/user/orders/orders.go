@@ -5,10 +5,13 @@ import (
 	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/listing"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
 	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
 	"io"
-	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -32,55 +35,61 @@ func (o Orders) upload(out http.ResponseWriter, in *http.Request) {
 
 	order, readOrderError := io.ReadAll(in.Body)
 	if readOrderError != nil {
-		status := http.StatusBadRequest
-		http.Error(out, http.StatusText(status), status)
+		problem.Body(out, in, readOrderError)
+		return
+	}
+	if strings.TrimSpace(string(order)) == "" {
+		problem.Write(out, in, problem.CodeEmptyBody, "the order number is expected in the request body")
 		return
 	}
 
 	addOrderError := user.AddOrder(in.Context(), string(order))
 	if addOrderError != nil {
-		log.Printf("failed to add order: %v", addOrderError)
 		if errors.Is(addOrderError, idp.ErrOrderDuplicate) {
 			out.WriteHeader(http.StatusOK)
 			return
 		}
-		status := http.StatusInternalServerError
-		if errors.Is(addOrderError, idp.ErrOrderInvalid) {
-			status = http.StatusUnprocessableEntity
-		}
-		if errors.Is(addOrderError, idp.ErrOrderUnowned) {
-			status = http.StatusConflict
-		}
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, addOrderError)
 		return
 	}
 	out.WriteHeader(http.StatusAccepted)
 }
 
 func (o Orders) list(out http.ResponseWriter, in *http.Request) {
-	out.Header().Set("Content-Type", "application/json")
-
 	user := authorization.User(in)
 
-	orders, ordersError := user.Orders(in.Context())
+	listQuery, listQueryError := listing.Query(in)
+	if listQueryError != nil {
+		problem.Error(out, in, listQueryError)
+		return
+	}
+	statuses, statusesError := listing.Statuses(in)
+	if statusesError != nil {
+		problem.Error(out, in, statusesError)
+		return
+	}
+
+	orders, ordersError := user.Orders(in.Context(), idp.OrderQuery{
+		ListQuery: listing.Page(listQuery),
+		Statuses:  statuses,
+	})
 	if ordersError != nil {
-		log.Printf("failed to get orders: %v", ordersError)
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, ordersError)
 		return
 	}
 	if len(orders) == 0 {
-		status := http.StatusNoContent
-		http.Error(out, http.StatusText(status), status)
+		out.WriteHeader(http.StatusNoContent)
 		return
 	}
 
+	orders = listing.Paginate(out, in, listQuery, orders, idp.Order.Cursor)
+
 	response := make([]any, 0)
 	for _, o := range orders {
 		order := map[string]any{
-			"number":     o.ID,
-			"created_at": o.Time.Format(time.RFC3339),
-			"status":     string(o.Status),
+			"number":      o.ID,
+			"uploaded_at": o.Time.Format(time.RFC3339),
+			"status":      string(o.Status),
 		}
 		if o.Accrual > 0 {
 			order["accrual"] = o.Accrual
@@ -90,16 +99,13 @@ func (o Orders) list(out http.ResponseWriter, in *http.Request) {
 
 	responseBody, marshalResponseBodyError := json.Marshal(response)
 	if marshalResponseBodyError != nil {
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, marshalResponseBodyError)
 		return
 	}
 
+	out.Header().Set("Content-Type", "application/json")
+	out.WriteHeader(http.StatusOK)
 	if _, err := out.Write(responseBody); err != nil {
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
-		return
+		logging.FromContext(in.Context()).Error("failed to write orders", "error", err)
 	}
-
-	out.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,83 @@
+package orders_test
+
+import (
+	"encoding/json"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem/problemtest"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/orders"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOrders(t *testing.T) {
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+	alice, _ := idptest.Authenticate(t, provider, database, "alice")
+	bob, _ := idptest.Authenticate(t, provider, database, "bob")
+	handler := authorization.Authorization(provider)(orders.New().Route())
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  idp.Token
+		body   string
+		want   int
+		code   problem.Code
+	}{
+		{name: "no orders", method: http.MethodGet, token: alice, want: http.StatusNoContent},
+		{name: "new order", method: http.MethodPost, token: alice, body: "12345678903", want: http.StatusAccepted},
+		{name: "uploaded order", method: http.MethodPost, token: alice, body: "12345678903", want: http.StatusOK},
+		{name: "order of another user", method: http.MethodPost, token: bob, body: "12345678903", want: http.StatusConflict, code: problem.CodeOrderUnowned},
+		{name: "invalid order", method: http.MethodPost, token: alice, body: "12345678902", want: http.StatusUnprocessableEntity, code: problem.CodeInvalidOrderNumber},
+		{name: "empty body", method: http.MethodPost, token: alice, body: " ", want: http.StatusBadRequest, code: problem.CodeEmptyBody},
+		{name: "invalid query", method: http.MethodGet, target: "/?limit=0", token: alice, want: http.StatusBadRequest, code: problem.CodeInvalidQuery},
+		{name: "no token", method: http.MethodGet, want: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := test.target
+			if target == "" {
+				target = "/"
+			}
+			request := httptest.NewRequest(test.method, target, strings.NewReader(test.body))
+			request.Header.Set("Content-Type", "text/plain")
+			if test.token != "" {
+				request.Header.Set("Authorization", string(test.token))
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.want, recorder.Body)
+			}
+			if test.code != "" {
+				problemtest.Check(t, recorder, test.code)
+			}
+		})
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", string(alice))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status of the list = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	var listed []struct {
+		Number string `json:"number"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("list %q: %v", recorder.Body.String(), err)
+	}
+	if len(listed) != 1 || listed[0].Number != "12345678903" || listed[0].Status != string(idp.OrderStatusNew) {
+		t.Fatalf("list = %+v, want the new order 12345678903", listed)
+	}
+}
@@ -0,0 +1,39 @@
+package user
+
+import "github.com/kerelape/gophermart/internal/gophermart/api/rest/ratelimit"
+
+// RateLimits are the rate limits of the route groups of User.
+type RateLimits struct {
+	// Auth limits the registrations and the logins of a client address.
+	Auth ratelimit.Limit
+
+	// Orders limits the order uploads of a user.
+	Orders ratelimit.Limit
+
+	// User limits all requests of a user.
+	User ratelimit.Limit
+}
+
+// RateLimiters are the limiters of the route groups of User,
+// their limits may be changed while the requests are served.
+type RateLimiters struct {
+	Auth   *ratelimit.Limiter
+	Orders *ratelimit.Limiter
+	User   *ratelimit.Limiter
+}
+
+// NewRateLimiters creates new RateLimiters limiting the requests by limits.
+func NewRateLimiters(limits RateLimits) RateLimiters {
+	return RateLimiters{
+		Auth:   ratelimit.NewLimiter(limits.Auth),
+		Orders: ratelimit.NewLimiter(limits.Orders),
+		User:   ratelimit.NewLimiter(limits.User),
+	}
+}
+
+// SetLimits changes the limits of the limiters.
+func (r RateLimiters) SetLimits(limits RateLimits) {
+	r.Auth.SetLimit(limits.Auth)
+	r.Orders.SetLimit(limits.Orders)
+	r.User.SetLimit(limits.User)
+}
@@ -2,10 +2,10 @@ package register
 
 import (
 	"encoding/json"
-	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
 	"github.com/kerelape/gophermart/internal/gophermart/idp"
 )
 
@@ -33,27 +33,21 @@ func (r Register) ServeHTTP(out http.ResponseWriter, in *http.Request) {
 	}
 	decodeRequestError := json.NewDecoder(in.Body).Decode(&request)
 	if decodeRequestError != nil {
-		status := http.StatusBadRequest
-		http.Error(out, http.StatusText(status), status)
+		problem.Body(out, in, decodeRequestError)
 		return
 	}
 
 	// Register the user.
 	registerError := r.IdentityProvider.Register(in.Context(), request.Login, request.Password)
 	if registerError != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(registerError, idp.ErrDuplicateUsername) {
-			status = http.StatusConflict
-		}
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, registerError)
 		return
 	}
 
 	// Authenticate the user.
 	token, authenticateError := r.IdentityProvider.Authenticate(in.Context(), request.Login, request.Password)
 	if authenticateError != nil {
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, authenticateError)
 		return
 	}
 	out.Header().Add("Authorization", string(token))
@@ -0,0 +1,48 @@
+package register_test
+
+import (
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem/problemtest"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/register"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	handler := register.New(idp.NewBearerIdentityProvider(database, []byte("secret"))).Route()
+
+	tests := []struct {
+		name string
+		body string
+		want int
+		code problem.Code
+	}{
+		{name: "new login", body: `{"login":"alice","password":"password"}`, want: http.StatusOK},
+		{name: "taken login", body: `{"login":"alice","password":"other"}`, want: http.StatusConflict, code: problem.CodeUsernameTaken},
+		{name: "malformed body", body: `{"login":`, want: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.want)
+			}
+			if test.code == "" {
+				if recorder.Header().Get("Authorization") == "" {
+					t.Fatal("no Authorization header in the response")
+				}
+				return
+			}
+			problemtest.Check(t, recorder, test.code)
+		})
+	}
+}
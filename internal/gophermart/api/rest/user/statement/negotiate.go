@@ -0,0 +1,68 @@
+package statement
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// negotiate returns the offered media type the client prefers according to the Accept header,
+// the first offer if there is no header and false if none of the offers is acceptable.
+func negotiate(accept string, offers ...string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		if quality := acceptance(accept, offer); quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best, best != ""
+}
+
+// acceptance returns the quality of the most specific media range of the Accept header
+// that matches the media type, or zero if none matches.
+func acceptance(accept, mediaType string) float64 {
+	quality, specificity := 0.0, -1
+	for _, item := range strings.Split(accept, ",") {
+		mediaRange, parameters, parseError := mime.ParseMediaType(item)
+		if parseError != nil {
+			continue
+		}
+		s := matchMediaRange(mediaRange, mediaType)
+		if s <= specificity {
+			continue
+		}
+		q := 1.0
+		if value, ok := parameters["q"]; ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		quality, specificity = q, s
+	}
+	return quality
+}
+
+// matchMediaRange returns how specifically the media range matches the media type:
+// 2 for the same type, 1 for a type/* range and 0 for */*, or -1 if it does not match.
+func matchMediaRange(mediaRange, mediaType string) int {
+	if mediaRange == "*/*" {
+		return 0
+	}
+	rangeType, rangeSubtype, _ := strings.Cut(mediaRange, "/")
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	if rangeType != typ {
+		return -1
+	}
+	if rangeSubtype == "*" {
+		return 1
+	}
+	if rangeSubtype == subtype {
+		return 2
+	}
+	return -1
+}
@@ -0,0 +1,57 @@
+package statement
+
+import (
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem/problemtest"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name       string
+		accept     string
+		want       string
+		acceptable bool
+	}{
+		{name: "no header", accept: "", want: contentTypeJSON, acceptable: true},
+		{name: "exact type", accept: "text/csv", want: contentTypeCSV, acceptable: true},
+		{name: "any type", accept: "*/*", want: contentTypeJSON, acceptable: true},
+		{name: "any subtype", accept: "text/*", want: contentTypeCSV, acceptable: true},
+		{name: "higher quality", accept: "application/json;q=0.5, text/csv;q=0.9", want: contentTypeCSV, acceptable: true},
+		{name: "any type of lower quality", accept: "*/*;q=0.1, application/json", want: contentTypeJSON, acceptable: true},
+		{name: "specific range over any type", accept: "*/*, application/json;q=0", want: contentTypeCSV, acceptable: true},
+		{name: "zero quality", accept: "text/csv;q=0, application/json;q=0", acceptable: false},
+		{name: "malformed quality", accept: "text/csv;q=high", acceptable: false},
+		{name: "other type", accept: "application/xml", acceptable: false},
+		{name: "malformed range", accept: "text/csv;;, application/json", want: contentTypeJSON, acceptable: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, acceptable := negotiate(test.accept, contentTypeJSON, contentTypeCSV)
+			if got != test.want || acceptable != test.acceptable {
+				t.Fatalf("negotiate(%q) = %q, %v, want %q, %v", test.accept, got, acceptable, test.want, test.acceptable)
+			}
+		})
+	}
+}
+
+func TestStatementNotAcceptable(t *testing.T) {
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+	token, _ := idptest.Authenticate(t, provider, database, "alice")
+	handler := authorization.Authorization(provider)(New(database).Route())
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", string(token))
+	request.Header.Set("Accept", "application/xml")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	problemtest.Check(t, recorder, problem.CodeNotAcceptable)
+}
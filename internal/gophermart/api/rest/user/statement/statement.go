@@ -0,0 +1,151 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/ledger"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/listing"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	contentTypeJSON = "application/json"
+	contentTypeCSV  = "text/csv"
+)
+
+type Statement struct {
+	adjustments idp.AdjustmentHistory
+}
+
+// New creates a new Statement.
+func New(adjustments idp.AdjustmentHistory) Statement {
+	return Statement{adjustments: adjustments}
+}
+
+func (s Statement) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Get("/", s.ServeHTTP)
+	return router
+}
+
+// ServeHTTP writes the statement of the period set by the "from" and "to" query parameters
+// as JSON or CSV, whichever the client prefers.
+func (s Statement) ServeHTTP(out http.ResponseWriter, in *http.Request) {
+	user := authorization.User(in)
+
+	contentType, acceptable := negotiate(in.Header.Get("Accept"), contentTypeJSON, contentTypeCSV)
+	if !acceptable {
+		problem.Write(out, in, problem.CodeNotAcceptable, "the statement is available as "+contentTypeJSON+" or "+contentTypeCSV)
+		return
+	}
+
+	from, to, rangeError := listing.Range(in)
+	if rangeError != nil {
+		problem.Error(out, in, rangeError)
+		return
+	}
+
+	orders, ordersError := user.Orders(in.Context(), idp.OrderQuery{Statuses: []idp.OrderStatus{idp.OrderStatusProcessed}})
+	if ordersError != nil {
+		problem.Error(out, in, ordersError)
+		return
+	}
+
+	withdrawals, withdrawalsError := user.Withdrawals(in.Context(), idp.ListQuery{})
+	if withdrawalsError != nil {
+		problem.Error(out, in, withdrawalsError)
+		return
+	}
+
+	adjustments, adjustmentsError := s.adjustments.Adjustments(in.Context(), user.ID())
+	if adjustmentsError != nil {
+		problem.Error(out, in, adjustmentsError)
+		return
+	}
+
+	statement := ledger.Make(orders, withdrawals, adjustments, from, to)
+
+	var responseBody []byte
+	var encodeError error
+	switch contentType {
+	case contentTypeCSV:
+		responseBody, encodeError = encodeCSV(statement)
+	default:
+		responseBody, encodeError = encodeJSON(statement)
+	}
+	if encodeError != nil {
+		problem.Error(out, in, encodeError)
+		return
+	}
+
+	extension := "json"
+	if contentType == contentTypeCSV {
+		extension = "csv"
+		contentType += "; charset=utf-8"
+	}
+	out.Header().Set("Content-Type", contentType)
+	out.Header().Set("Content-Disposition", `attachment; filename="gophermart-statement.`+extension+`"`)
+	out.WriteHeader(http.StatusOK)
+	if _, err := out.Write(responseBody); err != nil {
+		logging.FromContext(in.Context()).Error("failed to write statement", "error", err)
+	}
+}
+
+func encodeJSON(statement ledger.Statement) ([]byte, error) {
+	entries := make([]map[string]any, len(statement.Entries))
+	for i, e := range statement.Entries {
+		entries[i] = map[string]any{
+			"type":    e.Type,
+			"order":   e.Order,
+			"amount":  e.Amount,
+			"balance": e.Balance,
+			"time":    e.Time.Format(time.RFC3339),
+		}
+	}
+
+	response := map[string]any{
+		"opening_balance": statement.OpeningBalance,
+		"closing_balance": statement.ClosingBalance,
+		"credited":        statement.Credited,
+		"debited":         statement.Debited,
+		"entries":         entries,
+	}
+	if !statement.From.IsZero() {
+		response["from"] = statement.From.Format(time.RFC3339)
+	}
+	if !statement.To.IsZero() {
+		response["to"] = statement.To.Format(time.RFC3339)
+	}
+	return json.Marshal(response)
+}
+
+// encodeCSV encodes the entries of the statement as CSV with a header row.
+func encodeCSV(statement ledger.Statement) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if err := writer.Write([]string{"time", "type", "order", "amount", "balance"}); err != nil {
+		return nil, err
+	}
+	for _, e := range statement.Entries {
+		record := []string{
+			e.Time.Format(time.RFC3339),
+			e.Type,
+			e.Order,
+			strconv.FormatFloat(e.Amount, 'f', -1, 64),
+			strconv.FormatFloat(e.Balance, 'f', -1, 64),
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
@@ -2,10 +2,21 @@ package user
 
 import (
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/clientip"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/conditional"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/idempotency"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/ratelimit"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/balance"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/deletion"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/events"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/export"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/modification"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/orders"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/statement"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/webhooks"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/withdrawals"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user/login"
@@ -14,37 +25,89 @@ import (
 )
 
 type User struct {
-	register    register.Register
-	login       login.Login
-	orders      orders.Orders
-	balance     balance.Balance
-	withdrawals withdrawals.Withdrawals
+	register     register.Register
+	login        login.Login
+	orders       orders.Orders
+	balance      balance.Balance
+	withdrawals  withdrawals.Withdrawals
+	statement    statement.Statement
+	export       export.Export
+	deletion     deletion.Deletion
+	modification modification.Modification
+	events       events.Events
+	webhooks     webhooks.Webhooks
 
 	identityProvider idp.IdentityProvider
+	idempotency      func(http.Handler) http.Handler
+	rateLimiters     RateLimiters
+	clientIP         clientip.Resolver
 }
 
 // New creates a new User.
-func New(identityProvider idp.IdentityProvider) User {
+//
+// The statements and the exports include the adjustments of the balances made by the operators from adjustments.
+// The responses to the order uploads and the withdrawals made with
+// an idempotency key are kept in store for idempotencyWindow,
+// the bodies of such requests are at most maxRequestSize bytes.
+// The requests of the clients, the addresses of which are resolved by clientIP,
+// are limited by rateLimiters.
+func New(
+	identityProvider idp.IdentityProvider,
+	broker idp.Broker,
+	store idp.IdempotencyStore,
+	adjustments idp.AdjustmentHistory,
+	idempotencyWindow time.Duration,
+	maxRequestSize int64,
+	rateLimiters RateLimiters,
+	clientIP clientip.Resolver,
+) User {
 	return User{
-		register:    register.New(identityProvider),
-		login:       login.New(identityProvider),
-		orders:      orders.New(),
-		balance:     balance.New(),
-		withdrawals: withdrawals.New(),
+		register:     register.New(identityProvider),
+		login:        login.New(identityProvider),
+		orders:       orders.New(),
+		balance:      balance.New(),
+		withdrawals:  withdrawals.New(),
+		statement:    statement.New(adjustments),
+		export:       export.New(adjustments),
+		deletion:     deletion.New(),
+		modification: modification.New(),
+		events:       events.New(broker),
+		webhooks:     webhooks.New(),
 
 		identityProvider: identityProvider,
+		idempotency:      idempotency.Idempotency(store, idempotencyWindow, maxRequestSize),
+		rateLimiters:     rateLimiters,
+		clientIP:         clientIP,
 	}
 }
 
 func (u User) Route() http.Handler {
 	router := chi.NewRouter()
-	router.Mount("/register", u.register.Route())
-	router.Mount("/login", u.login.Route())
+	router.Group(func(router chi.Router) {
+		router.Use(ratelimit.RateLimit(u.rateLimiters.Auth, ratelimit.ByIP(u.clientIP)))
+		router.Mount("/register", u.register.Route())
+		router.Mount("/login", u.login.Route())
+	})
 	router.Group(func(router chi.Router) {
 		router.Use(authorization.Authorization(u.identityProvider))
-		router.Mount("/orders", u.orders.Route())
-		router.Mount("/balance", u.balance.Route())
-		router.Mount("/withdrawals", u.withdrawals.Route())
+		router.Use(ratelimit.RateLimit(u.rateLimiters.User, ratelimit.ByUser))
+		router.Group(func(router chi.Router) {
+			router.Use(conditional.Conditional)
+			router.Group(func(router chi.Router) {
+				router.Use(u.idempotency)
+				router.With(
+					ratelimit.RateLimit(u.rateLimiters.Orders, ratelimit.ForMethods(ratelimit.ByUser, http.MethodPost)),
+				).Mount("/orders", u.orders.Route())
+				router.Mount("/balance", u.balance.Route())
+			})
+			router.Mount("/withdrawals", u.withdrawals.Route())
+			router.Mount("/statement", u.statement.Route())
+		})
+		router.Mount("/export", u.export.Route())
+		router.Mount("/events", u.events.Route())
+		router.Mount("/webhooks", u.webhooks.Route())
+		router.Delete("/", u.deletion.ServeHTTP)
+		router.Patch("/", u.modification.ServeHTTP)
 	})
 	return router
 }
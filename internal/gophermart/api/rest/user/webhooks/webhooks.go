@@ -0,0 +1,164 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/listing"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type Webhooks struct {
+}
+
+// New creates a new Webhooks.
+func New() Webhooks {
+	return Webhooks{}
+}
+
+func (w Webhooks) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Post("/", w.add)
+	router.Get("/", w.list)
+	router.Delete("/{id}", w.delete)
+	router.Get("/{id}/deliveries", w.deliveries)
+	return router
+}
+
+// add registers a webhook, the response has the secret the deliveries
+// are signed with, it is never shown again.
+func (w Webhooks) add(out http.ResponseWriter, in *http.Request) {
+	user := authorization.User(in)
+
+	var request struct {
+		URL string `json:"url"`
+	}
+	decodeRequestError := json.NewDecoder(in.Body).Decode(&request)
+	if decodeRequestError != nil {
+		problem.Body(out, in, decodeRequestError)
+		return
+	}
+
+	webhook, addWebhookError := user.AddWebhook(in.Context(), request.URL)
+	if addWebhookError != nil {
+		problem.Error(out, in, addWebhookError)
+		return
+	}
+
+	response := webhookJSON(webhook)
+	response["secret"] = webhook.Secret
+	writeJSON(out, in, http.StatusCreated, response)
+}
+
+func (w Webhooks) list(out http.ResponseWriter, in *http.Request) {
+	user := authorization.User(in)
+
+	webhooks, webhooksError := user.Webhooks(in.Context())
+	if webhooksError != nil {
+		problem.Error(out, in, webhooksError)
+		return
+	}
+	if len(webhooks) == 0 {
+		out.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := make([]map[string]any, len(webhooks))
+	for i, webhook := range webhooks {
+		response[i] = webhookJSON(webhook)
+	}
+	writeJSON(out, in, http.StatusOK, response)
+}
+
+func (w Webhooks) delete(out http.ResponseWriter, in *http.Request) {
+	user := authorization.User(in)
+
+	id, idError := strconv.ParseInt(chi.URLParam(in, "id"), 10, 64)
+	if idError != nil {
+		problem.Write(out, in, problem.CodeNotFound, "")
+		return
+	}
+
+	if err := user.DeleteWebhook(in.Context(), id); err != nil {
+		problem.Error(out, in, err)
+		return
+	}
+	out.WriteHeader(http.StatusNoContent)
+}
+
+// deliveries responds with the delivery history of the webhook, newest first,
+// the "limit" query parameter limits the number of the deliveries.
+func (w Webhooks) deliveries(out http.ResponseWriter, in *http.Request) {
+	user := authorization.User(in)
+
+	id, idError := strconv.ParseInt(chi.URLParam(in, "id"), 10, 64)
+	if idError != nil {
+		problem.Write(out, in, problem.CodeNotFound, "")
+		return
+	}
+	listQuery, listQueryError := listing.Query(in)
+	if listQueryError != nil {
+		problem.Error(out, in, listQueryError)
+		return
+	}
+
+	deliveries, deliveriesError := user.Deliveries(in.Context(), id, listQuery.Limit)
+	if deliveriesError != nil {
+		problem.Error(out, in, deliveriesError)
+		return
+	}
+	if len(deliveries) == 0 {
+		out.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := make([]map[string]any, len(deliveries))
+	for i, d := range deliveries {
+		delivery := map[string]any{
+			"id":         d.ID,
+			"event":      d.Event,
+			"status":     string(d.Status),
+			"attempts":   d.Attempts,
+			"created_at": d.Time.Format(time.RFC3339),
+			"updated_at": d.UpdatedAt.Format(time.RFC3339),
+		}
+		if d.Status == idp.DeliveryStatusPending {
+			delivery["next_attempt_at"] = d.NextAttempt.Format(time.RFC3339)
+		}
+		if d.ResponseStatus != 0 {
+			delivery["response_status"] = d.ResponseStatus
+		}
+		if d.Error != "" {
+			delivery["error"] = d.Error
+		}
+		response[i] = delivery
+	}
+	writeJSON(out, in, http.StatusOK, response)
+}
+
+func webhookJSON(webhook idp.Webhook) map[string]any {
+	return map[string]any{
+		"id":         webhook.ID,
+		"url":        webhook.URL,
+		"created_at": webhook.Time.Format(time.RFC3339),
+	}
+}
+
+func writeJSON(out http.ResponseWriter, in *http.Request, status int, response any) {
+	responseBody, marshalResponseBodyError := json.Marshal(response)
+	if marshalResponseBodyError != nil {
+		problem.Error(out, in, marshalResponseBodyError)
+		return
+	}
+
+	out.Header().Set("Content-Type", "application/json")
+	out.WriteHeader(status)
+	if _, err := out.Write(responseBody); err != nil {
+		logging.FromContext(in.Context()).Error("failed to write webhooks", "error", err)
+	}
+}
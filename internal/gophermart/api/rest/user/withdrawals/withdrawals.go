@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"github.com/go-chi/chi/v5"
 	"github.com/kerelape/gophermart/internal/gophermart/api/rest/authorization"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/listing"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/problem"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
 	"net/http"
 	"time"
 )
@@ -23,22 +27,26 @@ func (w Withdrawals) Route() http.Handler {
 }
 
 func (w Withdrawals) ServeHTTP(out http.ResponseWriter, in *http.Request) {
-	out.Header().Set("Content-Type", "application/json")
-
 	user := authorization.User(in)
 
-	withdrawals, withdrawalsError := user.Withdrawals(in.Context())
+	listQuery, listQueryError := listing.Query(in)
+	if listQueryError != nil {
+		problem.Error(out, in, listQueryError)
+		return
+	}
+
+	withdrawals, withdrawalsError := user.Withdrawals(in.Context(), listing.Page(listQuery))
 	if withdrawalsError != nil {
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, withdrawalsError)
 		return
 	}
 	if len(withdrawals) == 0 {
-		status := http.StatusNoContent
-		http.Error(out, http.StatusText(status), status)
+		out.WriteHeader(http.StatusNoContent)
 		return
 	}
 
+	withdrawals = listing.Paginate(out, in, listQuery, withdrawals, idp.Withdrawal.Cursor)
+
 	response := make([]map[string]any, len(withdrawals))
 	for i, withdrawal := range withdrawals {
 		response[i] = map[string]any{
@@ -50,16 +58,13 @@ func (w Withdrawals) ServeHTTP(out http.ResponseWriter, in *http.Request) {
 
 	responseBody, marshalResponseBodyError := json.Marshal(response)
 	if marshalResponseBodyError != nil {
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
+		problem.Error(out, in, marshalResponseBodyError)
 		return
 	}
 
+	out.Header().Set("Content-Type", "application/json")
+	out.WriteHeader(http.StatusOK)
 	if _, err := out.Write(responseBody); err != nil {
-		status := http.StatusInternalServerError
-		http.Error(out, http.StatusText(status), status)
-		return
+		logging.FromContext(in.Context()).Error("failed to write withdrawals", "error", err)
 	}
-
-	out.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,51 @@
+package rpc
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc/gophermartpb"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type contextKey string
+
+const contextKeyUser = contextKey("rpc.user")
+
+// publicMethods do not require the authorization metadata.
+var publicMethods = map[string]bool{
+	gophermartpb.Gophermart_Register_FullMethodName: true,
+	gophermartpb.Gophermart_Login_FullMethodName:    true,
+}
+
+// authorization authenticates the calls by the token in the "authorization" metadata,
+// the same way the REST API does by the Authorization header.
+func authorization(identityProvider idp.IdentityProvider) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if publicMethods[info.FullMethod] {
+			return handler(ctx, request)
+		}
+
+		tokens := metadata.ValueFromIncomingContext(ctx, "authorization")
+		if len(tokens) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "the authorization metadata is missing")
+		}
+		user, err := identityProvider.User(ctx, idp.Token(tokens[0]))
+		if err != nil {
+			return nil, statusError(ctx, err)
+		}
+		ctx = logging.With(ctx, "user", user.ID())
+		return handler(context.WithValue(ctx, contextKeyUser, user), request)
+	}
+}
+
+func userFromContext(ctx context.Context) idp.User {
+	user, ok := ctx.Value(contextKeyUser).(idp.User)
+	if !ok {
+		panic("user not defined (authorization interceptor is not used)")
+	}
+	return user
+}
@@ -0,0 +1,64 @@
+package rpc_test
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc/gophermartpb"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+)
+
+func TestAuthorizationOfDeletedUser(t *testing.T) {
+	ctx := context.Background()
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+
+	client := serve(t, rpc.New(provider, user.NewRateLimiters(user.RateLimits{})))
+
+	token, identity := idptest.Authenticate(t, provider, database, "alice")
+	authorized := metadata.AppendToOutgoingContext(ctx, "authorization", string(token))
+	if _, err := client.GetBalance(authorized, &gophermartpb.GetBalanceRequest{}); err != nil {
+		t.Fatalf("GetBalance() = %v, want nil", err)
+	}
+
+	if err := identity.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, balanceError := client.GetBalance(authorized, &gophermartpb.GetBalanceRequest{})
+	if code := status.Code(balanceError); code != codes.Unauthenticated {
+		t.Fatalf("GetBalance() with the token of a deleted user = %v, want %v", balanceError, codes.Unauthenticated)
+	}
+}
+
+// serve serves the RPC in memory until the test ends and returns a client of it.
+func serve(t *testing.T, r rpc.RPC) gophermartpb.GophermartClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := r.Server()
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	conn, dialError := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if dialError != nil {
+		t.Fatal(dialError)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return gophermartpb.NewGophermartClient(conn)
+}
@@ -0,0 +1,4 @@
+// Package gophermartpb contains the protocol buffers and the gRPC service generated from gophermart.proto.
+package gophermartpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gophermart.proto
@@ -0,0 +1,95 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc/gophermartpb"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+)
+
+// maxLimit is the maximum number of records in one page.
+const maxLimit = 1000
+
+var errBadQuery = errors.New("bad list query")
+
+var orderStatuses = map[gophermartpb.OrderStatus]idp.OrderStatus{
+	gophermartpb.OrderStatus_ORDER_STATUS_NEW:        idp.OrderStatusNew,
+	gophermartpb.OrderStatus_ORDER_STATUS_PROCESSING: idp.OrderStatusProcessing,
+	gophermartpb.OrderStatus_ORDER_STATUS_INVALID:    idp.OrderStatusInvalid,
+	gophermartpb.OrderStatus_ORDER_STATUS_PROCESSED:  idp.OrderStatusProcessed,
+}
+
+func listQuery(in *gophermartpb.ListQuery) (idp.ListQuery, error) {
+	query := idp.ListQuery{}
+	if in == nil {
+		return query, nil
+	}
+
+	if in.From != nil {
+		if err := in.From.CheckValid(); err != nil {
+			return idp.ListQuery{}, fmt.Errorf("%w: from: %v", errBadQuery, err)
+		}
+		query.From = in.From.AsTime()
+	}
+	if in.To != nil {
+		if err := in.To.CheckValid(); err != nil {
+			return idp.ListQuery{}, fmt.Errorf("%w: to: %v", errBadQuery, err)
+		}
+		query.To = in.To.AsTime()
+	}
+	query.Descending = in.Descending
+
+	if in.Limit < 0 || in.Limit > maxLimit {
+		return idp.ListQuery{}, fmt.Errorf("%w: limit must be from 0 to %d", errBadQuery, maxLimit)
+	}
+	query.Limit = int(in.Limit)
+
+	if in.Cursor != "" {
+		cursor, err := idp.ParseCursor(in.Cursor)
+		if err != nil {
+			return idp.ListQuery{}, fmt.Errorf("%w: cursor: %v", errBadQuery, err)
+		}
+		query.After = &cursor
+	}
+	return query, nil
+}
+
+func statuses(in []gophermartpb.OrderStatus) ([]idp.OrderStatus, error) {
+	statuses := make([]idp.OrderStatus, 0, len(in))
+	for _, s := range in {
+		status, ok := orderStatuses[s]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown status %v", errBadQuery, s)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func orderStatus(status idp.OrderStatus) gophermartpb.OrderStatus {
+	for s, st := range orderStatuses {
+		if st == status {
+			return s
+		}
+	}
+	return gophermartpb.OrderStatus_ORDER_STATUS_UNSPECIFIED
+}
+
+// page asks the database for one more record than the query limit,
+// so that paginate can tell whether there is a next page.
+func page(query idp.ListQuery) idp.ListQuery {
+	if query.Limit > 0 {
+		query.Limit++
+	}
+	return query
+}
+
+// paginate drops the extra record requested by page
+// and returns the cursor of the next page, if there is one.
+func paginate[T any](query idp.ListQuery, records []T, cursor func(T) idp.Cursor) ([]T, string) {
+	if query.Limit == 0 || len(records) <= query.Limit {
+		return records, ""
+	}
+	records = records[:query.Limit]
+	return records, cursor(records[len(records)-1]).String()
+}
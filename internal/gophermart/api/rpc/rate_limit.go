@@ -0,0 +1,67 @@
+package rpc
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/ratelimit"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc/gophermartpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// rateLimit limits the calls by the same limiters as the REST requests, sharing their buckets:
+// Register and Login by the address of the peer, the other calls by the authorized user
+// and UploadOrder by the user once more. The calls over the limit fail with ResourceExhausted
+// and the number of seconds to wait for in the "retry-after" trailer.
+//
+// The interceptor must be used after the authorization one.
+func rateLimit(limiters user.RateLimiters) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		type limit struct {
+			limiter *ratelimit.Limiter
+			key     string
+		}
+		limits := make([]limit, 0, 2)
+		if publicMethods[info.FullMethod] {
+			limits = append(limits, limit{limiters.Auth, ratelimit.IPKey(peerAddr(ctx))})
+		} else {
+			key := ratelimit.UserKey(userFromContext(ctx).ID())
+			limits = append(limits, limit{limiters.User, key})
+			if info.FullMethod == gophermartpb.Gophermart_UploadOrder_FullMethodName {
+				limits = append(limits, limit{limiters.Orders, key})
+			}
+		}
+
+		now := time.Now()
+		for _, l := range limits {
+			if _, _, wait, limited := l.limiter.Take(l.key, now); limited && wait > 0 {
+				retryAfter := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+				if err := grpc.SetTrailer(ctx, metadata.Pairs("retry-after", retryAfter)); err != nil {
+					return nil, err
+				}
+				return nil, status.Error(codes.ResourceExhausted, "the rate limit is exceeded, retry in "+wait.Round(time.Millisecond).String())
+			}
+		}
+		return handler(ctx, request)
+	}
+}
+
+// peerAddr returns the address of the peer, or the zero address if it is not an IP address.
+func peerAddr(ctx context.Context) netip.Addr {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return netip.Addr{}
+	}
+	addrPort, parseError := netip.ParseAddrPort(p.Addr.String())
+	if parseError != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
@@ -0,0 +1,65 @@
+package rpc_test
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/ratelimit"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc/gophermartpb"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	database := idp.NewMemoryIdentityDatabase(accrual.Accrual{}, nil)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+	limiters := user.NewRateLimiters(user.RateLimits{
+		Auth:   ratelimit.Limit{Requests: 2, Per: time.Minute},
+		Orders: ratelimit.Limit{Requests: 1, Per: time.Minute},
+		User:   ratelimit.Limit{Requests: 3, Per: time.Minute},
+	})
+	client := serve(t, rpc.New(provider, limiters))
+
+	token, registerError := client.Register(ctx, &gophermartpb.Credentials{Login: "alice", Password: "password"})
+	if registerError != nil {
+		t.Fatalf("Register() = %v, want nil", registerError)
+	}
+	if _, err := client.Login(ctx, &gophermartpb.Credentials{Login: "alice", Password: "wrong"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Login() with a wrong password = %v, want %v", err, codes.Unauthenticated)
+	}
+	var trailer metadata.MD
+	_, loginError := client.Login(ctx, &gophermartpb.Credentials{Login: "alice", Password: "password"}, grpc.Trailer(&trailer))
+	checkExhausted(t, "Login() over the limit of the peer", loginError, trailer, 30)
+
+	authorized := metadata.AppendToOutgoingContext(ctx, "authorization", token.GetToken())
+	if _, err := client.UploadOrder(authorized, &gophermartpb.UploadOrderRequest{Number: "12345678903"}); err != nil {
+		t.Fatalf("UploadOrder() = %v, want nil", err)
+	}
+	_, uploadError := client.UploadOrder(authorized, &gophermartpb.UploadOrderRequest{Number: "2377225624"}, grpc.Trailer(&trailer))
+	checkExhausted(t, "UploadOrder() over the limit of the orders", uploadError, trailer, 60)
+
+	if _, err := client.GetBalance(authorized, &gophermartpb.GetBalanceRequest{}); err != nil {
+		t.Fatalf("GetBalance() = %v, want nil", err)
+	}
+	_, balanceError := client.GetBalance(authorized, &gophermartpb.GetBalanceRequest{}, grpc.Trailer(&trailer))
+	checkExhausted(t, "GetBalance() over the limit of the user", balanceError, trailer, 20)
+}
+
+func checkExhausted(t *testing.T, call string, err error, trailer metadata.MD, retryAfter int) {
+	t.Helper()
+	if code := status.Code(err); code != codes.ResourceExhausted {
+		t.Fatalf("%s = %v, want %v", call, err, codes.ResourceExhausted)
+	}
+	want := []string{strconv.Itoa(retryAfter)}
+	if got := trailer.Get("retry-after"); len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("%s retry-after = %v, want %v", call, got, want)
+	}
+}
@@ -0,0 +1,45 @@
+package rpc
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// requests gives every call a logger with its request ID, taken from
+// the "x-request-id" metadata or generated, and logs the call once it is handled.
+// The ID is sent back in the "x-request-id" header metadata.
+func requests(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	id := logging.NewRequestID()
+	if ids := metadata.ValueFromIncomingContext(ctx, logging.HeaderRequestID); len(ids) == 1 && logging.ValidRequestID(ids[0]) {
+		id = ids[0]
+	}
+	ctx = logging.WithRequestID(ctx, id)
+	if err := grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(logging.HeaderRequestID), id)); err != nil {
+		logging.FromContext(ctx).Debug("failed to set the request id header", "error", err)
+	}
+
+	response, err := handler(ctx, request)
+
+	code := status.Code(err)
+	level := slog.LevelInfo
+	if code == codes.Internal || code == codes.Unknown {
+		level = slog.LevelError
+	}
+	logging.FromContext(ctx).LogAttrs(
+		ctx,
+		level,
+		"call",
+		slog.String("method", info.FullMethod),
+		slog.String("code", code.String()),
+		slog.Duration("duration", time.Since(start)),
+	)
+	return response, err
+}
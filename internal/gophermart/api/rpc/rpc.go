@@ -0,0 +1,41 @@
+package rpc
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc/gophermartpb"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+type RPC struct {
+	identityProvider idp.IdentityProvider
+	rateLimiters     user.RateLimiters
+
+	// Reflection registers the server reflection, which lets the clients list the services.
+	Reflection bool
+}
+
+// New creates a new RPC.
+//
+// The calls are limited by rateLimiters, the same as the REST requests.
+func New(identityProvider idp.IdentityProvider, rateLimiters user.RateLimiters) RPC {
+	return RPC{
+		identityProvider: identityProvider,
+		rateLimiters:     rateLimiters,
+
+		Reflection: false,
+	}
+}
+
+// Server creates a gRPC server with the Gophermart service registered,
+// and the server reflection if Reflection is set.
+func (r RPC) Server(options ...grpc.ServerOption) *grpc.Server {
+	options = append(options, grpc.ChainUnaryInterceptor(requests, authorization(r.identityProvider), rateLimit(r.rateLimiters)))
+	server := grpc.NewServer(options...)
+	gophermartpb.RegisterGophermartServer(server, newService(r.identityProvider))
+	if r.Reflection {
+		reflection.Register(server)
+	}
+	return server
+}
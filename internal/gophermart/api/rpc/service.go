@@ -0,0 +1,131 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rpc/gophermartpb"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+)
+
+type service struct {
+	gophermartpb.UnimplementedGophermartServer
+
+	identityProvider idp.IdentityProvider
+}
+
+func newService(identityProvider idp.IdentityProvider) service {
+	return service{
+		identityProvider: identityProvider,
+	}
+}
+
+func (s service) Register(ctx context.Context, in *gophermartpb.Credentials) (*gophermartpb.Token, error) {
+	if err := s.identityProvider.Register(ctx, in.Login, in.Password); err != nil {
+		return nil, statusError(ctx, err)
+	}
+	return s.Login(ctx, in)
+}
+
+func (s service) Login(ctx context.Context, in *gophermartpb.Credentials) (*gophermartpb.Token, error) {
+	token, err := s.identityProvider.Authenticate(ctx, in.Login, in.Password)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+	return &gophermartpb.Token{Token: string(token)}, nil
+}
+
+func (s service) UploadOrder(ctx context.Context, in *gophermartpb.UploadOrderRequest) (*gophermartpb.UploadOrderResponse, error) {
+	if strings.TrimSpace(in.Number) == "" {
+		return nil, status.Error(codes.InvalidArgument, "the order number is empty")
+	}
+	err := userFromContext(ctx).AddOrder(ctx, in.Number)
+	if err != nil {
+		if errors.Is(err, idp.ErrOrderDuplicate) {
+			return &gophermartpb.UploadOrderResponse{AlreadyUploaded: true}, nil
+		}
+		return nil, statusError(ctx, err)
+	}
+	return &gophermartpb.UploadOrderResponse{}, nil
+}
+
+func (s service) ListOrders(ctx context.Context, in *gophermartpb.ListOrdersRequest) (*gophermartpb.ListOrdersResponse, error) {
+	query, queryError := listQuery(in.Query)
+	if queryError != nil {
+		return nil, statusError(ctx, queryError)
+	}
+	statuses, statusesError := statuses(in.Statuses)
+	if statusesError != nil {
+		return nil, statusError(ctx, statusesError)
+	}
+
+	orders, ordersError := userFromContext(ctx).Orders(ctx, idp.OrderQuery{
+		ListQuery: page(query),
+		Statuses:  statuses,
+	})
+	if ordersError != nil {
+		return nil, statusError(ctx, ordersError)
+	}
+	orders, next := paginate(query, orders, idp.Order.Cursor)
+
+	response := &gophermartpb.ListOrdersResponse{
+		Orders:     make([]*gophermartpb.Order, 0, len(orders)),
+		NextCursor: next,
+	}
+	for _, o := range orders {
+		response.Orders = append(response.Orders, &gophermartpb.Order{
+			Number:     o.ID,
+			Status:     orderStatus(o.Status),
+			Accrual:    o.Accrual,
+			UploadedAt: timestamppb.New(o.Time),
+		})
+	}
+	return response, nil
+}
+
+func (s service) GetBalance(ctx context.Context, _ *gophermartpb.GetBalanceRequest) (*gophermartpb.Balance, error) {
+	balance, err := userFromContext(ctx).Balance(ctx)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+	return &gophermartpb.Balance{
+		Current:   balance.Current,
+		Withdrawn: balance.Withdrawn,
+	}, nil
+}
+
+func (s service) Withdraw(ctx context.Context, in *gophermartpb.WithdrawRequest) (*gophermartpb.WithdrawResponse, error) {
+	if err := userFromContext(ctx).Withdraw(ctx, in.Order, in.Sum); err != nil {
+		return nil, statusError(ctx, err)
+	}
+	return &gophermartpb.WithdrawResponse{}, nil
+}
+
+func (s service) ListWithdrawals(ctx context.Context, in *gophermartpb.ListWithdrawalsRequest) (*gophermartpb.ListWithdrawalsResponse, error) {
+	query, queryError := listQuery(in.Query)
+	if queryError != nil {
+		return nil, statusError(ctx, queryError)
+	}
+
+	withdrawals, withdrawalsError := userFromContext(ctx).Withdrawals(ctx, page(query))
+	if withdrawalsError != nil {
+		return nil, statusError(ctx, withdrawalsError)
+	}
+	withdrawals, next := paginate(query, withdrawals, idp.Withdrawal.Cursor)
+
+	response := &gophermartpb.ListWithdrawalsResponse{
+		Withdrawals: make([]*gophermartpb.Withdrawal, 0, len(withdrawals)),
+		NextCursor:  next,
+	}
+	for _, w := range withdrawals {
+		response.Withdrawals = append(response.Withdrawals, &gophermartpb.Withdrawal{
+			Order:       w.Order,
+			Sum:         w.Sum,
+			ProcessedAt: timestamppb.New(w.Time),
+		})
+	}
+	return response, nil
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusError converts an error returned by idp to a gRPC status error,
+// the internal errors are logged and hidden from the client.
+func statusError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, idp.ErrDuplicateUsername):
+		return status.Error(codes.AlreadyExists, "the login is taken")
+	case errors.Is(err, idp.ErrBadCredentials), errors.Is(err, idp.ErrUnknownIdentity):
+		return status.Error(codes.Unauthenticated, "the credentials are wrong")
+	case errors.Is(err, idp.ErrOrderInvalid):
+		return status.Error(codes.InvalidArgument, "the order number is invalid")
+	case errors.Is(err, idp.ErrOrderUnowned):
+		return status.Error(codes.AlreadyExists, "the order has been uploaded by another user")
+	case errors.Is(err, idp.ErrBalanceTooLow):
+		return status.Error(codes.FailedPrecondition, "the balance is too low")
+	case errors.Is(err, idp.ErrWithdrawalSumInvalid):
+		return status.Error(codes.InvalidArgument, "the sum must be positive")
+	case errors.Is(err, idp.ErrWithdrawalDuplicate):
+		return status.Error(codes.AlreadyExists, "points have already been withdrawn towards the order")
+	case errors.Is(err, idp.ErrUnavailable):
+		return status.Error(codes.Unavailable, "the service is temporarily unavailable")
+	case errors.Is(err, errBadQuery):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	logging.FromContext(ctx).Error("call failed", "error", err)
+	return status.Error(codes.Internal, "internal server error")
+}
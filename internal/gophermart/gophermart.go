@@ -2,40 +2,261 @@ package gophermart
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/kerelape/gophermart/internal/accrual"
 	"github.com/kerelape/gophermart/internal/gophermart/api"
+	"github.com/kerelape/gophermart/internal/gophermart/api/admin"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/clientip"
+	"github.com/kerelape/gophermart/internal/gophermart/api/rest/user"
 	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"github.com/kerelape/gophermart/internal/gophermart/metrics"
+	"github.com/kerelape/gophermart/internal/gophermart/reload"
+	"github.com/kerelape/gophermart/internal/gophermart/tlsconfig"
+	"github.com/kerelape/gophermart/internal/gophermart/tracing"
+	"github.com/kerelape/gophermart/internal/gophermart/webhook"
 	"github.com/pior/runnable"
+	"golang.org/x/exp/slog"
 	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
 )
 
+const (
+	// DatabaseDriverPostgres stores the data in PostgreSQL.
+	DatabaseDriverPostgres = "postgres"
+
+	// DatabaseDriverMemory keeps the data in memory, it is lost on exit.
+	DatabaseDriverMemory = "memory"
+
+	// DatabaseDriverSQLite stores the data in an SQLite file.
+	DatabaseDriverSQLite = "sqlite"
+)
+
+// errNotReloadable is returned by the admin endpoint if the configuration has no source to be reloaded from.
+var errNotReloadable = errors.New("the configuration is not reloadable")
+
+// tracingShutdownTimeout is how long the spans are flushed for on exit.
+const tracingShutdownTimeout = 5 * time.Second
+
+// Config is the configuration of Gophermart.
+//
+// The fields marked reload:"live" are applied when the configuration is reloaded,
+// the others take effect after a restart (see the reload package).
+type Config struct {
+	AddressAPIServer     string
+	AddressGRPCServer    string
+	GRPCReflection       bool
+	AddressAdminServer   string
+	AdminToken           string `secret:"true"`
+	AddressAccrualSystem string
+	DatabaseDriver       string
+	AddressDatabase      string `secret:"true"`
+	JWTSecret            string `secret:"true"`
+
+	// TokenTTL is how long the issued tokens are valid for.
+	TokenTTL time.Duration
+
+	// The responses to the requests made with an idempotency key are kept for IdempotencyWindow.
+	IdempotencyWindow time.Duration
+
+	// The REST and gRPC requests are limited by RateLimits, the client addresses of the REST ones
+	// are taken from the forwarding headers of the requests made by TrustedProxies.
+	RateLimits     user.RateLimits `reload:"live"`
+	TrustedProxies []netip.Prefix
+
+	// The accrual system is polled every PollInterval with at most PollConcurrency requests at once,
+	// each request times out after AccrualTimeout.
+	PollInterval    time.Duration
+	PollConcurrency int `reload:"live"`
+	AccrualTimeout  time.Duration
+
+	// The webhooks are delivered with at most WebhookConcurrency requests at once,
+	// each request times out after WebhookTimeout.
+	WebhookTimeout     time.Duration
+	WebhookConcurrency int `reload:"live"`
+
+	// ReadTimeout and IdleTimeout are the timeouts of the HTTP server, see http.Server.
+	ReadTimeout time.Duration
+	IdleTimeout time.Duration
+
+	// The servers serve TLS with the certificate of TLSCertFile and TLSKeyFile, which are reloaded
+	// when they change, if they are not empty. The client certificates signed by TLSClientCAFile
+	// are required by the admin server and by /metrics if it is not empty.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSMinVersion   uint16
+	TLSCipherSuites []uint16
+	TLSClientCAFile string
+
+	// AddressRedirectServer is the address of the server redirecting HTTP to HTTPS,
+	// it is not run if it is empty or TLS is not served.
+	AddressRedirectServer string
+
+	// LogLevel is the minimum level of the logged records, see Gophermart.LogLevel.
+	LogLevel slog.Level `reload:"live"`
+
+	// The spans are exported with TracingExporter (see the tracing package).
+	TracingExporter string
+
+	TestMode bool
+}
+
 type Gophermart struct {
-	addressAPIServer     string
-	addressAccrualSystem string
-	addressDatabase      string
-	jwtSecret            string
+	config Config
+
+	// Source loads the configuration again when it is reloaded, on SIGHUP or by the admin
+	// endpoint; the configuration is not reloaded if Source is nil.
+	Source func() (Config, error)
+
+	// LogLevel is the level of the logger, it is set to the reloaded Config.LogLevel unless it is nil.
+	LogLevel *slog.LevelVar
 }
 
 // New creates a new Gophermart.
-func New(addressAPIServer, addressAccrualSystem, addressDatabase, jwtSecret string) Gophermart {
+func New(config Config) Gophermart {
 	return Gophermart{
-		addressAPIServer:     addressAPIServer,
-		addressAccrualSystem: addressAccrualSystem,
-		addressDatabase:      addressDatabase,
-		jwtSecret:            jwtSecret,
+		config: config,
 	}
 }
 
-func (g Gophermart) Run(ctx context.Context) error {
-	database := idp.NewPostgresIdentityDatabase(
-		g.addressDatabase,
-		accrual.New(g.addressAccrualSystem, http.DefaultClient),
+func (g Gophermart) Run(ctx context.Context) (err error) {
+	shutdownTracing, tracingError := tracing.Setup(ctx, g.config.TracingExporter, os.Stdout)
+	if tracingError != nil {
+		return tracingError
+	}
+	defer func() {
+		// The context is done by now, the spans are flushed with a timeout of their own.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if shutdownError := shutdownTracing(shutdownCtx); shutdownError != nil && err == nil {
+			err = shutdownError
+		}
+	}()
+
+	broker := idp.NewMemoryBroker()
+	accrualClient := &http.Client{
+		Timeout:   g.config.AccrualTimeout,
+		Transport: metrics.AccrualTransport(tracing.Transport(logging.Transport(http.DefaultTransport))),
+	}
+	accrualSystem := accrual.New(g.config.AddressAccrualSystem, accrualClient)
+	rateLimiters := user.NewRateLimiters(g.config.RateLimits)
+	database, databaseError := g.database(accrualSystem, broker)
+	if databaseError != nil {
+		return databaseError
+	}
+	identityProvider := idp.NewBearerIdentityProvider(database, []byte(g.config.JWTSecret))
+	identityProvider.TokenTTL = g.config.TokenTTL
+	apiService := api.New(
+		identityProvider,
+		broker,
+		database,
+		database,
+		g.config.IdempotencyWindow,
+		rateLimiters,
+		clientip.New(g.config.TrustedProxies),
+		database,
+		accrualSystem.Circuit,
+		g.config.AddressAPIServer,
+		g.config.AddressGRPCServer,
+		g.config.TestMode,
 	)
-	identityProvider := idp.NewBearerIdentityProvider(database, []byte(g.jwtSecret))
-	apiService := api.New(identityProvider, g.addressAPIServer)
+	apiService.GRPCReflection = g.config.GRPCReflection
+	apiService.AdminAddress = g.config.AddressAdminServer
+	apiService.AdminToken = g.config.AdminToken
+	apiService.RedirectAddress = g.config.AddressRedirectServer
+	apiService.ReadTimeout = g.config.ReadTimeout
+	apiService.IdleTimeout = g.config.IdleTimeout
+
+	dispatcher := webhook.New(database, broker, webhook.Client(g.config.WebhookTimeout))
+
+	apply := func(config Config) {
+		rateLimiters.SetLimits(config.RateLimits)
+		database.SetPollConcurrency(config.PollConcurrency)
+		dispatcher.SetConcurrency(config.WebhookConcurrency)
+		if g.LogLevel != nil {
+			g.LogLevel.Set(config.LogLevel)
+		}
+	}
+	apply(g.config)
 
 	manager := runnable.NewManager()
+	if g.config.TLSCertFile != "" {
+		certificate, certificateError := tlsconfig.NewCertificate(g.config.TLSCertFile, g.config.TLSKeyFile)
+		if certificateError != nil {
+			return certificateError
+		}
+		apiService.TLS = tlsconfig.New(certificate, g.config.TLSMinVersion, g.config.TLSCipherSuites)
+		if g.config.TLSClientCAFile != "" {
+			clientCAs, clientCAsError := tlsconfig.LoadCertPool(g.config.TLSClientCAFile)
+			if clientCAsError != nil {
+				return clientCAsError
+			}
+			apiService.ClientCAs = clientCAs
+		}
+		manager.Add(certificate)
+	}
+	if g.Source != nil {
+		reloader := newReloader(g.config, g.Source, apply)
+		apiService.Admin = admin.New(reloader.reload)
+		manager.Add(reloader)
+	} else {
+		apiService.Admin = admin.New(func(context.Context) ([]reload.Change, error) {
+			return nil, errNotReloadable
+		})
+	}
 	manager.Add(database)
+	manager.Add(dispatcher, database)
 	manager.Add(apiService)
 	return manager.Build().Run(ctx)
 }
+
+// Database is the database of gophermart as the operator commands use it.
+type Database interface {
+	idp.IdentityDatabase
+	idp.Administration
+	idp.Health
+	runnable.Runnable
+}
+
+// OpenDatabase returns the database of the configuration, connected by its Run,
+// that neither polls the accrual system nor publishes the changes; the running
+// service polls the rechecked orders and sees the new revisions of the identities.
+func OpenDatabase(config Config) (Database, error) {
+	config.PollInterval = 0
+	return New(config).database(accrual.Accrual{}, nil)
+}
+
+type identityDatabase interface {
+	idp.IdentityDatabase
+	idp.Administration
+	idp.WebhookQueue
+	idp.IdempotencyStore
+	idp.Health
+	runnable.Runnable
+
+	// SetPollConcurrency changes the maximum number of concurrent requests to the accrual system.
+	SetPollConcurrency(concurrency int)
+}
+
+func (g Gophermart) database(accrual accrual.Accrual, publisher idp.Publisher) (identityDatabase, error) {
+	switch g.config.DatabaseDriver {
+	case DatabaseDriverPostgres:
+		database := idp.NewPostgresIdentityDatabase(g.config.AddressDatabase, accrual, publisher)
+		database.PollInterval = g.config.PollInterval
+		return instrumentedDatabase{database}, nil
+	case DatabaseDriverMemory:
+		database := idp.NewMemoryIdentityDatabase(accrual, publisher)
+		database.PollInterval = g.config.PollInterval
+		return instrumentedDatabase{database}, nil
+	case DatabaseDriverSQLite:
+		_, path, _ := strings.Cut(g.config.AddressDatabase, "://")
+		database := idp.NewSQLiteIdentityDatabase(path, accrual, publisher)
+		database.PollInterval = g.config.PollInterval
+		return instrumentedDatabase{database}, nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", g.config.DatabaseDriver)
+}
@@ -0,0 +1,125 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"github.com/kerelape/gophermart/internal/gophermart/metrics"
+	"github.com/kerelape/gophermart/internal/gophermart/tracing"
+	"github.com/pior/runnable"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"time"
+)
+
+const (
+	// defaultPollInterval is how often the databases poll the accrual system by default.
+	defaultPollInterval = time.Second
+
+	// defaultPollConcurrency is the maximum number of concurrent requests
+	// to the accrual system by default.
+	defaultPollConcurrency = 16
+)
+
+// pollEvery returns a runnable that calls update every interval until the context is done.
+//
+// The errors of update are logged instead of stopping the runnable,
+// so that the polling is resumed once the database or the accrual system recovers.
+// The polling is disabled if interval is not positive.
+func pollEvery(update func(ctx context.Context) error, interval time.Duration) runnable.Runnable {
+	if interval <= 0 {
+		return runnable.Func(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+	}
+	return runnable.Every(runnable.Func(func(ctx context.Context) error {
+		if err := update(ctx); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error("failed to poll the accrual system", "error", err)
+		}
+		return nil
+	}), interval)
+}
+
+// pollAccrual requests the accrual system for the state of every order in ids,
+// making at most concurrency requests at once, and passes each received state to apply.
+//
+// If the accrual system asks to slow down, pollAccrual waits for the requested time
+// or until ctx is done and returns without an error, the rest of the orders are polled next time.
+// So it does while the circuit of the accrual system is open.
+// Every poll has its own request ID, which is sent to the accrual system.
+func pollAccrual(
+	ctx context.Context,
+	system accrual.Accrual,
+	concurrency int,
+	ids []string,
+	apply func(ctx context.Context, id string, status OrderStatus, accrual float64) error,
+) (err error) {
+	if len(ids) == 0 {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "poll accrual", trace.WithAttributes(attribute.Int("orders", len(ids))))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+	logging.FromContext(ctx).Debug("polling the accrual system", "orders", len(ids))
+
+	eg, egctx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
+	for _, id := range ids {
+		eg.Go(func(ctx context.Context, id string) func() error {
+			return func() error {
+				orderInfo, orderInfoError := system.OrderInfo(ctx, id)
+				var status OrderStatus
+				if orderInfoError != nil {
+					if errors.Is(orderInfoError, accrual.ErrUnknownOrder) {
+						status = OrderStatusInvalid
+					} else {
+						return orderInfoError
+					}
+				} else {
+					status = MakeOrderStatus(orderInfo.Status)
+				}
+				return apply(ctx, id, status, orderInfo.Accrual)
+			}
+		}(egctx, id))
+	}
+
+	if err := eg.Wait(); err != nil {
+		if errors.Is(err, accrual.ErrCircuitOpen) {
+			logging.FromContext(ctx).Debug("the accrual circuit is open, the orders are polled later")
+			return nil
+		}
+		tooManyRequestsError := new(accrual.TooManyRequestsError)
+		if errors.As(err, tooManyRequestsError) {
+			logging.FromContext(ctx).Warn("the accrual system asked to slow down", "retry_after", tooManyRequestsError.RetryAfter)
+			span.AddEvent("the accrual system asked to slow down", trace.WithAttributes(attribute.Stringer("retry_after", tooManyRequestsError.RetryAfter)))
+			metrics.AccrualRetryAfterSeconds.Add(tooManyRequestsError.RetryAfter.Seconds())
+			// The worker must stop on shutdown rather than wait out a long Retry-After.
+			select {
+			case <-time.After(tooManyRequestsError.RetryAfter):
+			case <-ctx.Done():
+			}
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// observePoll records the duration of the poll started at start
+// and the number of the orders waiting for the accrual system by status.
+func observePoll(start time.Time, pending []OrderStatus) {
+	counts := map[OrderStatus]int{OrderStatusNew: 0, OrderStatusProcessing: 0}
+	for _, status := range pending {
+		counts[status]++
+	}
+	for status, count := range counts {
+		metrics.PendingOrders.WithLabelValues(string(status)).Set(float64(count))
+	}
+	metrics.PollDuration.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,32 @@
+package idp
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPollAccrualStopsWaitingOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(out http.ResponseWriter, _ *http.Request) {
+		out.Header().Set("Retry-After", "3600")
+		out.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	apply := func(context.Context, string, OrderStatus, float64) error {
+		t.Error("apply was called while the accrual system asked to slow down")
+		return nil
+	}
+	if err := pollAccrual(ctx, accrual.Accrual{Address: server.URL, Client: server.Client()}, 1, []string{"12345678903"}, apply); err != nil {
+		t.Fatalf("pollAccrual() = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("pollAccrual() returned in %v after the cancellation, want at once", elapsed)
+	}
+}
@@ -0,0 +1,39 @@
+package idp
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrUnknownOrder is returned when there is no order with the requested number.
+	ErrUnknownOrder = errors.New("unknown order")
+
+	// ErrOrderProcessed is returned when a processed order is rechecked,
+	// its accrual has already been credited.
+	ErrOrderProcessed = errors.New("order processed")
+)
+
+// AdjustmentHistory is the history of the adjustments of the balances made by the operators.
+type AdjustmentHistory interface {
+	// Adjustments returns the adjustments of the balance of the user, oldest first.
+	Adjustments(ctx context.Context, user int64) ([]Adjustment, error)
+}
+
+// Administration is the operations of the operators of gophermart on the data of any user.
+type Administration interface {
+	AdjustmentHistory
+
+	// RecheckOrder makes the order new again, so that the accrual system is asked for it
+	// as if it had just been uploaded.
+	//
+	// It returns ErrUnknownOrder if there is no such order and ErrOrderProcessed
+	// if the order has been processed.
+	RecheckOrder(ctx context.Context, id string) error
+
+	// AdjustBalance credits sum to the balance of the user, or debits it if sum is negative, for the reason.
+	//
+	// It returns ErrUnknownIdentity if there is no such user and ErrBalanceTooLow
+	// if a debit is more than the current balance. The adjustments do not count as withdrawn.
+	AdjustBalance(ctx context.Context, user int64, sum float64, reason string) error
+}
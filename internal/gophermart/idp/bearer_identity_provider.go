@@ -2,14 +2,22 @@ package idp
 
 import (
 	"context"
+	"errors"
 	"github.com/golang-jwt/jwt/v5"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// defaultTokenTTL is how long the tokens are valid for by default.
+const defaultTokenTTL = 24 * time.Hour
+
 type BearerIdentityProvider struct {
 	database IdentityDatabase
 	secret   []byte
+
+	// TokenTTL is how long the issued tokens are valid for.
+	TokenTTL time.Duration
 }
 
 // NewBearerIdentityProvider creates a new BearerIdentityProvider.
@@ -17,6 +25,8 @@ func NewBearerIdentityProvider(database IdentityDatabase, secret []byte) BearerI
 	return BearerIdentityProvider{
 		database: database,
 		secret:   secret,
+
+		TokenTTL: defaultTokenTTL,
 	}
 }
 
@@ -25,7 +35,21 @@ func (b BearerIdentityProvider) Register(ctx context.Context, username, password
 }
 
 func (b BearerIdentityProvider) Authenticate(ctx context.Context, username, password string) (Token, error) {
-	authenticated, comparePasswordError := b.database.Identity(username).ComparePassword(ctx, password)
+	identity, findError := b.database.Find(ctx, username)
+	if findError != nil {
+		if errors.Is(findError, ErrUnknownIdentity) {
+			return "", ErrBadCredentials
+		}
+		return "", findError
+	}
+
+	// The generation is read first, so that the token is revoked
+	// if the password changes after it is compared.
+	generation, generationError := identity.PasswordGeneration(ctx)
+	if generationError != nil {
+		return "", generationError
+	}
+	authenticated, comparePasswordError := identity.ComparePassword(ctx, password)
 	if comparePasswordError != nil {
 		return "", comparePasswordError
 	}
@@ -34,9 +58,10 @@ func (b BearerIdentityProvider) Authenticate(ctx context.Context, username, pass
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
+		"exp": time.Now().Add(b.TokenTTL).Unix(),
 		"iss": "https://github.com/kerelape/gophermart",
-		"sub": username,
+		"sub": strconv.FormatInt(identity.ID(), 10),
+		"gen": generation,
 	})
 	signedToken, signTokenError := token.SignedString(b.secret)
 	if signTokenError != nil {
@@ -45,7 +70,7 @@ func (b BearerIdentityProvider) Authenticate(ctx context.Context, username, pass
 	return Token("Bearer " + signedToken), nil
 }
 
-func (b BearerIdentityProvider) User(_ context.Context, token Token) (User, error) {
+func (b BearerIdentityProvider) User(ctx context.Context, token Token) (User, error) {
 	if !strings.HasPrefix(string(token), "Bearer ") {
 		return nil, ErrBadCredentials
 	}
@@ -73,5 +98,33 @@ func (b BearerIdentityProvider) User(_ context.Context, token Token) (User, erro
 		return nil, ErrBadCredentials
 	}
 
-	return b.database.Identity(sub), nil
+	id, parseIDError := strconv.ParseInt(sub, 10, 64)
+	if parseIDError != nil {
+		return nil, ErrBadCredentials
+	}
+
+	// The tokens of the deleted identities are valid until they expire, but useless.
+	identity, identityError := b.database.Identity(ctx, id)
+	if errors.Is(identityError, ErrUnknownIdentity) {
+		return nil, ErrBadCredentials
+	}
+	if identityError != nil {
+		return nil, identityError
+	}
+
+	// The tokens issued before the generations have no generation, which is zero.
+	var tokenGeneration float64
+	if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok && claims["gen"] != nil {
+		if tokenGeneration, ok = claims["gen"].(float64); !ok {
+			return nil, ErrBadCredentials
+		}
+	}
+	generation, generationError := identity.PasswordGeneration(ctx)
+	if generationError != nil {
+		return nil, generationError
+	}
+	if int64(tokenGeneration) != generation {
+		return nil, ErrBadCredentials
+	}
+	return identity, nil
 }
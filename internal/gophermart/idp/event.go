@@ -0,0 +1,45 @@
+package idp
+
+import "context"
+
+// Event is a change of the orders or the balance of a user.
+type Event struct {
+	// User is the id of the user whose data has changed.
+	User int64
+
+	// Order is the added or updated order, it is nil if no order has changed.
+	Order *Order
+
+	// Withdrawal is the new withdrawal, it is nil if the user has not withdrawn points.
+	Withdrawal *Withdrawal
+
+	// Balance is the new balance, it is nil if the balance has not changed.
+	Balance *Balance
+}
+
+// Publisher publishes the events of an IdentityDatabase.
+//
+// Publish must not block, the failures to deliver an event are up to the publisher to report.
+type Publisher interface {
+	Publish(ctx context.Context, event Event)
+}
+
+// Broker delivers the published events to the subscribers of the user.
+type Broker interface {
+	Publisher
+
+	// Subscribe returns the events of the user published until ctx is done,
+	// the channel is closed then.
+	Subscribe(ctx context.Context, user int64) <-chan Event
+
+	// SubscribeAll returns the events of every user published until ctx is done,
+	// the channel is closed then.
+	SubscribeAll(ctx context.Context) <-chan Event
+}
+
+// publish publishes the event if the publisher is set.
+func publish(ctx context.Context, publisher Publisher, event Event) {
+	if publisher != nil {
+		publisher.Publish(ctx, event)
+	}
+}
@@ -0,0 +1,21 @@
+package idp
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrUnavailable is returned when the database can not be used at the moment,
+// e.g. before it has connected.
+var ErrUnavailable = errors.New("the database is unavailable")
+
+// Health is implemented by the databases that may be unavailable.
+type Health interface {
+	// Available returns ErrUnavailable if the database can not be used at the moment,
+	// it neither blocks nor reaches the database.
+	Available() error
+
+	// Check reaches the database and returns nil if it can be used
+	// and its schema is migrated to the latest version.
+	Check(ctx context.Context) error
+}
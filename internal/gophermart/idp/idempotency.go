@@ -0,0 +1,40 @@
+package idp
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotentRequest is a request made with an idempotency key.
+type IdempotentRequest struct {
+	// Fingerprint identifies the method, the path and the body of the request.
+	Fingerprint string
+
+	// Response is nil while the request is in progress.
+	Response *IdempotentResponse
+
+	Time time.Time
+}
+
+// IdempotentResponse is the response to an IdempotentRequest
+// that is sent again when the request is repeated.
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// IdempotencyStore keeps the requests made with idempotency keys along with their responses,
+// the keys are scoped to the users.
+type IdempotencyStore interface {
+	// BeginIdempotentRequest stores a new request in progress with the key unless the user
+	// has made a request with the key after since, in which case that request is returned
+	// along with false. The requests of the user made before since are deleted.
+	BeginIdempotentRequest(ctx context.Context, user int64, key, fingerprint string, since time.Time) (IdempotentRequest, bool, error)
+
+	// CompleteIdempotentRequest stores the response to the request in progress.
+	CompleteIdempotentRequest(ctx context.Context, user int64, key string, response IdempotentResponse) error
+
+	// CancelIdempotentRequest deletes the request so that it can be made again with the key.
+	CancelIdempotentRequest(ctx context.Context, user int64, key string) error
+}
@@ -0,0 +1,20 @@
+package idp
+
+import "time"
+
+// idempotencyColumns are the columns of the idempotency_keys table scanned by scanIdempotentRequest.
+const idempotencyColumns = `fingerprint, status, content_type, body, time`
+
+func scanIdempotentRequest(scan func(dest ...any) error) (IdempotentRequest, error) {
+	request := IdempotentRequest{}
+	response := IdempotentResponse{}
+	var requestTime int64
+	if err := scan(&request.Fingerprint, &response.Status, &response.ContentType, &response.Body, &requestTime); err != nil {
+		return IdempotentRequest{}, err
+	}
+	if response.Status != 0 {
+		request.Response = &response
+	}
+	request.Time = time.UnixMilli(requestTime)
+	return request, nil
+}
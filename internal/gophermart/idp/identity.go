@@ -7,4 +7,11 @@ type Identity interface {
 
 	// ComparePassword compares the provided password by the password of this identity.
 	ComparePassword(ctx context.Context, password string) (bool, error)
+
+	// SetPassword changes the password of this identity.
+	SetPassword(ctx context.Context, password string) error
+
+	// PasswordGeneration returns the number of the changes of the password of this identity,
+	// the tokens issued for an older generation are no longer valid.
+	PasswordGeneration(ctx context.Context) (int64, error)
 }
@@ -1,11 +1,22 @@
 package idp
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrUnknownIdentity is returned when there is no identity with the requested username or id.
+var ErrUnknownIdentity = errors.New("unknown identity")
 
 type IdentityDatabase interface {
 	// Create creates a new identity in the database.
 	Create(ctx context.Context, username, password string) error
 
-	// Identity returns the identity by the provided username.
-	Identity(username string) Identity
+	// Find returns the identity by the provided username.
+	Find(ctx context.Context, username string) (Identity, error)
+
+	// Identity returns the identity by the provided id.
+	//
+	// It returns ErrUnknownIdentity if there is no such identity or it has been deleted.
+	Identity(ctx context.Context, id int64) (Identity, error)
 }
@@ -0,0 +1,64 @@
+package idptest
+
+import (
+	"encoding/json"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// accrualSystem is a fake accrual system that reports
+// the states of the orders it has been told about.
+type accrualSystem struct {
+	server *httptest.Server
+
+	mutex  *sync.Mutex
+	orders map[string]accrual.OrderInfo
+}
+
+// newAccrualSystem starts a new accrualSystem that is stopped when the test ends.
+func newAccrualSystem(t *testing.T) *accrualSystem {
+	system := &accrualSystem{
+		mutex:  &sync.Mutex{},
+		orders: make(map[string]accrual.OrderInfo),
+	}
+	system.server = httptest.NewServer(http.HandlerFunc(system.serveHTTP))
+	t.Cleanup(system.server.Close)
+	return system
+}
+
+// Accrual returns the client of the system.
+func (a *accrualSystem) Accrual() accrual.Accrual {
+	return accrual.New(a.server.URL, a.server.Client())
+}
+
+// Process makes the system report the order as processed with the amount of points.
+func (a *accrualSystem) Process(order string, points float64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.orders[order] = accrual.OrderInfo{
+		Order:   order,
+		Status:  accrual.OrderStatusProcessed,
+		Accrual: points,
+	}
+}
+
+func (a *accrualSystem) serveHTTP(out http.ResponseWriter, in *http.Request) {
+	order := strings.TrimPrefix(in.URL.Path, "/api/orders/")
+
+	a.mutex.Lock()
+	info, ok := a.orders[order]
+	a.mutex.Unlock()
+	if !ok {
+		out.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	out.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(out).Encode(info); err != nil {
+		http.Error(out, err.Error(), http.StatusInternalServerError)
+	}
+}
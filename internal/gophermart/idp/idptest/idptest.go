@@ -0,0 +1,1127 @@
+// Package idptest implements a conformance suite for idp.IdentityDatabase implementations.
+//
+// A storage backend runs the suite from its own tests:
+//
+//	func TestMyIdentityDatabase(t *testing.T) {
+//		idptest.TestIdentityDatabase(t, func(t *testing.T, accrual accrual.Accrual, publisher idp.Publisher) idptest.Database {
+//			return NewMyIdentityDatabase(accrual, publisher)
+//		})
+//	}
+package idptest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Database is an idp.IdentityDatabase, idp.Administration, idp.WebhookQueue, idp.IdempotencyStore
+// and idp.Health along with its background worker that connects to the storage and polls the accrual system.
+type Database interface {
+	idp.IdentityDatabase
+	idp.Administration
+	idp.WebhookQueue
+	idp.IdempotencyStore
+	idp.Health
+
+	// Run runs the worker until ctx is done.
+	Run(ctx context.Context) error
+}
+
+// Open opens a new Database that uses the provided accrual system
+// and publishes its events to publisher.
+//
+// The suite never relies on a database being empty, usernames and order
+// numbers are random, so Open may return databases sharing the same storage.
+type Open func(t *testing.T, accrual accrual.Accrual, publisher idp.Publisher) Database
+
+const (
+	// statusTimeout is how long the suite waits for the database
+	// to fetch an order status from the accrual system.
+	statusTimeout = 10 * time.Second
+
+	// availableTimeout is how long the suite waits for the database to connect.
+	availableTimeout = 10 * time.Second
+)
+
+// TestIdentityDatabase checks that the databases returned by open
+// follow the contract of idp.IdentityDatabase and idp.Identity.
+func TestIdentityDatabase(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, database Database, system *accrualSystem, broker idp.Broker)
+	}{
+		{"Create", testCreate},
+		{"Find", testFind},
+		{"ComparePassword", testComparePassword},
+		{"SetPassword", testSetPassword},
+		{"PasswordGeneration", testPasswordGeneration},
+		{"SetUsername", testSetUsername},
+		{"AddOrder", testAddOrder},
+		{"OrderStatus", testOrderStatus},
+		{"Balance", testBalance},
+		{"Withdraw", testWithdraw},
+		{"ConcurrentWithdraw", testConcurrentWithdraw},
+		{"AdjustBalance", testAdjustBalance},
+		{"RecheckOrder", testRecheckOrder},
+		{"OrderQuery", testOrderQuery},
+		{"WithdrawalQuery", testWithdrawalQuery},
+		{"Revision", testRevision},
+		{"Delete", testDelete},
+		{"Events", testEvents},
+		{"Webhooks", testWebhooks},
+		{"WebhookDeliveries", testWebhookDeliveries},
+		{"Idempotency", testIdempotency},
+		{"Health", testHealth},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			system := newAccrualSystem(t)
+			broker := idp.NewMemoryBroker()
+			database := open(t, system.Accrual(), broker)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- database.Run(ctx)
+			}()
+			t.Cleanup(func() {
+				cancel()
+				if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+					t.Errorf("database stopped with an error: %v", err)
+				}
+			})
+			AwaitAvailable(t, database)
+
+			test.test(t, database, system, broker)
+		})
+	}
+}
+
+// AwaitAvailable waits until the database that has been started is available.
+func AwaitAvailable(t *testing.T, database idp.Health) {
+	t.Helper()
+	deadline := time.Now().Add(availableTimeout)
+	for {
+		err := database.Available()
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, idp.ErrUnavailable) {
+			t.Fatalf("Available() = %v, want nil or idp.ErrUnavailable", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the database is unavailable after %s", availableTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Authenticate registers the login with the password "password" by the provider
+// and returns the token issued to the user and the identity of the user in the database.
+func Authenticate(t *testing.T, provider idp.IdentityProvider, database idp.IdentityDatabase, login string) (idp.Token, idp.Identity) {
+	t.Helper()
+	ctx := context.Background()
+	if err := provider.Register(ctx, login, "password"); err != nil {
+		t.Fatalf("Register() = %v, want nil", err)
+	}
+	token, authenticateError := provider.Authenticate(ctx, login, "password")
+	if authenticateError != nil {
+		t.Fatalf("Authenticate() = %v, want nil", authenticateError)
+	}
+	identity, findError := database.Find(ctx, login)
+	if findError != nil {
+		t.Fatalf("Find() = %v, want nil", findError)
+	}
+	return token, identity
+}
+
+func testHealth(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	if err := database.Check(context.Background()); err != nil {
+		t.Fatalf("Check() = %v, want nil", err)
+	}
+}
+
+func testCreate(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	username := randomUsername(t)
+
+	if err := database.Create(ctx, username, "password"); err != nil {
+		t.Fatalf("Create() = %v, want nil", err)
+	}
+	if err := database.Create(ctx, username, "password"); !errors.Is(err, idp.ErrDuplicateUsername) {
+		t.Fatalf("Create() with a taken username = %v, want %v", err, idp.ErrDuplicateUsername)
+	}
+}
+
+func testFind(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	first := createIdentity(t, database)
+	second := createIdentity(t, database)
+
+	if first.ID() == second.ID() {
+		t.Fatalf("identities share id %d", first.ID())
+	}
+
+	username, usernameError := first.Username(ctx)
+	if usernameError != nil {
+		t.Fatalf("Username() = %v, want nil", usernameError)
+	}
+	if identity, err := database.Identity(ctx, first.ID()); err != nil || identity.ID() != first.ID() {
+		t.Fatalf("Identity(%d) = %v, want identity %d", first.ID(), err, first.ID())
+	}
+	if _, err := database.Identity(ctx, second.ID()+1000000); !errors.Is(err, idp.ErrUnknownIdentity) {
+		t.Fatalf("Identity() of an unknown id = %v, want %v", err, idp.ErrUnknownIdentity)
+	}
+	found, findError := database.Find(ctx, username)
+	if findError != nil {
+		t.Fatalf("Find(%q) = %v, want nil", username, findError)
+	}
+	if found.ID() != first.ID() {
+		t.Fatalf("Find(%q).ID() = %d, want %d", username, found.ID(), first.ID())
+	}
+
+	if _, err := database.Find(ctx, randomUsername(t)); !errors.Is(err, idp.ErrUnknownIdentity) {
+		t.Fatalf("Find() of an unknown username = %v, want %v", err, idp.ErrUnknownIdentity)
+	}
+}
+
+func testComparePassword(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+
+	if ok, err := identity.ComparePassword(ctx, "password"); err != nil || !ok {
+		t.Fatalf("ComparePassword() with the right password = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := identity.ComparePassword(ctx, "wrong"); err != nil || ok {
+		t.Fatalf("ComparePassword() with a wrong password = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func testSetPassword(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+
+	if err := identity.SetPassword(ctx, "changed"); err != nil {
+		t.Fatalf("SetPassword() = %v, want nil", err)
+	}
+	if ok, err := identity.ComparePassword(ctx, "changed"); err != nil || !ok {
+		t.Fatalf("ComparePassword() with the new password = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := identity.ComparePassword(ctx, "password"); err != nil || ok {
+		t.Fatalf("ComparePassword() with the old password = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func testPasswordGeneration(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	username := mustUsername(t, identity)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+	token, authenticateError := provider.Authenticate(ctx, username, "password")
+	if authenticateError != nil {
+		t.Fatalf("Authenticate() = %v, want nil", authenticateError)
+	}
+	if _, err := provider.User(ctx, token); err != nil {
+		t.Fatalf("User() = %v, want nil", err)
+	}
+
+	if err := identity.SetPassword(ctx, "changed"); err != nil {
+		t.Fatalf("SetPassword() = %v, want nil", err)
+	}
+	if generation, err := identity.PasswordGeneration(ctx); err != nil || generation != 1 {
+		t.Fatalf("PasswordGeneration() after a change = %v, %v, want 1, nil", generation, err)
+	}
+	if _, err := provider.User(ctx, token); !errors.Is(err, idp.ErrBadCredentials) {
+		t.Fatalf("User() with a token issued before the change of the password = %v, want %v", err, idp.ErrBadCredentials)
+	}
+	changed, authenticateError := provider.Authenticate(ctx, username, "changed")
+	if authenticateError != nil {
+		t.Fatalf("Authenticate() with the new password = %v, want nil", authenticateError)
+	}
+	if _, err := provider.User(ctx, changed); err != nil {
+		t.Fatalf("User() with a token issued after the change of the password = %v, want nil", err)
+	}
+}
+
+func testSetUsername(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	other := createIdentity(t, database)
+
+	oldUsername := mustUsername(t, identity)
+	newUsername := randomUsername(t)
+	if err := identity.SetUsername(ctx, newUsername); err != nil {
+		t.Fatalf("SetUsername() = %v, want nil", err)
+	}
+	if username := mustUsername(t, identity); username != newUsername {
+		t.Fatalf("Username() = %q, want %q", username, newUsername)
+	}
+	found, findError := database.Find(ctx, newUsername)
+	if findError != nil || found.ID() != identity.ID() {
+		t.Fatalf("Find() of the new username = %v, want identity %d", findError, identity.ID())
+	}
+	if _, err := database.Find(ctx, oldUsername); !errors.Is(err, idp.ErrUnknownIdentity) {
+		t.Fatalf("Find() of the old username = %v, want %v", err, idp.ErrUnknownIdentity)
+	}
+
+	if err := identity.SetUsername(ctx, mustUsername(t, other)); !errors.Is(err, idp.ErrDuplicateUsername) {
+		t.Fatalf("SetUsername() to a taken username = %v, want %v", err, idp.ErrDuplicateUsername)
+	}
+}
+
+func testAddOrder(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	owner := createIdentity(t, database)
+	other := createIdentity(t, database)
+	order := randomOrder()
+
+	if err := owner.AddOrder(ctx, "12345"); !errors.Is(err, idp.ErrOrderInvalid) {
+		t.Fatalf("AddOrder() of an invalid number = %v, want %v", err, idp.ErrOrderInvalid)
+	}
+	if err := owner.AddOrder(ctx, order); err != nil {
+		t.Fatalf("AddOrder() = %v, want nil", err)
+	}
+	if err := owner.AddOrder(ctx, order); !errors.Is(err, idp.ErrOrderDuplicate) {
+		t.Fatalf("AddOrder() of an order added by the same user = %v, want %v", err, idp.ErrOrderDuplicate)
+	}
+	if err := other.AddOrder(ctx, order); !errors.Is(err, idp.ErrOrderUnowned) {
+		t.Fatalf("AddOrder() of an order added by another user = %v, want %v", err, idp.ErrOrderUnowned)
+	}
+
+	orders, ordersError := owner.Orders(ctx, idp.OrderQuery{})
+	if ordersError != nil {
+		t.Fatalf("Orders() = %v, want nil", ordersError)
+	}
+	if len(orders) != 1 || orders[0].ID != order {
+		t.Fatalf("Orders() = %v, want only order %s", orders, order)
+	}
+	if orders[0].Time.IsZero() {
+		t.Fatalf("Orders()[0].Time is zero")
+	}
+	if !orders[0].Processed.IsZero() {
+		t.Fatalf("Orders()[0].Processed of a new order = %v, want zero", orders[0].Processed)
+	}
+
+	otherOrders, otherOrdersError := other.Orders(ctx, idp.OrderQuery{})
+	if otherOrdersError != nil || len(otherOrders) != 0 {
+		t.Fatalf("Orders() of another user = %v, %v, want no orders", otherOrders, otherOrdersError)
+	}
+}
+
+func testOrderStatus(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	processed := randomOrder()
+	unknown := randomOrder()
+	system.Process(processed, 42.5)
+
+	for _, order := range []string{processed, unknown} {
+		if err := identity.AddOrder(ctx, order); err != nil {
+			t.Fatalf("AddOrder() = %v, want nil", err)
+		}
+	}
+
+	orders := awaitOrders(t, identity)
+	for _, order := range orders {
+		switch order.ID {
+		case processed:
+			if order.Status != idp.OrderStatusProcessed || order.Accrual != 42.5 {
+				t.Errorf("order known to the accrual system is %v, want PROCESSED with 42.5 points", order)
+			}
+		case unknown:
+			if order.Status != idp.OrderStatusInvalid {
+				t.Errorf("order unknown to the accrual system is %v, want INVALID", order)
+			}
+		}
+	}
+}
+
+func testBalance(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+
+	balance, balanceError := identity.Balance(ctx)
+	if balanceError != nil {
+		t.Fatalf("Balance() = %v, want nil", balanceError)
+	}
+	if balance != (idp.Balance{}) {
+		t.Fatalf("Balance() of a new user = %v, want zero", balance)
+	}
+
+	deposit(t, identity, system, 100, 50.5)
+	mustWithdraw(t, identity, 30)
+
+	balance, balanceError = identity.Balance(ctx)
+	if balanceError != nil {
+		t.Fatalf("Balance() = %v, want nil", balanceError)
+	}
+	if want := (idp.Balance{Current: 120.5, Withdrawn: 30}); balance != want {
+		t.Fatalf("Balance() = %v, want %v", balance, want)
+	}
+}
+
+func testWithdraw(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	other := createIdentity(t, database)
+	deposit(t, identity, system, 100)
+
+	if err := identity.Withdraw(ctx, "12345", 10); !errors.Is(err, idp.ErrOrderInvalid) {
+		t.Fatalf("Withdraw() towards an invalid number = %v, want %v", err, idp.ErrOrderInvalid)
+	}
+	for _, sum := range []float64{0, -10, math.NaN()} {
+		if err := identity.Withdraw(ctx, randomOrder(), sum); !errors.Is(err, idp.ErrWithdrawalSumInvalid) {
+			t.Fatalf("Withdraw() of %v points = %v, want %v", sum, err, idp.ErrWithdrawalSumInvalid)
+		}
+	}
+	if err := identity.Withdraw(ctx, randomOrder(), 100.01); !errors.Is(err, idp.ErrBalanceTooLow) {
+		t.Fatalf("Withdraw() of more than the balance = %v, want %v", err, idp.ErrBalanceTooLow)
+	}
+	if err := other.Withdraw(ctx, randomOrder(), 1); !errors.Is(err, idp.ErrBalanceTooLow) {
+		t.Fatalf("Withdraw() of another user = %v, want %v", err, idp.ErrBalanceTooLow)
+	}
+
+	order := randomOrder()
+	mustWithdraw(t, identity, 60)
+	if err := identity.Withdraw(ctx, order, 40); err != nil {
+		t.Fatalf("Withdraw() of the rest of the balance = %v, want nil", err)
+	}
+	deposit(t, identity, system, 10)
+	if err := identity.Withdraw(ctx, order, 5); !errors.Is(err, idp.ErrWithdrawalDuplicate) {
+		t.Fatalf("Withdraw() towards the same order = %v, want %v", err, idp.ErrWithdrawalDuplicate)
+	}
+
+	withdrawals, withdrawalsError := identity.Withdrawals(ctx, idp.ListQuery{})
+	if withdrawalsError != nil {
+		t.Fatalf("Withdrawals() = %v, want nil", withdrawalsError)
+	}
+	if len(withdrawals) != 2 {
+		t.Fatalf("Withdrawals() = %v, want 2 withdrawals", withdrawals)
+	}
+	for _, withdrawal := range withdrawals {
+		if withdrawal.Order == order && (withdrawal.Sum != 40 || withdrawal.Time.IsZero()) {
+			t.Fatalf("Withdrawals() has %v, want 40 points towards %s", withdrawal, order)
+		}
+	}
+
+	otherWithdrawals, otherWithdrawalsError := other.Withdrawals(ctx, idp.ListQuery{})
+	if otherWithdrawalsError != nil || len(otherWithdrawals) != 0 {
+		t.Fatalf("Withdrawals() of another user = %v, %v, want none", otherWithdrawals, otherWithdrawalsError)
+	}
+}
+
+func testConcurrentWithdraw(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	const (
+		attempts = 20
+		amount   = 10
+	)
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	deposit(t, identity, system, 95)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- identity.Withdraw(ctx, randomOrder(), amount)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, idp.ErrBalanceTooLow):
+			t.Errorf("Withdraw() = %v, want nil or %v", err, idp.ErrBalanceTooLow)
+		}
+	}
+	if succeeded != 9 {
+		t.Errorf("%d of %d concurrent withdrawals succeeded, want 9", succeeded, attempts)
+	}
+
+	balance, balanceError := identity.Balance(ctx)
+	if balanceError != nil {
+		t.Fatalf("Balance() = %v, want nil", balanceError)
+	}
+	if want := (idp.Balance{Current: 5, Withdrawn: 90}); balance != want {
+		t.Fatalf("Balance() after concurrent withdrawals = %v, want %v", balance, want)
+	}
+}
+
+func testAdjustBalance(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	deposit(t, identity, system, 100)
+	before := mustRevision(t, identity)
+
+	if err := database.AdjustBalance(ctx, identity.ID(), 25.5, "goodwill"); err != nil {
+		t.Fatalf("AdjustBalance() of a credit = %v, want nil", err)
+	}
+	if err := database.AdjustBalance(ctx, identity.ID(), -10, "correction"); err != nil {
+		t.Fatalf("AdjustBalance() of a debit = %v, want nil", err)
+	}
+	if err := database.AdjustBalance(ctx, identity.ID(), -115.51, "too much"); !errors.Is(err, idp.ErrBalanceTooLow) {
+		t.Fatalf("AdjustBalance() of a debit of more than the balance = %v, want %v", err, idp.ErrBalanceTooLow)
+	}
+	if err := database.AdjustBalance(ctx, identity.ID()+1000000, 10, "unknown"); !errors.Is(err, idp.ErrUnknownIdentity) {
+		t.Fatalf("AdjustBalance() of an unknown user = %v, want %v", err, idp.ErrUnknownIdentity)
+	}
+
+	balance, balanceError := identity.Balance(ctx)
+	if balanceError != nil {
+		t.Fatalf("Balance() = %v, want nil", balanceError)
+	}
+	if want := (idp.Balance{Current: 115.5}); balance != want {
+		t.Fatalf("Balance() after the adjustments = %v, want %v", balance, want)
+	}
+	if revision := mustRevision(t, identity); revision.Number != before.Number+2 {
+		t.Fatalf("Revision() after two adjustments = %d, want %d", revision.Number, before.Number+2)
+	}
+
+	adjustments, adjustmentsError := database.Adjustments(ctx, identity.ID())
+	if adjustmentsError != nil {
+		t.Fatalf("Adjustments() = %v, want nil", adjustmentsError)
+	}
+	if len(adjustments) != 2 ||
+		adjustments[0].Sum != 25.5 || adjustments[0].Reason != "goodwill" || adjustments[0].Time.IsZero() ||
+		adjustments[1].Sum != -10 || adjustments[1].Reason != "correction" {
+		t.Fatalf("Adjustments() = %v, want +25.5 for goodwill and -10 for correction", adjustments)
+	}
+}
+
+func testRecheckOrder(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	processed := randomOrder()
+	late := randomOrder()
+	system.Process(processed, 10)
+
+	for _, order := range []string{processed, late} {
+		if err := identity.AddOrder(ctx, order); err != nil {
+			t.Fatalf("AddOrder() = %v, want nil", err)
+		}
+	}
+	for _, order := range awaitOrders(t, identity) {
+		if order.Processed.Before(order.Time) {
+			t.Fatalf("order %v is processed before it is uploaded", order)
+		}
+	}
+
+	if err := database.RecheckOrder(ctx, randomOrder()); !errors.Is(err, idp.ErrUnknownOrder) {
+		t.Fatalf("RecheckOrder() of an unknown order = %v, want %v", err, idp.ErrUnknownOrder)
+	}
+	if err := database.RecheckOrder(ctx, processed); !errors.Is(err, idp.ErrOrderProcessed) {
+		t.Fatalf("RecheckOrder() of a processed order = %v, want %v", err, idp.ErrOrderProcessed)
+	}
+
+	// The accrual system learns about the order after it has been reported invalid.
+	system.Process(late, 5)
+	if err := database.RecheckOrder(ctx, late); err != nil {
+		t.Fatalf("RecheckOrder() of an invalid order = %v, want nil", err)
+	}
+	for _, order := range awaitOrders(t, identity) {
+		if order.ID == late && (order.Status != idp.OrderStatusProcessed || order.Accrual != 5 || order.Processed.Before(order.Time)) {
+			t.Fatalf("rechecked order is %v, want PROCESSED with 5 points", order)
+		}
+	}
+}
+
+func testOrderQuery(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	deposit(t, identity, system, 1, 2)
+	for i := 0; i < 3; i++ {
+		if err := identity.AddOrder(ctx, randomOrder()); err != nil {
+			t.Fatalf("AddOrder() = %v, want nil", err)
+		}
+	}
+	all := awaitOrders(t, identity)
+
+	ascending := mustOrders(t, identity, idp.OrderQuery{})
+	if len(ascending) != len(all) {
+		t.Fatalf("Orders() = %v, want %d orders", ascending, len(all))
+	}
+	for i := 1; i < len(ascending); i++ {
+		if !cursorBefore(ascending[i-1].Cursor(), ascending[i].Cursor()) {
+			t.Fatalf("Orders() = %v, want oldest first", ascending)
+		}
+	}
+
+	descending := mustOrders(t, identity, idp.OrderQuery{ListQuery: idp.ListQuery{Descending: true}})
+	for i := range descending {
+		if descending[i].ID != ascending[len(ascending)-1-i].ID {
+			t.Fatalf("descending Orders() = %v, want the reverse of %v", descending, ascending)
+		}
+	}
+
+	paged := make([]idp.Order, 0)
+	query := idp.OrderQuery{ListQuery: idp.ListQuery{Limit: 2}}
+	for {
+		page := mustOrders(t, identity, query)
+		if len(page) > 2 {
+			t.Fatalf("Orders() with limit 2 = %v", page)
+		}
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+		cursor := page[len(page)-1].Cursor()
+		query.After = &cursor
+	}
+	if len(paged) != len(ascending) {
+		t.Fatalf("paged Orders() = %v, want %v", paged, ascending)
+	}
+	for i := range paged {
+		if paged[i].ID != ascending[i].ID {
+			t.Fatalf("paged Orders() = %v, want %v", paged, ascending)
+		}
+	}
+
+	processed := mustOrders(t, identity, idp.OrderQuery{Statuses: []idp.OrderStatus{idp.OrderStatusProcessed}})
+	if len(processed) != 2 {
+		t.Fatalf("Orders() with PROCESSED status = %v, want 2 orders", processed)
+	}
+	invalid := mustOrders(t, identity, idp.OrderQuery{
+		Statuses: []idp.OrderStatus{idp.OrderStatusInvalid, idp.OrderStatusNew},
+	})
+	if len(invalid) != 3 {
+		t.Fatalf("Orders() with INVALID or NEW status = %v, want 3 orders", invalid)
+	}
+
+	first, last := ascending[0].Time, ascending[len(ascending)-1].Time
+	if orders := mustOrders(t, identity, idp.OrderQuery{ListQuery: idp.ListQuery{To: first}}); len(orders) != 0 {
+		t.Fatalf("Orders() to the first order = %v, want none", orders)
+	}
+	if orders := mustOrders(t, identity, idp.OrderQuery{ListQuery: idp.ListQuery{From: first}}); len(orders) != len(all) {
+		t.Fatalf("Orders() from the first order = %v, want all", orders)
+	}
+	after := last.Add(time.Millisecond)
+	if orders := mustOrders(t, identity, idp.OrderQuery{ListQuery: idp.ListQuery{From: after}}); len(orders) != 0 {
+		t.Fatalf("Orders() from after the last order = %v, want none", orders)
+	}
+}
+
+func testWithdrawalQuery(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	deposit(t, identity, system, 10)
+	for i := 0; i < 5; i++ {
+		mustWithdraw(t, identity, 1)
+	}
+
+	ascending, ascendingError := identity.Withdrawals(ctx, idp.ListQuery{})
+	if ascendingError != nil || len(ascending) != 5 {
+		t.Fatalf("Withdrawals() = %v, %v, want 5 withdrawals", ascending, ascendingError)
+	}
+	for i := 1; i < len(ascending); i++ {
+		if !cursorBefore(ascending[i-1].Cursor(), ascending[i].Cursor()) {
+			t.Fatalf("Withdrawals() = %v, want oldest first", ascending)
+		}
+	}
+
+	paged := make([]idp.Withdrawal, 0)
+	query := idp.ListQuery{Descending: true, Limit: 2}
+	for {
+		page, pageError := identity.Withdrawals(ctx, query)
+		if pageError != nil {
+			t.Fatalf("Withdrawals() = %v, want nil", pageError)
+		}
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+		cursor := page[len(page)-1].Cursor()
+		query.After = &cursor
+	}
+	if len(paged) != len(ascending) {
+		t.Fatalf("paged descending Withdrawals() = %v, want the reverse of %v", paged, ascending)
+	}
+	for i := range paged {
+		if paged[i].Order != ascending[len(ascending)-1-i].Order {
+			t.Fatalf("paged descending Withdrawals() = %v, want the reverse of %v", paged, ascending)
+		}
+	}
+}
+
+func testRevision(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	other := createIdentity(t, database)
+
+	initial := mustRevision(t, identity)
+	if !initial.Time.IsZero() {
+		t.Fatalf("Revision() of a new user = %v, want zero time", initial)
+	}
+	otherInitial := mustRevision(t, other)
+
+	order := randomOrder()
+	system.Process(order, 100)
+	if err := identity.AddOrder(ctx, order); err != nil {
+		t.Fatalf("AddOrder() = %v, want nil", err)
+	}
+	added := mustRevision(t, identity)
+	if added.Number <= initial.Number || added.Time.IsZero() {
+		t.Fatalf("Revision() after AddOrder() = %v, want a later one than %v", added, initial)
+	}
+	awaitOrders(t, identity)
+	processed := mustRevision(t, identity)
+	if processed.Number < initial.Number+2 {
+		t.Fatalf("Revision() after the order is added and processed = %v, want two changes since %v", processed, initial)
+	}
+
+	if err := identity.AddOrder(ctx, order); !errors.Is(err, idp.ErrOrderDuplicate) {
+		t.Fatalf("AddOrder() of the same order = %v, want %v", err, idp.ErrOrderDuplicate)
+	}
+	if err := identity.Withdraw(ctx, randomOrder(), 1000); !errors.Is(err, idp.ErrBalanceTooLow) {
+		t.Fatalf("Withdraw() of more than the balance = %v, want %v", err, idp.ErrBalanceTooLow)
+	}
+	if revision := mustRevision(t, identity); revision != processed {
+		t.Fatalf("Revision() after failed changes = %v, want %v", revision, processed)
+	}
+
+	mustWithdraw(t, identity, 10)
+	if revision := mustRevision(t, identity); revision.Number <= processed.Number {
+		t.Fatalf("Revision() after Withdraw() = %v, want a later one than %v", revision, processed)
+	}
+	if revision := mustRevision(t, other); revision != otherInitial {
+		t.Fatalf("Revision() of another user = %v, want %v", revision, otherInitial)
+	}
+}
+
+func testDelete(t *testing.T, database Database, system *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	username := mustUsername(t, identity)
+	deposit(t, identity, system, 100)
+	mustWithdraw(t, identity, 25)
+	provider := idp.NewBearerIdentityProvider(database, []byte("secret"))
+	token, authenticateError := provider.Authenticate(ctx, username, "password")
+	if authenticateError != nil {
+		t.Fatalf("Authenticate() = %v, want nil", authenticateError)
+	}
+	if _, _, err := database.BeginIdempotentRequest(ctx, identity.ID(), "key", "fingerprint", time.Time{}); err != nil {
+		t.Fatalf("BeginIdempotentRequest() = %v, want nil", err)
+	}
+	response := idp.IdempotentResponse{Status: 200, ContentType: "application/json", Body: []byte("{}")}
+	if err := database.CompleteIdempotentRequest(ctx, identity.ID(), "key", response); err != nil {
+		t.Fatalf("CompleteIdempotentRequest() = %v, want nil", err)
+	}
+
+	if err := identity.Delete(ctx); err != nil {
+		t.Fatalf("Delete() = %v, want nil", err)
+	}
+
+	if _, created, err := database.BeginIdempotentRequest(ctx, identity.ID(), "key", "fingerprint", time.Time{}); err != nil || !created {
+		t.Fatalf("BeginIdempotentRequest() with a key of a deleted identity = %v, %v, want true, nil", created, err)
+	}
+
+	if _, err := database.Identity(ctx, identity.ID()); !errors.Is(err, idp.ErrUnknownIdentity) {
+		t.Fatalf("Identity() of a deleted identity = %v, want %v", err, idp.ErrUnknownIdentity)
+	}
+	if _, err := provider.User(ctx, token); !errors.Is(err, idp.ErrBadCredentials) {
+		t.Fatalf("User() with a token of a deleted identity = %v, want %v", err, idp.ErrBadCredentials)
+	}
+
+	if _, err := database.Find(ctx, username); !errors.Is(err, idp.ErrUnknownIdentity) {
+		t.Fatalf("Find() of a deleted username = %v, want %v", err, idp.ErrUnknownIdentity)
+	}
+	if ok, err := identity.ComparePassword(ctx, "password"); err != nil || ok {
+		t.Fatalf("ComparePassword() of a deleted identity = %v, %v, want false, nil", ok, err)
+	}
+	balance, balanceError := identity.Balance(ctx)
+	if balanceError != nil {
+		t.Fatalf("Balance() = %v, want nil", balanceError)
+	}
+	if want := (idp.Balance{Current: 75, Withdrawn: 25}); balance != want {
+		t.Fatalf("Balance() of a deleted identity = %v, want %v", balance, want)
+	}
+
+	if err := database.Create(ctx, username, "password"); err != nil {
+		t.Fatalf("Create() with the username of a deleted identity = %v, want nil", err)
+	}
+	reused, findError := database.Find(ctx, username)
+	if findError != nil {
+		t.Fatalf("Find() = %v, want nil", findError)
+	}
+	if reused.ID() == identity.ID() {
+		t.Fatalf("new identity reuses id %d of a deleted identity", identity.ID())
+	}
+}
+
+func testEvents(t *testing.T, database Database, system *accrualSystem, broker idp.Broker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	identity := createIdentity(t, database)
+	other := createIdentity(t, database)
+	events := broker.Subscribe(ctx, identity.ID())
+
+	order := randomOrder()
+	system.Process(order, 42.5)
+	if err := other.AddOrder(ctx, randomOrder()); err != nil {
+		t.Fatalf("AddOrder() = %v, want nil", err)
+	}
+	if err := identity.AddOrder(ctx, order); err != nil {
+		t.Fatalf("AddOrder() = %v, want nil", err)
+	}
+	awaitEvent(t, identity, events, "the new order", func(event idp.Event) bool {
+		return event.Order != nil && event.Order.ID == order && event.Order.Status == idp.OrderStatusNew
+	})
+	awaitEvent(t, identity, events, "the processed order with the new balance", func(event idp.Event) bool {
+		return event.Order != nil && event.Order.ID == order &&
+			event.Order.Status == idp.OrderStatusProcessed && event.Order.Accrual == 42.5 &&
+			event.Balance != nil && *event.Balance == idp.Balance{Current: 42.5}
+	})
+
+	mustWithdraw(t, identity, 10)
+	awaitEvent(t, identity, events, "the balance after the withdrawal", func(event idp.Event) bool {
+		return event.Balance != nil && *event.Balance == idp.Balance{Current: 32.5, Withdrawn: 10}
+	})
+}
+
+func testWebhooks(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	other := createIdentity(t, database)
+
+	invalid := []string{
+		"",
+		"example.com/hook",
+		"ftp://example.com/hook",
+		"http:///hook",
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+	}
+	for _, url := range invalid {
+		if _, err := identity.AddWebhook(ctx, url); !errors.Is(err, idp.ErrWebhookURLInvalid) {
+			t.Fatalf("AddWebhook(%q) = %v, want %v", url, err, idp.ErrWebhookURLInvalid)
+		}
+	}
+
+	webhook, addError := identity.AddWebhook(ctx, "https://example.com/hook")
+	if addError != nil {
+		t.Fatalf("AddWebhook() = %v, want nil", addError)
+	}
+	if webhook.URL != "https://example.com/hook" || webhook.Secret == "" {
+		t.Fatalf("AddWebhook() = %+v, want the URL and a secret", webhook)
+	}
+	webhooks, webhooksError := identity.Webhooks(ctx)
+	if webhooksError != nil {
+		t.Fatalf("Webhooks() = %v, want nil", webhooksError)
+	}
+	if len(webhooks) != 1 || webhooks[0].ID != webhook.ID || webhooks[0].Secret != webhook.Secret {
+		t.Fatalf("Webhooks() = %+v, want [%+v]", webhooks, webhook)
+	}
+	if others, err := other.Webhooks(ctx); err != nil || len(others) != 0 {
+		t.Fatalf("Webhooks() of another identity = %+v, %v, want [], nil", others, err)
+	}
+
+	if _, err := other.Deliveries(ctx, webhook.ID, 0); !errors.Is(err, idp.ErrUnknownWebhook) {
+		t.Fatalf("Deliveries() of another identity's webhook = %v, want %v", err, idp.ErrUnknownWebhook)
+	}
+	if err := other.DeleteWebhook(ctx, webhook.ID); !errors.Is(err, idp.ErrUnknownWebhook) {
+		t.Fatalf("DeleteWebhook() of another identity's webhook = %v, want %v", err, idp.ErrUnknownWebhook)
+	}
+	if err := identity.DeleteWebhook(ctx, webhook.ID); err != nil {
+		t.Fatalf("DeleteWebhook() = %v, want nil", err)
+	}
+	if err := identity.DeleteWebhook(ctx, webhook.ID); !errors.Is(err, idp.ErrUnknownWebhook) {
+		t.Fatalf("DeleteWebhook() of a deleted webhook = %v, want %v", err, idp.ErrUnknownWebhook)
+	}
+	if webhooks, err := identity.Webhooks(ctx); err != nil || len(webhooks) != 0 {
+		t.Fatalf("Webhooks() after DeleteWebhook() = %+v, %v, want [], nil", webhooks, err)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	identity := createIdentity(t, database)
+	other := createIdentity(t, database)
+	webhook, addError := identity.AddWebhook(ctx, "https://example.com/hook")
+	if addError != nil {
+		t.Fatalf("AddWebhook() = %v, want nil", addError)
+	}
+	otherWebhook, otherAddError := other.AddWebhook(ctx, "https://example.com/other")
+	if otherAddError != nil {
+		t.Fatalf("AddWebhook() = %v, want nil", otherAddError)
+	}
+	t.Cleanup(func() {
+		_ = identity.DeleteWebhook(ctx, webhook.ID)
+		_ = other.DeleteWebhook(ctx, otherWebhook.ID)
+	})
+
+	payload := []byte(`{"type":"test"}`)
+	if err := database.EnqueueDeliveries(ctx, identity.ID(), "test", payload); err != nil {
+		t.Fatalf("EnqueueDeliveries() = %v, want nil", err)
+	}
+	deliveries, deliveriesError := identity.Deliveries(ctx, webhook.ID, 0)
+	if deliveriesError != nil {
+		t.Fatalf("Deliveries() = %v, want nil", deliveriesError)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("Deliveries() = %+v, want a single delivery", deliveries)
+	}
+	delivery := deliveries[0]
+	if delivery.Event != "test" || string(delivery.Payload) != string(payload) ||
+		delivery.Status != idp.DeliveryStatusPending || delivery.Attempts != 0 {
+		t.Fatalf("Deliveries() = %+v, want a pending delivery of the event", delivery)
+	}
+	if others, err := other.Deliveries(ctx, otherWebhook.ID, 0); err != nil || len(others) != 0 {
+		t.Fatalf("Deliveries() to another identity's webhook = %+v, %v, want [], nil", others, err)
+	}
+
+	now := time.Now()
+	due := dueDelivery(t, database, now, delivery.ID)
+	if due.Webhook.ID != webhook.ID || due.Webhook.URL != webhook.URL || due.Webhook.Secret != webhook.Secret {
+		t.Fatalf("DueDeliveries() webhook = %+v, want %+v", due.Webhook, webhook)
+	}
+
+	due.Attempts = 1
+	due.NextAttempt = now.Add(time.Hour)
+	due.ResponseStatus = 500
+	due.Error = "unexpected status 500"
+	due.UpdatedAt = now
+	if err := database.UpdateDelivery(ctx, due); err != nil {
+		t.Fatalf("UpdateDelivery() = %v, want nil", err)
+	}
+	if d := dueDelivery(t, database, now, delivery.ID); d.ID != 0 {
+		t.Fatalf("DueDeliveries() returned a delivery postponed by an hour")
+	}
+	retry := dueDelivery(t, database, now.Add(2*time.Hour), delivery.ID)
+	if retry.ID == 0 || retry.Attempts != 1 || retry.ResponseStatus != 500 || retry.Error != due.Error {
+		t.Fatalf("DueDeliveries() in two hours = %+v, want the failed attempt", retry)
+	}
+
+	retry.Status = idp.DeliveryStatusDelivered
+	retry.Attempts = 2
+	retry.ResponseStatus = 204
+	retry.Error = ""
+	if err := database.UpdateDelivery(ctx, retry); err != nil {
+		t.Fatalf("UpdateDelivery() = %v, want nil", err)
+	}
+	if d := dueDelivery(t, database, now.Add(2*time.Hour), delivery.ID); d.ID != 0 {
+		t.Fatalf("DueDeliveries() returned a delivered delivery")
+	}
+	delivered, deliveredError := identity.Deliveries(ctx, webhook.ID, 1)
+	if deliveredError != nil {
+		t.Fatalf("Deliveries() = %v, want nil", deliveredError)
+	}
+	if len(delivered) != 1 || delivered[0].Status != idp.DeliveryStatusDelivered ||
+		delivered[0].Attempts != 2 || delivered[0].ResponseStatus != 204 {
+		t.Fatalf("Deliveries() = %+v, want the delivered delivery", delivered)
+	}
+}
+
+func testIdempotency(t *testing.T, database Database, _ *accrualSystem, _ idp.Broker) {
+	ctx := context.Background()
+	user := createIdentity(t, database).ID()
+	other := createIdentity(t, database).ID()
+	since := time.Now().Add(-time.Hour)
+
+	begin := func(user int64, key, fingerprint string, since time.Time) (idp.IdempotentRequest, bool) {
+		t.Helper()
+		request, created, err := database.BeginIdempotentRequest(ctx, user, key, fingerprint, since)
+		if err != nil {
+			t.Fatalf("BeginIdempotentRequest() = %v, want nil", err)
+		}
+		return request, created
+	}
+
+	if _, created := begin(user, "key", "a", since); !created {
+		t.Fatalf("BeginIdempotentRequest() of a new key did not create a request")
+	}
+	if request, created := begin(user, "key", "b", since); created || request.Fingerprint != "a" || request.Response != nil {
+		t.Fatalf("BeginIdempotentRequest() of a used key = %+v, %v, want the request in progress", request, created)
+	}
+	if _, created := begin(other, "key", "b", since); !created {
+		t.Fatalf("BeginIdempotentRequest() of the key of another identity did not create a request")
+	}
+
+	response := idp.IdempotentResponse{Status: 202, ContentType: "application/json", Body: []byte(`{}`)}
+	if err := database.CompleteIdempotentRequest(ctx, user, "key", response); err != nil {
+		t.Fatalf("CompleteIdempotentRequest() = %v, want nil", err)
+	}
+	request, created := begin(user, "key", "a", since)
+	if created || request.Response == nil || request.Response.Status != response.Status ||
+		request.Response.ContentType != response.ContentType || string(request.Response.Body) != string(response.Body) {
+		t.Fatalf("BeginIdempotentRequest() of a completed key = %+v, %v, want the response", request, created)
+	}
+	if request, _ := begin(other, "key", "b", since); request.Response != nil {
+		t.Fatalf("the response is stored for the key of another identity")
+	}
+
+	if err := database.CancelIdempotentRequest(ctx, other, "key"); err != nil {
+		t.Fatalf("CancelIdempotentRequest() = %v, want nil", err)
+	}
+	if _, created := begin(other, "key", "c", since); !created {
+		t.Fatalf("BeginIdempotentRequest() of a cancelled key did not create a request")
+	}
+
+	if _, created := begin(user, "key", "d", time.Now().Add(time.Second)); !created {
+		t.Fatalf("BeginIdempotentRequest() of an expired key did not create a request")
+	}
+}
+
+// createIdentity creates an identity with a random username and "password" as the password.
+func createIdentity(t *testing.T, database idp.IdentityDatabase) idp.Identity {
+	t.Helper()
+	ctx := context.Background()
+	username := randomUsername(t)
+	if err := database.Create(ctx, username, "password"); err != nil {
+		t.Fatalf("Create() = %v, want nil", err)
+	}
+	identity, findError := database.Find(ctx, username)
+	if findError != nil {
+		t.Fatalf("Find() = %v, want nil", findError)
+	}
+	return identity
+}
+
+// deposit adds processed orders worth points to the identity
+// and waits for the database to credit them.
+func deposit(t *testing.T, identity idp.Identity, system *accrualSystem, points ...float64) {
+	t.Helper()
+	for _, p := range points {
+		order := randomOrder()
+		system.Process(order, p)
+		if err := identity.AddOrder(context.Background(), order); err != nil {
+			t.Fatalf("AddOrder() = %v, want nil", err)
+		}
+	}
+	awaitOrders(t, identity)
+}
+
+// awaitOrders waits until all orders of the identity have final statuses.
+func awaitOrders(t *testing.T, identity idp.Identity) []idp.Order {
+	t.Helper()
+	deadline := time.Now().Add(statusTimeout)
+	for {
+		orders, ordersError := identity.Orders(context.Background(), idp.OrderQuery{})
+		if ordersError != nil {
+			t.Fatalf("Orders() = %v, want nil", ordersError)
+		}
+		final := true
+		for _, order := range orders {
+			final = final && order.Status.IsFinal()
+		}
+		if final {
+			return orders
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("orders are not processed in %s: %v", statusTimeout, orders)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// awaitEvent reads the events of the identity until one matches.
+func awaitEvent(t *testing.T, identity idp.Identity, events <-chan idp.Event, description string, match func(idp.Event) bool) {
+	t.Helper()
+	timeout := time.After(statusTimeout)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("events are closed before %s", description)
+			}
+			if event.User != identity.ID() {
+				t.Fatalf("event of user %d is delivered to user %d", event.User, identity.ID())
+			}
+			if match(event) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no event of %s in %s", description, statusTimeout)
+		}
+	}
+}
+
+// dueDelivery returns the delivery with the id if it is due by now, or a zero Delivery.
+func dueDelivery(t *testing.T, queue idp.WebhookQueue, now time.Time, id int64) idp.Delivery {
+	t.Helper()
+	due, dueError := queue.DueDeliveries(context.Background(), now, 1000)
+	if dueError != nil {
+		t.Fatalf("DueDeliveries() = %v, want nil", dueError)
+	}
+	for _, delivery := range due {
+		if delivery.ID == id {
+			return delivery
+		}
+	}
+	return idp.Delivery{}
+}
+
+func mustOrders(t *testing.T, identity idp.Identity, query idp.OrderQuery) []idp.Order {
+	t.Helper()
+	orders, ordersError := identity.Orders(context.Background(), query)
+	if ordersError != nil {
+		t.Fatalf("Orders(%+v) = %v, want nil", query, ordersError)
+	}
+	return orders
+}
+
+// cursorBefore reports whether a goes before b in the oldest first order.
+func cursorBefore(a, b idp.Cursor) bool {
+	if a.Time.UnixMilli() != b.Time.UnixMilli() {
+		return a.Time.UnixMilli() < b.Time.UnixMilli()
+	}
+	return a.Order < b.Order
+}
+
+func mustRevision(t *testing.T, identity idp.Identity) idp.Revision {
+	t.Helper()
+	revision, revisionError := identity.Revision(context.Background())
+	if revisionError != nil {
+		t.Fatalf("Revision() = %v, want nil", revisionError)
+	}
+	return revision
+}
+
+func mustWithdraw(t *testing.T, identity idp.Identity, amount float64) {
+	t.Helper()
+	if err := identity.Withdraw(context.Background(), randomOrder(), amount); err != nil {
+		t.Fatalf("Withdraw(%v) = %v, want nil", amount, err)
+	}
+}
+
+func mustUsername(t *testing.T, identity idp.Identity) string {
+	t.Helper()
+	username, usernameError := identity.Username(context.Background())
+	if usernameError != nil {
+		t.Fatalf("Username() = %v, want nil", usernameError)
+	}
+	return username
+}
+
+func randomUsername(t *testing.T) string {
+	t.Helper()
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	return "idptest-" + hex.EncodeToString(suffix)
+}
+
+func randomOrder() string {
+	return goluhn.Generate(16)
+}
@@ -0,0 +1,116 @@
+package idp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// ListQuery selects a page of a chronological list of records
+// (orders by upload time, withdrawals by processing time).
+//
+// The zero ListQuery selects every record, oldest first.
+type ListQuery struct {
+	// From excludes the records older than it, unless it is zero.
+	From time.Time
+
+	// To excludes the records that are not older than it, unless it is zero.
+	To time.Time
+
+	// Descending lists the newest records first.
+	Descending bool
+
+	// After continues the list after the record with this cursor, unless it is nil.
+	After *Cursor
+
+	// Limit is the maximum number of records, zero means no limit.
+	Limit int
+}
+
+// OrderQuery selects a page of the orders.
+type OrderQuery struct {
+	ListQuery
+
+	// Statuses keeps only the orders with one of the statuses, unless it is empty.
+	Statuses []OrderStatus
+}
+
+// Cursor is the position of a record in a chronological list,
+// records with the same time are ordered by their order numbers.
+type Cursor struct {
+	Time  time.Time
+	Order string
+}
+
+type cursorJSON struct {
+	Time  int64  `json:"t"`
+	Order string `json:"o"`
+}
+
+// String encodes the cursor as an opaque URL-safe token.
+func (c Cursor) String() string {
+	encoded, _ := json.Marshal(cursorJSON{Time: c.Time.UnixMilli(), Order: c.Order})
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// ParseCursor decodes a cursor encoded by Cursor.String.
+func ParseCursor(cursor string) (Cursor, error) {
+	decoded, decodeError := base64.RawURLEncoding.DecodeString(cursor)
+	if decodeError != nil {
+		return Cursor{}, decodeError
+	}
+	var c cursorJSON
+	if err := json.Unmarshal(decoded, &c); err != nil {
+		return Cursor{}, err
+	}
+	return Cursor{Time: time.UnixMilli(c.Time), Order: c.Order}, nil
+}
+
+// Cursor returns the position of the order.
+func (o Order) Cursor() Cursor {
+	return Cursor{Time: o.Time, Order: o.ID}
+}
+
+// Cursor returns the position of the withdrawal.
+func (w Withdrawal) Cursor() Cursor {
+	return Cursor{Time: w.Time, Order: w.Order}
+}
+
+// matches reports whether a record at the position is selected by the query, ignoring the limit.
+func (q ListQuery) matches(position Cursor) bool {
+	if !q.From.IsZero() && position.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !position.Time.Before(q.To) {
+		return false
+	}
+	if q.After != nil {
+		if q.Descending {
+			return position.before(*q.After)
+		}
+		return q.After.before(position)
+	}
+	return true
+}
+
+// before reports whether c goes before other in the oldest first order.
+func (c Cursor) before(other Cursor) bool {
+	if c.Time.UnixMilli() != other.Time.UnixMilli() {
+		return c.Time.UnixMilli() < other.Time.UnixMilli()
+	}
+	return c.Order < other.Order
+}
+
+// matches reports whether the order is selected by the query, ignoring the limit.
+func (q OrderQuery) matches(order Order) bool {
+	if len(q.Statuses) > 0 {
+		found := false
+		for _, status := range q.Statuses {
+			found = found || status == order.Status
+		}
+		if !found {
+			return false
+		}
+	}
+	return q.ListQuery.matches(order.Cursor())
+}
@@ -0,0 +1,59 @@
+package idp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// listQuerySQL builds a SELECT of the columns of the records of the owner in the table
+// that are selected by query, the table must have "owner" and "time" columns and
+// the order number column named orderColumn.
+//
+// The statuses are matched against the "status" column, unless there are none.
+func listQuerySQL(
+	columns, table, orderColumn string,
+	owner int64,
+	query ListQuery,
+	statuses []OrderStatus,
+	placeholder func(n int) string,
+) (string, []any) {
+	args := make([]any, 0)
+	arg := func(value any) string {
+		args = append(args, value)
+		return placeholder(len(args))
+	}
+
+	conditions := []string{"owner = " + arg(owner)}
+	if len(statuses) > 0 {
+		in := make([]string, len(statuses))
+		for i, status := range statuses {
+			in[i] = arg(string(status))
+		}
+		conditions = append(conditions, "status IN ("+strings.Join(in, ", ")+")")
+	}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "time >= "+arg(query.From.UnixMilli()))
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "time < "+arg(query.To.UnixMilli()))
+	}
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if query.After != nil {
+		conditions = append(conditions, fmt.Sprintf(
+			"(time, %s) %s (%s, %s)",
+			orderColumn, comparison, arg(query.After.Time.UnixMilli()), arg(query.After.Order),
+		))
+	}
+
+	statement := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s ORDER BY time %s, %s %s",
+		columns, table, strings.Join(conditions, " AND "), direction, orderColumn, direction,
+	)
+	if query.Limit > 0 {
+		statement += " LIMIT " + arg(query.Limit)
+	}
+	return statement, args
+}
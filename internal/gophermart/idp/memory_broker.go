@@ -0,0 +1,110 @@
+package idp
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/gophermart/metrics"
+	"golang.org/x/exp/slog"
+	"sync"
+)
+
+const (
+	// memoryBrokerBuffer is the number of events a subscriber of a user may lag behind,
+	// the events are dropped for the subscribers that lag more.
+	memoryBrokerBuffer = 64
+
+	// memoryBrokerBufferAll is the number of events a subscriber of every user may lag behind.
+	memoryBrokerBufferAll = 4096
+)
+
+// MemoryBroker is a Broker that delivers the events within the process.
+//
+// The events are dropped for the subscribers lagging behind, the dropped events
+// are counted by metrics.BrokerDroppedEvents and logged.
+//
+// For a deployment of several instances it can be fed the events
+// published by the other instances (e.g. received by PostgreSQL LISTEN)
+// by calling Publish.
+type MemoryBroker struct {
+	mutex       *sync.Mutex
+	subscribers map[int64]map[chan Event]struct{}
+	all         map[chan Event]struct{}
+}
+
+// NewMemoryBroker creates a new MemoryBroker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		mutex:       &sync.Mutex{},
+		subscribers: make(map[int64]map[chan Event]struct{}),
+		all:         make(map[chan Event]struct{}),
+	}
+}
+
+func (m *MemoryBroker) Publish(_ context.Context, event Event) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for subscriber := range m.subscribers[event.User] {
+		select {
+		case subscriber <- event:
+		default:
+			dropEvent("user", event)
+		}
+	}
+	for subscriber := range m.all {
+		select {
+		case subscriber <- event:
+		default:
+			dropEvent("all", event)
+		}
+	}
+}
+
+func (m *MemoryBroker) Subscribe(ctx context.Context, user int64) <-chan Event {
+	subscriber := make(chan Event, memoryBrokerBuffer)
+
+	m.mutex.Lock()
+	if m.subscribers[user] == nil {
+		m.subscribers[user] = make(map[chan Event]struct{})
+	}
+	m.subscribers[user][subscriber] = struct{}{}
+	m.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.subscribers[user], subscriber)
+		if len(m.subscribers[user]) == 0 {
+			delete(m.subscribers, user)
+		}
+		close(subscriber)
+	}()
+
+	return subscriber
+}
+
+func (m *MemoryBroker) SubscribeAll(ctx context.Context) <-chan Event {
+	subscriber := make(chan Event, memoryBrokerBufferAll)
+
+	m.mutex.Lock()
+	m.all[subscriber] = struct{}{}
+	m.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.all, subscriber)
+		close(subscriber)
+	}()
+
+	return subscriber
+}
+
+// dropEvent counts and logs an event dropped for a subscriber of the subscription (user or all).
+func dropEvent(subscription string, event Event) {
+	metrics.BrokerDroppedEvents.WithLabelValues(subscription).Inc()
+	slog.Warn("dropped an event for a subscriber lagging behind", "subscription", subscription, "user", event.User)
+}
@@ -0,0 +1,30 @@
+package idp_test
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+)
+
+func TestMemoryBrokerCountsDroppedEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	broker := idp.NewMemoryBroker()
+	events := broker.SubscribeAll(ctx)
+
+	dropped := metrics.BrokerDroppedEvents.WithLabelValues("all")
+	before := testutil.ToFloat64(dropped)
+	published := 0
+	for testutil.ToFloat64(dropped) == before {
+		if published > 1<<16 {
+			t.Fatalf("no event of %d is dropped for a subscriber that does not read them", published)
+		}
+		broker.Publish(ctx, idp.Event{User: 1})
+		published++
+	}
+	if len(events) != published-1 {
+		t.Fatalf("the subscriber has %d events of %d published, want all but the dropped one", len(events), published)
+	}
+}
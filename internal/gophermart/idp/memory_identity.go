@@ -0,0 +1,434 @@
+package idp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/ShiraazMoollatjie/goluhn"
+	"golang.org/x/crypto/bcrypt"
+	"sort"
+	"time"
+)
+
+type MemoryIdentity struct {
+	id       int64
+	database *MemoryIdentityDatabase
+}
+
+// NewMemoryIdentity creates a new MemoryIdentity.
+func NewMemoryIdentity(id int64, database *MemoryIdentityDatabase) MemoryIdentity {
+	return MemoryIdentity{
+		id:       id,
+		database: database,
+	}
+}
+
+func (m MemoryIdentity) ID() int64 {
+	return m.id
+}
+
+func (m MemoryIdentity) Username(_ context.Context) (string, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	record, recordError := m.record()
+	if recordError != nil {
+		return "", recordError
+	}
+	return record.username, nil
+}
+
+func (m MemoryIdentity) SetUsername(_ context.Context, username string) error {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	record, recordError := m.record()
+	if recordError != nil {
+		return recordError
+	}
+	if owner, ok := m.database.usernames[username]; ok {
+		if owner == m.id {
+			return nil
+		}
+		return ErrDuplicateUsername
+	}
+	delete(m.database.usernames, record.username)
+	record.username = username
+	m.database.usernames[username] = m.id
+	return nil
+}
+
+func (m MemoryIdentity) AddOrder(ctx context.Context, id string) error {
+	if err := goluhn.Validate(id); err != nil {
+		return ErrOrderInvalid
+	}
+
+	order, addOrderError := m.addOrder(id)
+	if addOrderError != nil {
+		return addOrderError
+	}
+	publish(ctx, m.database.publisher, Event{User: m.id, Order: &order})
+	return nil
+}
+
+// addOrder stores a new order.
+func (m MemoryIdentity) addOrder(id string) (Order, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	if duplicate, ok := m.database.orders[id]; ok {
+		if duplicate.owner == m.id {
+			return Order{}, ErrOrderDuplicate
+		}
+		return Order{}, ErrOrderUnowned
+	}
+	order := Order{
+		ID:      id,
+		Status:  OrderStatusNew,
+		Accrual: 0.0,
+		Time:    memoryNow(),
+	}
+	m.database.orders[id] = &memoryOrderRecord{
+		owner: m.id,
+		order: order,
+	}
+	m.database.orderIDs = append(m.database.orderIDs, id)
+	m.database.touch(m.id)
+	return order, nil
+}
+
+func (m MemoryIdentity) Orders(_ context.Context, query OrderQuery) ([]Order, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	orders := make([]Order, 0)
+	for _, order := range m.orders() {
+		if query.matches(order) {
+			orders = append(orders, order)
+		}
+	}
+	sortMemoryRecords(orders, Order.Cursor, query.ListQuery)
+	return limitMemoryRecords(orders, query.ListQuery), nil
+}
+
+func (m MemoryIdentity) Balance(_ context.Context) (Balance, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	return m.balance(), nil
+}
+
+func (m MemoryIdentity) Withdraw(ctx context.Context, order string, amount float64) error {
+	if err := goluhn.Validate(order); err != nil {
+		return ErrOrderInvalid
+	}
+	if !ValidWithdrawalSum(amount) {
+		return ErrWithdrawalSumInvalid
+	}
+
+	withdrawal, balance, withdrawError := m.withdraw(order, amount)
+	if withdrawError != nil {
+		return withdrawError
+	}
+	publish(ctx, m.database.publisher, Event{User: m.id, Withdrawal: &withdrawal, Balance: &balance})
+	return nil
+}
+
+// withdraw stores the withdrawal and returns it along with the new balance.
+func (m MemoryIdentity) withdraw(order string, amount float64) (Withdrawal, Balance, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	if m.balance().Current < amount {
+		return Withdrawal{}, Balance{}, ErrBalanceTooLow
+	}
+	if _, ok := m.database.withdrawals[order]; ok {
+		return Withdrawal{}, Balance{}, ErrWithdrawalDuplicate
+	}
+	withdrawal := Withdrawal{
+		Order: order,
+		Sum:   amount,
+		Time:  memoryNow(),
+	}
+	m.database.withdrawals[order] = &memoryWithdrawalRecord{
+		owner:      m.id,
+		withdrawal: withdrawal,
+	}
+	m.database.withdrawIDs = append(m.database.withdrawIDs, order)
+	m.database.touch(m.id)
+	return withdrawal, m.balance(), nil
+}
+
+func (m MemoryIdentity) Withdrawals(_ context.Context, query ListQuery) ([]Withdrawal, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	withdrawals := make([]Withdrawal, 0)
+	for _, withdrawal := range m.withdrawals() {
+		if query.matches(withdrawal.Cursor()) {
+			withdrawals = append(withdrawals, withdrawal)
+		}
+	}
+	sortMemoryRecords(withdrawals, Withdrawal.Cursor, query)
+	return limitMemoryRecords(withdrawals, query), nil
+}
+
+// adjustBalance stores the adjustment and returns the new balance.
+func (m MemoryIdentity) adjustBalance(sum float64, reason string) (Balance, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	if record, recordError := m.record(); recordError != nil || record.deleted {
+		return Balance{}, ErrUnknownIdentity
+	}
+	if m.balance().Current+sum < 0 {
+		return Balance{}, ErrBalanceTooLow
+	}
+	m.database.adjustments = append(m.database.adjustments, memoryAdjustmentRecord{
+		owner: m.id,
+		adjustment: Adjustment{
+			Sum:    sum,
+			Reason: reason,
+			Time:   memoryNow(),
+		},
+	})
+	m.database.touch(m.id)
+	return m.balance(), nil
+}
+
+func (m MemoryIdentity) Revision(_ context.Context) (Revision, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	record, recordError := m.record()
+	if recordError != nil {
+		return Revision{}, recordError
+	}
+	return record.revision, nil
+}
+
+func (m MemoryIdentity) AddWebhook(_ context.Context, url string) (Webhook, error) {
+	webhook, webhookError := newWebhook(url, memoryNow(), m.database.AllowPrivateWebhooks)
+	if webhookError != nil {
+		return Webhook{}, webhookError
+	}
+
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	m.database.lastWebhookID++
+	webhook.ID = m.database.lastWebhookID
+	m.database.webhooks[webhook.ID] = &memoryWebhookRecord{
+		owner:   m.id,
+		webhook: webhook,
+	}
+	return webhook, nil
+}
+
+func (m MemoryIdentity) Webhooks(_ context.Context) ([]Webhook, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	webhooks := make([]Webhook, 0)
+	for id := int64(1); id <= m.database.lastWebhookID; id++ {
+		if record, ok := m.database.webhooks[id]; ok && record.owner == m.id {
+			webhooks = append(webhooks, record.webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (m MemoryIdentity) DeleteWebhook(_ context.Context, id int64) error {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	if record, ok := m.database.webhooks[id]; !ok || record.owner != m.id {
+		return ErrUnknownWebhook
+	}
+	m.deleteWebhook(id)
+	return nil
+}
+
+func (m MemoryIdentity) Deliveries(_ context.Context, webhook int64, limit int) ([]Delivery, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	if record, ok := m.database.webhooks[webhook]; !ok || record.owner != m.id {
+		return nil, ErrUnknownWebhook
+	}
+	deliveries := make([]Delivery, 0)
+	for id := m.database.lastDeliveryID; id > 0; id-- {
+		if limit > 0 && len(deliveries) == limit {
+			break
+		}
+		if delivery, ok := m.database.deliveries[id]; ok && delivery.Webhook.ID == webhook {
+			deliveries = append(deliveries, m.database.delivery(delivery))
+		}
+	}
+	return deliveries, nil
+}
+
+func (m MemoryIdentity) Delete(_ context.Context) error {
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	record, recordError := m.record()
+	if recordError != nil {
+		return recordError
+	}
+	delete(m.database.usernames, record.username)
+	record.username = "deleted-" + hex.EncodeToString(suffix)
+	record.passwordHash = nil
+	record.deleted = true
+	m.database.usernames[record.username] = m.id
+	for id, webhook := range m.database.webhooks {
+		if webhook.owner == m.id {
+			m.deleteWebhook(id)
+		}
+	}
+	for k := range m.database.idempotency {
+		if k.owner == m.id {
+			delete(m.database.idempotency, k)
+		}
+	}
+	return nil
+}
+
+func (m MemoryIdentity) ComparePassword(_ context.Context, password string) (bool, error) {
+	m.database.mutex.Lock()
+	record, recordError := m.record()
+	m.database.mutex.Unlock()
+	if recordError != nil {
+		return false, recordError
+	}
+
+	return bcrypt.CompareHashAndPassword(record.passwordHash, []byte(password)) == nil, nil
+}
+
+func (m MemoryIdentity) SetPassword(_ context.Context, password string) error {
+	passwordHash, passwordHashError := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if passwordHashError != nil {
+		return passwordHashError
+	}
+
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	record, recordError := m.record()
+	if recordError != nil {
+		return recordError
+	}
+	record.passwordHash = passwordHash
+	record.generation++
+	return nil
+}
+
+func (m MemoryIdentity) PasswordGeneration(_ context.Context) (int64, error) {
+	m.database.mutex.Lock()
+	defer m.database.mutex.Unlock()
+
+	record, recordError := m.record()
+	if recordError != nil {
+		return 0, recordError
+	}
+	return record.generation, nil
+}
+
+// record returns the identity record, the database must be locked.
+func (m MemoryIdentity) record() (*memoryIdentityRecord, error) {
+	record, ok := m.database.identities[m.id]
+	if !ok {
+		return nil, ErrUnknownIdentity
+	}
+	return record, nil
+}
+
+// deleteWebhook deletes the webhook and its deliveries, the database must be locked.
+func (m MemoryIdentity) deleteWebhook(id int64) {
+	delete(m.database.webhooks, id)
+	for deliveryID, delivery := range m.database.deliveries {
+		if delivery.Webhook.ID == id {
+			delete(m.database.deliveries, deliveryID)
+		}
+	}
+}
+
+// orders returns the orders of the identity, the database must be locked.
+func (m MemoryIdentity) orders() []Order {
+	orders := make([]Order, 0)
+	for _, id := range m.database.orderIDs {
+		if record := m.database.orders[id]; record.owner == m.id {
+			orders = append(orders, record.order)
+		}
+	}
+	return orders
+}
+
+// withdrawals returns the withdrawals of the identity, the database must be locked.
+func (m MemoryIdentity) withdrawals() []Withdrawal {
+	withdrawals := make([]Withdrawal, 0)
+	for _, order := range m.database.withdrawIDs {
+		if record := m.database.withdrawals[order]; record.owner == m.id {
+			withdrawals = append(withdrawals, record.withdrawal)
+		}
+	}
+	return withdrawals
+}
+
+// adjustments returns the adjustments of the balance of the identity, the database must be locked.
+func (m MemoryIdentity) adjustments() []Adjustment {
+	adjustments := make([]Adjustment, 0)
+	for _, record := range m.database.adjustments {
+		if record.owner == m.id {
+			adjustments = append(adjustments, record.adjustment)
+		}
+	}
+	return adjustments
+}
+
+// balance calculates the balance of the identity, the database must be locked.
+func (m MemoryIdentity) balance() Balance {
+	balance := Balance{}
+	for _, order := range m.orders() {
+		if order.Status == OrderStatusProcessed {
+			balance.Current += order.Accrual
+		}
+	}
+	for _, withdrawal := range m.withdrawals() {
+		balance.Current -= withdrawal.Sum
+		balance.Withdrawn += withdrawal.Sum
+	}
+	for _, adjustment := range m.adjustments() {
+		balance.Current += adjustment.Sum
+	}
+	return balance
+}
+
+// sortMemoryRecords sorts the records in the order requested by the query.
+func sortMemoryRecords[T any](records []T, cursor func(T) Cursor, query ListQuery) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if query.Descending {
+			return cursor(records[j]).before(cursor(records[i]))
+		}
+		return cursor(records[i]).before(cursor(records[j]))
+	})
+}
+
+// limitMemoryRecords drops the records exceeding the limit of the query.
+func limitMemoryRecords[T any](records []T, query ListQuery) []T {
+	if query.Limit > 0 && len(records) > query.Limit {
+		return records[:query.Limit]
+	}
+	return records
+}
+
+// memoryNow returns the current time with the millisecond precision
+// the other databases store the time with.
+func memoryNow() time.Time {
+	return time.UnixMilli(time.Now().UnixMilli())
+}
@@ -0,0 +1,373 @@
+package idp
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"golang.org/x/crypto/bcrypt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryIdentityDatabase is an IdentityDatabase that keeps everything in memory,
+// it is meant for development and tests.
+type MemoryIdentityDatabase struct {
+	accrual   accrual.Accrual
+	publisher Publisher
+
+	// PollInterval is how often the accrual system is polled for the orders being processed.
+	PollInterval time.Duration
+
+	// pollConcurrency is the maximum number of concurrent requests to the accrual system.
+	pollConcurrency atomic.Int64
+
+	// AllowPrivateWebhooks lets the webhooks point at the loopback, private
+	// and link-local addresses, e.g. at the receivers of the tests.
+	AllowPrivateWebhooks bool
+
+	mutex          *sync.Mutex
+	lastID         int64
+	identities     map[int64]*memoryIdentityRecord
+	usernames      map[string]int64
+	orders         map[string]*memoryOrderRecord
+	orderIDs       []string
+	withdrawals    map[string]*memoryWithdrawalRecord
+	withdrawIDs    []string
+	adjustments    []memoryAdjustmentRecord
+	webhooks       map[int64]*memoryWebhookRecord
+	lastWebhookID  int64
+	deliveries     map[int64]*Delivery
+	lastDeliveryID int64
+	idempotency    map[memoryIdempotencyKey]*IdempotentRequest
+}
+
+type memoryIdentityRecord struct {
+	username     string
+	passwordHash []byte
+	generation   int64
+	revision     Revision
+	deleted      bool
+}
+
+type memoryOrderRecord struct {
+	owner int64
+	order Order
+}
+
+type memoryWithdrawalRecord struct {
+	owner      int64
+	withdrawal Withdrawal
+}
+
+type memoryAdjustmentRecord struct {
+	owner      int64
+	adjustment Adjustment
+}
+
+type memoryWebhookRecord struct {
+	owner   int64
+	webhook Webhook
+}
+
+type memoryIdempotencyKey struct {
+	owner int64
+	key   string
+}
+
+// NewMemoryIdentityDatabase creates a new MemoryIdentityDatabase,
+// the changes of the orders and the balances are published to publisher unless it is nil.
+func NewMemoryIdentityDatabase(accrual accrual.Accrual, publisher Publisher) *MemoryIdentityDatabase {
+	database := &MemoryIdentityDatabase{
+		accrual:   accrual,
+		publisher: publisher,
+
+		PollInterval: defaultPollInterval,
+
+		mutex:          &sync.Mutex{},
+		lastID:         0,
+		identities:     make(map[int64]*memoryIdentityRecord),
+		usernames:      make(map[string]int64),
+		orders:         make(map[string]*memoryOrderRecord),
+		orderIDs:       make([]string, 0),
+		withdrawals:    make(map[string]*memoryWithdrawalRecord),
+		withdrawIDs:    make([]string, 0),
+		adjustments:    make([]memoryAdjustmentRecord, 0),
+		webhooks:       make(map[int64]*memoryWebhookRecord),
+		lastWebhookID:  0,
+		deliveries:     make(map[int64]*Delivery),
+		lastDeliveryID: 0,
+		idempotency:    make(map[memoryIdempotencyKey]*IdempotentRequest),
+	}
+	database.pollConcurrency.Store(defaultPollConcurrency)
+	return database
+}
+
+func (m *MemoryIdentityDatabase) Create(_ context.Context, username, password string) error {
+	passwordHash, passwordHashError := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if passwordHashError != nil {
+		return passwordHashError
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.usernames[username]; ok {
+		return ErrDuplicateUsername
+	}
+	m.lastID++
+	m.identities[m.lastID] = &memoryIdentityRecord{
+		username:     username,
+		passwordHash: passwordHash,
+	}
+	m.usernames[username] = m.lastID
+	return nil
+}
+
+func (m *MemoryIdentityDatabase) Find(_ context.Context, username string) (Identity, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	id, ok := m.usernames[username]
+	if !ok || m.identities[id].deleted {
+		return nil, ErrUnknownIdentity
+	}
+	return NewMemoryIdentity(id, m), nil
+}
+
+func (m *MemoryIdentityDatabase) Identity(_ context.Context, id int64) (Identity, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if record, ok := m.identities[id]; !ok || record.deleted {
+		return nil, ErrUnknownIdentity
+	}
+	return NewMemoryIdentity(id, m), nil
+}
+
+func (m *MemoryIdentityDatabase) AdjustBalance(ctx context.Context, user int64, sum float64, reason string) error {
+	balance, adjustError := NewMemoryIdentity(user, m).adjustBalance(sum, reason)
+	if adjustError != nil {
+		return adjustError
+	}
+	publish(ctx, m.publisher, Event{User: user, Balance: &balance})
+	return nil
+}
+
+func (m *MemoryIdentityDatabase) Adjustments(_ context.Context, user int64) ([]Adjustment, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return NewMemoryIdentity(user, m).adjustments(), nil
+}
+
+func (m *MemoryIdentityDatabase) RecheckOrder(ctx context.Context, id string) error {
+	m.mutex.Lock()
+	record, ok := m.orders[id]
+	if !ok {
+		m.mutex.Unlock()
+		return ErrUnknownOrder
+	}
+	if record.order.Status == OrderStatusProcessed {
+		m.mutex.Unlock()
+		return ErrOrderProcessed
+	}
+	record.order.Status = OrderStatusNew
+	record.order.Accrual = 0
+	record.order.Processed = time.Time{}
+	m.touch(record.owner)
+	order := record.order
+	m.mutex.Unlock()
+
+	publish(ctx, m.publisher, Event{User: record.owner, Order: &order})
+	return nil
+}
+
+func (m *MemoryIdentityDatabase) EnqueueDeliveries(_ context.Context, user int64, event string, payload []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := memoryNow()
+	for id := int64(1); id <= m.lastWebhookID; id++ {
+		record, ok := m.webhooks[id]
+		if !ok || record.owner != user {
+			continue
+		}
+		m.lastDeliveryID++
+		m.deliveries[m.lastDeliveryID] = &Delivery{
+			ID:          m.lastDeliveryID,
+			Webhook:     Webhook{ID: id},
+			Event:       event,
+			Payload:     payload,
+			Status:      DeliveryStatusPending,
+			NextAttempt: now,
+			Time:        now,
+			UpdatedAt:   now,
+		}
+	}
+	return nil
+}
+
+func (m *MemoryIdentityDatabase) DueDeliveries(_ context.Context, now time.Time, limit int) ([]Delivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	deliveries := make([]Delivery, 0)
+	for id := int64(1); id <= m.lastDeliveryID; id++ {
+		delivery, ok := m.deliveries[id]
+		if !ok || delivery.Status != DeliveryStatusPending || delivery.NextAttempt.After(now) {
+			continue
+		}
+		deliveries = append(deliveries, m.delivery(delivery))
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttempt.Before(deliveries[j].NextAttempt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (m *MemoryIdentityDatabase) UpdateDelivery(_ context.Context, delivery Delivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, ok := m.deliveries[delivery.ID]
+	if !ok {
+		return nil // the webhook has been deleted
+	}
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.NextAttempt = time.UnixMilli(delivery.NextAttempt.UnixMilli())
+	stored.ResponseStatus = delivery.ResponseStatus
+	stored.Error = delivery.Error
+	stored.UpdatedAt = time.UnixMilli(delivery.UpdatedAt.UnixMilli())
+	return nil
+}
+
+// delivery returns a copy of the stored delivery with its webhook, the database must be locked.
+func (m *MemoryIdentityDatabase) delivery(stored *Delivery) Delivery {
+	delivery := *stored
+	delivery.Webhook = m.webhooks[stored.Webhook.ID].webhook
+	return delivery
+}
+
+func (m *MemoryIdentityDatabase) BeginIdempotentRequest(
+	_ context.Context,
+	user int64,
+	key, fingerprint string,
+	since time.Time,
+) (IdempotentRequest, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for k, request := range m.idempotency {
+		if k.owner == user && request.Time.Before(since) {
+			delete(m.idempotency, k)
+		}
+	}
+
+	k := memoryIdempotencyKey{owner: user, key: key}
+	if request, ok := m.idempotency[k]; ok {
+		return copyIdempotentRequest(*request), false, nil
+	}
+	request := IdempotentRequest{
+		Fingerprint: fingerprint,
+		Response:    nil,
+		Time:        time.UnixMilli(time.Now().UnixMilli()),
+	}
+	m.idempotency[k] = &request
+	return request, true, nil
+}
+
+func (m *MemoryIdentityDatabase) CompleteIdempotentRequest(_ context.Context, user int64, key string, response IdempotentResponse) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if request, ok := m.idempotency[memoryIdempotencyKey{owner: user, key: key}]; ok {
+		response.Body = append([]byte(nil), response.Body...)
+		request.Response = &response
+	}
+	return nil
+}
+
+func (m *MemoryIdentityDatabase) CancelIdempotentRequest(_ context.Context, user int64, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.idempotency, memoryIdempotencyKey{owner: user, key: key})
+	return nil
+}
+
+// copyIdempotentRequest returns a copy of the stored request that does not share its response.
+func copyIdempotentRequest(stored IdempotentRequest) IdempotentRequest {
+	if stored.Response != nil {
+		response := *stored.Response
+		response.Body = append([]byte(nil), response.Body...)
+		stored.Response = &response
+	}
+	return stored
+}
+
+// touch advances the revision of the identity, the database must be locked.
+func (m *MemoryIdentityDatabase) touch(owner int64) {
+	if record, ok := m.identities[owner]; ok {
+		record.revision.Number++
+		record.revision.Time = memoryNow()
+	}
+}
+
+// Available returns nil, the memory is always available.
+func (m *MemoryIdentityDatabase) Available() error {
+	return nil
+}
+
+// Check returns nil, the memory is always available.
+func (m *MemoryIdentityDatabase) Check(context.Context) error {
+	return nil
+}
+
+// SetPollConcurrency changes the maximum number of concurrent requests to the accrual system,
+// the next poll makes at most concurrency requests at once.
+func (m *MemoryIdentityDatabase) SetPollConcurrency(concurrency int) {
+	m.pollConcurrency.Store(int64(concurrency))
+}
+
+func (m *MemoryIdentityDatabase) Run(ctx context.Context) error {
+	return pollEvery(m.update, m.PollInterval).Run(ctx)
+}
+
+func (m *MemoryIdentityDatabase) update(ctx context.Context) error {
+	start := time.Now()
+	m.mutex.Lock()
+	ids := make([]string, 0)
+	statuses := make([]OrderStatus, 0)
+	for _, id := range m.orderIDs {
+		if status := m.orders[id].order.Status; !status.IsFinal() {
+			ids = append(ids, id)
+			statuses = append(statuses, status)
+		}
+	}
+	m.mutex.Unlock()
+	defer observePoll(start, statuses)
+
+	return pollAccrual(ctx, m.accrual, int(m.pollConcurrency.Load()), ids, func(ctx context.Context, id string, status OrderStatus, accrual float64) error {
+		m.mutex.Lock()
+		record := m.orders[id]
+		if record.order.Status == status && record.order.Accrual == accrual {
+			m.mutex.Unlock()
+			return nil
+		}
+		record.order.Status = status
+		record.order.Accrual = accrual
+		record.order.Processed = makeProcessed(processedMillis(status, memoryNow()))
+		m.touch(record.owner)
+		order := record.order
+		balance := NewMemoryIdentity(record.owner, m).balance()
+		m.mutex.Unlock()
+
+		publish(ctx, m.publisher, Event{User: record.owner, Order: &order, Balance: &balance})
+		return nil
+	})
+}
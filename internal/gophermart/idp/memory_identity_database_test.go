@@ -0,0 +1,25 @@
+package idp_test
+
+import (
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"github.com/kerelape/gophermart/internal/gophermart/webhook/webhooktest"
+	"testing"
+)
+
+func openMemory(_ *testing.T, accrual accrual.Accrual, publisher idp.Publisher) idptest.Database {
+	return idp.NewMemoryIdentityDatabase(accrual, publisher)
+}
+
+func TestMemoryIdentityDatabase(t *testing.T) {
+	idptest.TestIdentityDatabase(t, openMemory)
+}
+
+func TestMemoryIdentityDatabaseDispatcher(t *testing.T) {
+	webhooktest.TestDispatcher(t, func(_ *testing.T, accrual accrual.Accrual, publisher idp.Publisher) idptest.Database {
+		database := idp.NewMemoryIdentityDatabase(accrual, publisher)
+		database.AllowPrivateWebhooks = true
+		return database
+	})
+}
@@ -2,34 +2,74 @@ package idp
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"github.com/ShiraazMoollatjie/goluhn"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kerelape/gophermart/internal/accrual"
 	"golang.org/x/crypto/bcrypt"
+	"strconv"
 	"time"
 )
 
 type PostgresIdentity struct {
-	username string
-	conn     *pgx.Conn
-	accrual  accrual.Accrual
+	id        int64
+	pool      *pgxpool.Pool
+	accrual   accrual.Accrual
+	publisher Publisher
+
+	// allowPrivateWebhooks lets the webhooks point at the private addresses,
+	// see PostgresIdentityDatabase.AllowPrivateWebhooks.
+	allowPrivateWebhooks bool
+}
+
+// postgresQuerier is implemented by both *pgxpool.Pool and pgx.Tx.
+type postgresQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
 // NewPostgresIdentity creates a new PostgresIdentity.
-func NewPostgresIdentity(username string, conn *pgx.Conn, accrual accrual.Accrual) PostgresIdentity {
+func NewPostgresIdentity(id int64, pool *pgxpool.Pool, accrual accrual.Accrual, publisher Publisher) PostgresIdentity {
 	return PostgresIdentity{
-		username: username,
-		conn:     conn,
-		accrual:  accrual,
+		id:        id,
+		pool:      pool,
+		accrual:   accrual,
+		publisher: publisher,
+	}
+}
+
+func (p PostgresIdentity) ID() int64 {
+	return p.id
+}
+
+func (p PostgresIdentity) Username(ctx context.Context) (string, error) {
+	row := p.pool.QueryRow(ctx, `SELECT username FROM identities WHERE id = $1`, p.id)
+	var username string
+	if err := row.Scan(&username); err != nil {
+		return "", err
 	}
+	return username, nil
+}
+
+func (p PostgresIdentity) SetUsername(ctx context.Context, username string) error {
+	_, updateError := p.pool.Exec(ctx, `UPDATE identities SET username = $1 WHERE id = $2`, username, p.id)
+	if err := new(pgconn.PgError); errors.As(updateError, &err) {
+		if err.Code == "23505" { // unique violation error
+			return ErrDuplicateUsername
+		}
+	}
+	return updateError
 }
 
 func (p PostgresIdentity) AddOrder(ctx context.Context, id string) error {
 	order := Order{
 		ID:      id,
-		Time:    time.Now(),
+		Time:    time.UnixMilli(time.Now().UnixMilli()),
 		Accrual: 0.0,
 		Status:  OrderStatusNew,
 	}
@@ -38,119 +78,417 @@ func (p PostgresIdentity) AddOrder(ctx context.Context, id string) error {
 		return ErrOrderInvalid
 	}
 
-	_, insertError := p.conn.Exec(
+	// The revision of the identity is advanced only if the order is inserted.
+	tag, insertError := p.pool.Exec(
 		ctx,
-		`INSERT INTO orders VALUES($1, $2, $3, $4, $5)`,
+		`
+		WITH inserted AS (
+			INSERT INTO orders(id, owner, time, status, accrual) VALUES($1, $2, $3, $4, $5)
+			ON CONFLICT(id) DO NOTHING
+			RETURNING owner, time
+		)
+		UPDATE identities SET revision = revision + 1, modified = inserted.time
+		FROM inserted WHERE identities.id = inserted.owner
+		`,
 		order.ID,
-		p.username,
+		p.id,
 		order.Time.UnixMilli(),
 		string(order.Status),
 		order.Accrual,
 	)
-
 	if insertError != nil {
-		duplicateRow := p.conn.QueryRow(ctx, `SELECT owner FROM orders WHERE id = $1`, id)
-		var owner string
+		return insertError
+	}
+
+	if tag.RowsAffected() == 0 {
+		duplicateRow := p.pool.QueryRow(ctx, `SELECT owner FROM orders WHERE id = $1`, id)
+		var owner int64
 		if err := duplicateRow.Scan(&owner); err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				return insertError
-			}
 			return err
 		}
-		if owner == p.username {
+		if owner == p.id {
 			return ErrOrderDuplicate
 		} else {
 			return ErrOrderUnowned
 		}
 	}
+
+	publish(ctx, p.publisher, Event{User: p.id, Order: &order})
+	return nil
+}
+
+func (p PostgresIdentity) Orders(ctx context.Context, query OrderQuery) ([]Order, error) {
+	return p.orders(ctx, p.pool, query)
+}
+
+func (p PostgresIdentity) Balance(ctx context.Context) (Balance, error) {
+	return p.balance(ctx, p.pool)
+}
+
+func (p PostgresIdentity) Withdraw(ctx context.Context, order string, amount float64) error {
+	if err := goluhn.Validate(order); err != nil {
+		return ErrOrderInvalid
+	}
+	if !ValidWithdrawalSum(amount) {
+		return ErrWithdrawalSumInvalid
+	}
+
+	transaction, transactionError := p.lockBalance(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback(ctx)
+
+	balance, balanceError := p.balance(ctx, transaction)
+	if balanceError != nil {
+		return balanceError
+	}
+	if balance.Current < amount {
+		return ErrBalanceTooLow
+	}
+
+	withdrawal := Withdrawal{
+		Order: order,
+		Sum:   amount,
+		Time:  time.UnixMilli(time.Now().UnixMilli()),
+	}
+	_, execError := transaction.Exec(
+		ctx,
+		`
+		WITH inserted AS (
+			INSERT INTO withdrawals(orderID, sum, time, owner) VALUES($1, $2, $3, $4)
+			RETURNING owner, time
+		)
+		UPDATE identities SET revision = revision + 1, modified = inserted.time
+		FROM inserted WHERE identities.id = inserted.owner
+		`,
+		withdrawal.Order,
+		withdrawal.Sum,
+		withdrawal.Time.UnixMilli(),
+		p.id,
+	)
+	if err := new(pgconn.PgError); errors.As(execError, &err) {
+		if err.Code == "23505" { // unique violation error
+			return ErrWithdrawalDuplicate
+		}
+	}
+	if execError != nil {
+		return execError
+	}
+	if err := transaction.Commit(ctx); err != nil {
+		return err
+	}
+
+	balance.Current -= amount
+	balance.Withdrawn += amount
+	publish(ctx, p.publisher, Event{User: p.id, Withdrawal: &withdrawal, Balance: &balance})
+	return nil
+}
+
+func (p PostgresIdentity) Withdrawals(ctx context.Context, query ListQuery) ([]Withdrawal, error) {
+	return p.withdrawals(ctx, p.pool, query)
+}
+
+// adjustBalance stores the adjustment and publishes the new balance.
+func (p PostgresIdentity) adjustBalance(ctx context.Context, sum float64, reason string) error {
+	transaction, transactionError := p.lockBalance(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback(ctx)
+
+	balance, balanceError := p.balance(ctx, transaction)
+	if balanceError != nil {
+		return balanceError
+	}
+	if balance.Current+sum < 0 {
+		return ErrBalanceTooLow
+	}
+
+	_, execError := transaction.Exec(
+		ctx,
+		`
+		WITH inserted AS (
+			INSERT INTO balance_adjustments(owner, sum, reason, time) VALUES($1, $2, $3, $4)
+			RETURNING owner, time
+		)
+		UPDATE identities SET revision = revision + 1, modified = inserted.time
+		FROM inserted WHERE identities.id = inserted.owner
+		`,
+		p.id,
+		sum,
+		reason,
+		time.Now().UnixMilli(),
+	)
+	if execError != nil {
+		return execError
+	}
+	if err := transaction.Commit(ctx); err != nil {
+		return err
+	}
+
+	balance.Current += sum
+	publish(ctx, p.publisher, Event{User: p.id, Balance: &balance})
 	return nil
 }
 
-func (p PostgresIdentity) Orders(ctx context.Context) ([]Order, error) {
-	result, queryError := p.conn.Query(ctx, `SELECT id,status,time,accrual FROM orders WHERE owner = $1`, p.username)
+func (p PostgresIdentity) adjustments(ctx context.Context) ([]Adjustment, error) {
+	result, queryError := p.pool.Query(
+		ctx,
+		`SELECT sum, reason, time FROM balance_adjustments WHERE owner = $1 ORDER BY id`,
+		p.id,
+	)
 	if queryError != nil {
 		return nil, queryError
 	}
+	defer result.Close()
 
-	orders := make([]Order, 0)
+	adjustments := make([]Adjustment, 0)
 	for result.Next() {
-		if err := result.Err(); err != nil {
+		adjustment := Adjustment{}
+		var adjustmentTime int64
+		if err := result.Scan(&adjustment.Sum, &adjustment.Reason, &adjustmentTime); err != nil {
 			return nil, err
 		}
+		adjustment.Time = time.UnixMilli(adjustmentTime)
+		adjustments = append(adjustments, adjustment)
+	}
+	return adjustments, result.Err()
+}
 
-		order := Order{}
-		var status string
-		var orderTime int64
-		if err := result.Scan(&order.ID, &status, &orderTime, &order.Accrual); err != nil {
-			return nil, err
-		}
-		order.Status = OrderStatus(status)
-		order.Time = time.UnixMilli(orderTime)
-		orders = append(orders, order)
+// lockBalance begins a transaction that holds the lock of the balance of the identity until it ends,
+// so that no other withdrawal or adjustment changes the balance between checking it and spending it.
+func (p PostgresIdentity) lockBalance(ctx context.Context) (pgx.Tx, error) {
+	transaction, transactionError := p.pool.Begin(ctx)
+	if transactionError != nil {
+		return nil, transactionError
 	}
+	if _, err := transaction.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, p.id); err != nil {
+		return nil, errors.Join(err, transaction.Rollback(ctx))
+	}
+	return transaction, nil
+}
 
-	return orders, nil
+func (p PostgresIdentity) Revision(ctx context.Context) (Revision, error) {
+	row := p.pool.QueryRow(ctx, `SELECT revision, modified FROM identities WHERE id = $1`, p.id)
+	var number, modified int64
+	if err := row.Scan(&number, &modified); err != nil {
+		return Revision{}, err
+	}
+	return makeRevision(number, modified), nil
 }
 
-func (p PostgresIdentity) Balance(ctx context.Context) (Balance, error) {
-	orders, ordersError := p.Orders(ctx)
-	if ordersError != nil {
-		return Balance{}, ordersError
+func (p PostgresIdentity) AddWebhook(ctx context.Context, url string) (Webhook, error) {
+	webhook, webhookError := newWebhook(url, time.Now(), p.allowPrivateWebhooks)
+	if webhookError != nil {
+		return Webhook{}, webhookError
 	}
 
-	withdrawals, withdrawalsError := p.Withdrawals(ctx)
-	if withdrawalsError != nil {
-		return Balance{}, withdrawalsError
+	row := p.pool.QueryRow(
+		ctx,
+		`INSERT INTO webhooks(owner, url, secret, time) VALUES($1, $2, $3, $4) RETURNING id`,
+		p.id,
+		webhook.URL,
+		webhook.Secret,
+		webhook.Time.UnixMilli(),
+	)
+	if err := row.Scan(&webhook.ID); err != nil {
+		return Webhook{}, err
 	}
+	return webhook, nil
+}
 
-	balance := Balance{}
-	for _, order := range orders {
-		if order.Status == OrderStatusProcessed {
-			balance.Current += order.Accrual
+func (p PostgresIdentity) Webhooks(ctx context.Context) ([]Webhook, error) {
+	result, queryError := p.pool.Query(
+		ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE owner = $1 ORDER BY id`,
+		p.id,
+	)
+	if queryError != nil {
+		return nil, queryError
+	}
+	defer result.Close()
+
+	webhooks := make([]Webhook, 0)
+	for result.Next() {
+		webhook, err := scanWebhook(result.Scan)
+		if err != nil {
+			return nil, err
 		}
+		webhooks = append(webhooks, webhook)
 	}
-	for _, withdrawal := range withdrawals {
-		balance.Current -= withdrawal.Sum
-		balance.Withdrawn += withdrawal.Sum
+	return webhooks, result.Err()
+}
+
+func (p PostgresIdentity) DeleteWebhook(ctx context.Context, id int64) error {
+	transaction, transactionError := p.pool.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
 	}
+	defer transaction.Rollback(ctx)
 
-	return balance, nil
+	if _, err := transaction.Exec(
+		ctx,
+		`DELETE FROM webhook_deliveries WHERE webhook IN (SELECT id FROM webhooks WHERE id = $1 AND owner = $2)`,
+		id,
+		p.id,
+	); err != nil {
+		return err
+	}
+	result, deleteError := transaction.Exec(ctx, `DELETE FROM webhooks WHERE id = $1 AND owner = $2`, id, p.id)
+	if deleteError != nil {
+		return deleteError
+	}
+	if result.RowsAffected() == 0 {
+		return ErrUnknownWebhook
+	}
+	return transaction.Commit(ctx)
 }
 
-func (p PostgresIdentity) Withdraw(ctx context.Context, order string, amount float64) error {
-checkLock:
-	lock := p.conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, 1)
-	var locked bool
-	if err := lock.Scan(&locked); err != nil {
+func (p PostgresIdentity) Deliveries(ctx context.Context, webhook int64, limit int) ([]Delivery, error) {
+	row := p.pool.QueryRow(ctx, `SELECT COUNT(*) FROM webhooks WHERE id = $1 AND owner = $2`, webhook, p.id)
+	var found int
+	if err := row.Scan(&found); err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, ErrUnknownWebhook
+	}
+
+	statement := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+		JOIN webhooks ON webhooks.id = webhook_deliveries.webhook
+		WHERE webhooks.id = $1 ORDER BY webhook_deliveries.id DESC`
+	args := []any{webhook}
+	if limit > 0 {
+		statement += ` LIMIT $2`
+		args = append(args, limit)
+	}
+	result, queryError := p.pool.Query(ctx, statement, args...)
+	if queryError != nil {
+		return nil, queryError
+	}
+	defer result.Close()
+
+	deliveries := make([]Delivery, 0)
+	for result.Next() {
+		delivery, err := scanDelivery(result.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, result.Err()
+}
+
+func (p PostgresIdentity) Delete(ctx context.Context) error {
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
 		return err
 	}
-	if !locked {
-		time.Sleep(time.Second)
-		goto checkLock
+
+	transaction, transactionError := p.pool.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
 	}
-	defer p.conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, 1)
+	defer transaction.Rollback(ctx)
 
-	balance, balanceError := p.Balance(ctx)
-	if balanceError != nil {
-		return balanceError
+	// Orders and withdrawals reference the identity by its id,
+	// so it is enough to forget the credentials, the webhooks and the idempotency keys.
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`UPDATE identities SET username = $1, password = '', deleted = TRUE WHERE id = $2`, []any{"deleted-" + hex.EncodeToString(suffix), p.id}},
+		{`DELETE FROM webhook_deliveries WHERE webhook IN (SELECT id FROM webhooks WHERE owner = $1)`, []any{p.id}},
+		{`DELETE FROM webhooks WHERE owner = $1`, []any{p.id}},
+		{`DELETE FROM idempotency_keys WHERE owner = $1`, []any{p.id}},
 	}
-	if balance.Current < amount {
-		return ErrBalanceTooLow
+	for _, statement := range statements {
+		if _, err := transaction.Exec(ctx, statement.query, statement.args...); err != nil {
+			return err
+		}
+	}
+	return transaction.Commit(ctx)
+}
+
+func (p PostgresIdentity) ComparePassword(ctx context.Context, password string) (bool, error) {
+	row := p.pool.QueryRow(ctx, `SELECT password FROM identities WHERE id = $1`, p.id)
+
+	var encodedPasswordHash string
+	if err := row.Scan(&encodedPasswordHash); err != nil {
+		return false, err
 	}
 
-	_, execError := p.conn.Exec(
+	passwordHash, decodePasswordHashError := base64.StdEncoding.DecodeString(encodedPasswordHash)
+	if decodePasswordHashError != nil {
+		return false, decodePasswordHashError
+	}
+
+	return bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) == nil, nil
+}
+
+func (p PostgresIdentity) SetPassword(ctx context.Context, password string) error {
+	passwordHash, passwordHashError := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if passwordHashError != nil {
+		return passwordHashError
+	}
+
+	result, updateError := p.pool.Exec(
 		ctx,
-		`INSERT INTO withdrawals VALUES($1, $2, $3, $4)`,
-		order,
-		amount,
-		time.Now().UnixMilli(),
-		p.username,
+		`UPDATE identities SET password = $1, password_generation = password_generation + 1 WHERE id = $2`,
+		base64.StdEncoding.EncodeToString(passwordHash),
+		p.id,
 	)
-	return execError
+	if updateError != nil {
+		return updateError
+	}
+	if result.RowsAffected() == 0 {
+		return ErrUnknownIdentity
+	}
+	return nil
 }
 
-func (p PostgresIdentity) Withdrawals(ctx context.Context) ([]Withdrawal, error) {
-	result, queryError := p.conn.Query(ctx, `SELECT orderID,sum,time FROM withdrawals WHERE owner = $1`, p.username)
+func (p PostgresIdentity) PasswordGeneration(ctx context.Context) (int64, error) {
+	row := p.pool.QueryRow(ctx, `SELECT password_generation FROM identities WHERE id = $1`, p.id)
+	var generation int64
+	if err := row.Scan(&generation); err != nil {
+		return 0, err
+	}
+	return generation, nil
+}
+
+func (p PostgresIdentity) orders(ctx context.Context, querier postgresQuerier, query OrderQuery) ([]Order, error) {
+	statement, args := listQuerySQL("id,status,time,accrual,processed", "orders", "id", p.id, query.ListQuery, query.Statuses, postgresPlaceholder)
+	result, queryError := querier.Query(ctx, statement, args...)
+	if queryError != nil {
+		return nil, queryError
+	}
+	defer result.Close()
+
+	orders := make([]Order, 0)
+	for result.Next() {
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+
+		order := Order{}
+		var status string
+		var orderTime, processed int64
+		if err := result.Scan(&order.ID, &status, &orderTime, &order.Accrual, &processed); err != nil {
+			return nil, err
+		}
+		order.Status = OrderStatus(status)
+		order.Time = time.UnixMilli(orderTime)
+		order.Processed = makeProcessed(processed)
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+func (p PostgresIdentity) withdrawals(ctx context.Context, querier postgresQuerier, query ListQuery) ([]Withdrawal, error) {
+	statement, args := listQuerySQL("orderID,sum,time", "withdrawals", "orderID", p.id, query, nil, postgresPlaceholder)
+	result, queryError := querier.Query(ctx, statement, args...)
 	if queryError != nil {
 		return nil, queryError
 	}
@@ -175,18 +513,39 @@ func (p PostgresIdentity) Withdrawals(ctx context.Context) ([]Withdrawal, error)
 	return withdrawals, nil
 }
 
-func (p PostgresIdentity) ComparePassword(ctx context.Context, password string) (bool, error) {
-	row := p.conn.QueryRow(ctx, `SELECT password FROM identities WHERE username = $1`, p.username)
+func (p PostgresIdentity) balance(ctx context.Context, querier postgresQuerier) (Balance, error) {
+	orders, ordersError := p.orders(ctx, querier, OrderQuery{})
+	if ordersError != nil {
+		return Balance{}, ordersError
+	}
 
-	var encodedPasswordHash string
-	if err := row.Scan(&encodedPasswordHash); err != nil {
-		return false, err
+	withdrawals, withdrawalsError := p.withdrawals(ctx, querier, ListQuery{})
+	if withdrawalsError != nil {
+		return Balance{}, withdrawalsError
 	}
 
-	passwordHash, decodePasswordHashError := base64.StdEncoding.DecodeString(encodedPasswordHash)
-	if decodePasswordHashError != nil {
-		return false, decodePasswordHashError
+	balance := Balance{}
+	for _, order := range orders {
+		if order.Status == OrderStatusProcessed {
+			balance.Current += order.Accrual
+		}
+	}
+	for _, withdrawal := range withdrawals {
+		balance.Current -= withdrawal.Sum
+		balance.Withdrawn += withdrawal.Sum
 	}
 
-	return bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) == nil, nil
+	row := querier.QueryRow(ctx, `SELECT COALESCE(SUM(sum), 0) FROM balance_adjustments WHERE owner = $1`, p.id)
+	var adjusted float64
+	if err := row.Scan(&adjusted); err != nil {
+		return Balance{}, err
+	}
+	balance.Current += adjusted
+
+	return balance, nil
+}
+
+// postgresPlaceholder returns the placeholder of the nth query argument.
+func postgresPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
 }
@@ -6,43 +6,78 @@ import (
 	"errors"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/tracing"
 	"github.com/pior/runnable"
 	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/sync/errgroup"
+	"golang.org/x/exp/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// minReconnectBackoff is the delay before the second attempt to migrate the database,
+	// it doubles after every failed attempt up to maxReconnectBackoff.
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 30 * time.Second
+
+	// pingInterval is how often the database is checked.
+	pingInterval = 5 * time.Second
+
+	// pingTimeout is how long a ping may take before the database is considered unreachable.
+	pingTimeout = 5 * time.Second
+)
+
 type PostgresIdentityDatabase struct {
-	dsn     string
-	accrual accrual.Accrual
+	dsn       string
+	accrual   accrual.Accrual
+	publisher Publisher
+
+	// PollInterval is how often the accrual system is polled for the orders being processed.
+	PollInterval time.Duration
 
-	conn  *pgx.Conn
-	ready *sync.WaitGroup
+	// pollConcurrency is the maximum number of concurrent requests to the accrual system.
+	pollConcurrency atomic.Int64
+
+	// AllowPrivateWebhooks lets the webhooks point at the loopback, private
+	// and link-local addresses, e.g. at the receivers of the tests.
+	AllowPrivateWebhooks bool
+
+	// pool is nil until the migrations have been applied and while the database is unreachable.
+	mu   sync.RWMutex
+	pool *pgxpool.Pool
 }
 
-func NewPostgresIdentityDatabase(dsn string, accrual accrual.Accrual) *PostgresIdentityDatabase {
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	return &PostgresIdentityDatabase{
-		dsn:     dsn,
-		accrual: accrual,
+// NewPostgresIdentityDatabase creates a new PostgresIdentityDatabase,
+// the changes of the orders and the balances are published to publisher unless it is nil.
+func NewPostgresIdentityDatabase(dsn string, accrual accrual.Accrual, publisher Publisher) *PostgresIdentityDatabase {
+	database := &PostgresIdentityDatabase{
+		dsn:       dsn,
+		accrual:   accrual,
+		publisher: publisher,
 
-		conn:  nil,
-		ready: &wg,
+		PollInterval: defaultPollInterval,
+
+		pool: nil,
 	}
+	database.pollConcurrency.Store(defaultPollConcurrency)
+	return database
 }
 
 func (p *PostgresIdentityDatabase) Create(ctx context.Context, username, password string) error {
-	p.ready.Wait()
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return poolError
+	}
 	passwordHash, passwordHashError := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if passwordHashError != nil {
 		return passwordHashError
 	}
 	encodedPasswordHash := base64.StdEncoding.EncodeToString(passwordHash)
 
-	_, insertError := p.conn.Exec(
+	_, insertError := pool.Exec(
 		ctx,
 		`INSERT INTO identities(username, password) VALUES($1, $2)`,
 		username,
@@ -56,86 +91,393 @@ func (p *PostgresIdentityDatabase) Create(ctx context.Context, username, passwor
 	return insertError
 }
 
-func (p *PostgresIdentityDatabase) Identity(username string) Identity {
-	p.ready.Wait()
-	return NewPostgresIdentity(username, p.conn, p.accrual)
+func (p *PostgresIdentityDatabase) Find(ctx context.Context, username string) (Identity, error) {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return nil, poolError
+	}
+	row := pool.QueryRow(ctx, `SELECT id FROM identities WHERE username = $1 AND NOT deleted`, username)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUnknownIdentity
+		}
+		return nil, err
+	}
+	return p.identity(id, pool), nil
 }
 
-func (p *PostgresIdentityDatabase) Run(ctx context.Context) error {
-	manager := runnable.NewManager()
-	manager.Add(runnable.Func(p.connect))
-	manager.Add(runnable.Every(runnable.Func(p.update), time.Second))
-	return manager.Build().Run(ctx)
+func (p *PostgresIdentityDatabase) Identity(ctx context.Context, id int64) (Identity, error) {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return nil, poolError
+	}
+	row := pool.QueryRow(ctx, `SELECT COUNT(*) FROM identities WHERE id = $1 AND NOT deleted`, id)
+	var found int
+	if err := row.Scan(&found); err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, ErrUnknownIdentity
+	}
+	return p.identity(id, pool), nil
 }
 
-func (p *PostgresIdentityDatabase) connect(ctx context.Context) error {
-	if p.conn != nil {
-		return errors.New("connection is already initialized")
+func (p *PostgresIdentityDatabase) AdjustBalance(ctx context.Context, user int64, sum float64, reason string) error {
+	if _, err := p.Identity(ctx, user); err != nil {
+		return err
 	}
-
-	conn, connectError := pgx.Connect(ctx, p.dsn)
-	if connectError != nil {
-		return connectError
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return poolError
 	}
+	return p.identity(user, pool).adjustBalance(ctx, sum, reason)
+}
 
-	transaction, transactionError := conn.Begin(ctx)
-	if transactionError != nil {
-		return transactionError
+func (p *PostgresIdentityDatabase) Adjustments(ctx context.Context, user int64) ([]Adjustment, error) {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return nil, poolError
 	}
+	return p.identity(user, pool).adjustments(ctx)
+}
 
-	queries := []string{
-		// Create identities table.
-		`
-		CREATE TABLE IF NOT EXISTS identities(
-			username TEXT PRIMARY KEY UNIQUE,
-			password TEXT
-		)
-		`,
-		// Create orders table.
-		`
-		CREATE TABLE IF NOT EXISTS orders(
-			id TEXT PRIMARY KEY UNIQUE,
-		    owner TEXT,
-		    time BIGINT,
-		    status TEXT,
-		    accrual DECIMAL
-		)
-		`,
-		// Create withdrawals table.
+func (p *PostgresIdentityDatabase) RecheckOrder(ctx context.Context, id string) error {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return poolError
+	}
+	now := time.Now().UnixMilli()
+	row := pool.QueryRow(
+		ctx,
 		`
-		CREATE TABLE IF NOT EXISTS withdrawals(
-		    orderID TEXT UNIQUE PRIMARY KEY,
-			sum DECIMAL,
-			time BIGINT,
-		    owner TEXT
+		WITH updated AS (
+			UPDATE orders SET status = $1, accrual = 0, processed = 0 WHERE id = $2 AND status <> $3
+			RETURNING owner, time
 		)
+		UPDATE identities SET revision = revision + 1, modified = $4
+		FROM updated WHERE identities.id = updated.owner
+		RETURNING updated.owner, updated.time
 		`,
-	}
-
-	for _, query := range queries {
-		if _, err := transaction.Exec(ctx, query); err != nil {
-			if err := transaction.Rollback(ctx); err != nil {
-				return err
+		string(OrderStatusNew),
+		id,
+		string(OrderStatusProcessed),
+		now,
+	)
+	var owner, orderTime int64
+	if err := row.Scan(&owner, &orderTime); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+		var status string
+		if err := pool.QueryRow(ctx, `SELECT status FROM orders WHERE id = $1`, id).Scan(&status); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrUnknownOrder
 			}
 			return err
 		}
+		return ErrOrderProcessed
+	}
+
+	order := Order{ID: id, Status: OrderStatusNew, Accrual: 0, Time: time.UnixMilli(orderTime)}
+	publish(ctx, p.publisher, Event{User: owner, Order: &order})
+	return nil
+}
+
+func (p *PostgresIdentityDatabase) EnqueueDeliveries(ctx context.Context, user int64, event string, payload []byte) error {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return poolError
+	}
+	_, insertError := pool.Exec(
+		ctx,
+		`INSERT INTO webhook_deliveries(webhook, event, payload, status, attempts, next_attempt, response_status, error, time, updated)
+		SELECT id, $1::TEXT, $2::BYTEA, $3::TEXT, 0, $4::BIGINT, 0, '', $4::BIGINT, $4::BIGINT FROM webhooks WHERE owner = $5`,
+		event,
+		payload,
+		string(DeliveryStatusPending),
+		time.Now().UnixMilli(),
+		user,
+	)
+	return insertError
+}
+
+func (p *PostgresIdentityDatabase) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return nil, poolError
+	}
+	result, queryError := pool.Query(
+		ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries
+		JOIN webhooks ON webhooks.id = webhook_deliveries.webhook
+		WHERE webhook_deliveries.status = $1 AND webhook_deliveries.next_attempt <= $2
+		ORDER BY webhook_deliveries.next_attempt LIMIT $3`,
+		string(DeliveryStatusPending),
+		now.UnixMilli(),
+		limit,
+	)
+	if queryError != nil {
+		return nil, queryError
 	}
+	defer result.Close()
 
-	if err := transaction.Commit(ctx); err != nil {
-		return transaction.Rollback(ctx)
+	deliveries := make([]Delivery, 0)
+	for result.Next() {
+		delivery, err := scanDelivery(result.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
 	}
+	return deliveries, result.Err()
+}
 
-	p.conn = conn
-	p.ready.Done()
-	<-ctx.Done()
-	return p.conn.Close(context.Background())
+func (p *PostgresIdentityDatabase) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return poolError
+	}
+	_, updateError := pool.Exec(
+		ctx,
+		`UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt = $3, response_status = $4, error = $5, updated = $6
+		WHERE id = $7`,
+		string(delivery.Status),
+		delivery.Attempts,
+		delivery.NextAttempt.UnixMilli(),
+		delivery.ResponseStatus,
+		delivery.Error,
+		delivery.UpdatedAt.UnixMilli(),
+		delivery.ID,
+	)
+	return updateError
+}
+
+func (p *PostgresIdentityDatabase) BeginIdempotentRequest(
+	ctx context.Context,
+	user int64,
+	key, fingerprint string,
+	since time.Time,
+) (IdempotentRequest, bool, error) {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return IdempotentRequest{}, false, poolError
+	}
+	transaction, transactionError := pool.Begin(ctx)
+	if transactionError != nil {
+		return IdempotentRequest{}, false, transactionError
+	}
+	defer transaction.Rollback(ctx)
+
+	if _, err := transaction.Exec(
+		ctx,
+		`DELETE FROM idempotency_keys WHERE owner = $1 AND time < $2`,
+		user,
+		since.UnixMilli(),
+	); err != nil {
+		return IdempotentRequest{}, false, err
+	}
+
+	now := time.Now().UnixMilli()
+	inserted, insertError := transaction.Exec(
+		ctx,
+		`INSERT INTO idempotency_keys(owner, key, `+idempotencyColumns+`) VALUES($1, $2, $3, 0, '', $4, $5)
+		ON CONFLICT(owner, key) DO NOTHING`,
+		user,
+		key,
+		fingerprint,
+		[]byte{},
+		now,
+	)
+	if insertError != nil {
+		return IdempotentRequest{}, false, insertError
+	}
+	if inserted.RowsAffected() > 0 {
+		if err := transaction.Commit(ctx); err != nil {
+			return IdempotentRequest{}, false, err
+		}
+		return IdempotentRequest{Fingerprint: fingerprint, Time: time.UnixMilli(now)}, true, nil
+	}
+
+	row := transaction.QueryRow(
+		ctx,
+		`SELECT `+idempotencyColumns+` FROM idempotency_keys WHERE owner = $1 AND key = $2`,
+		user,
+		key,
+	)
+	request, scanError := scanIdempotentRequest(row.Scan)
+	if scanError != nil {
+		return IdempotentRequest{}, false, scanError
+	}
+	return request, false, transaction.Commit(ctx)
+}
+
+func (p *PostgresIdentityDatabase) CompleteIdempotentRequest(ctx context.Context, user int64, key string, response IdempotentResponse) error {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return poolError
+	}
+	_, updateError := pool.Exec(
+		ctx,
+		`UPDATE idempotency_keys SET status = $1, content_type = $2, body = $3 WHERE owner = $4 AND key = $5`,
+		response.Status,
+		response.ContentType,
+		append([]byte{}, response.Body...),
+		user,
+		key,
+	)
+	return updateError
+}
+
+func (p *PostgresIdentityDatabase) CancelIdempotentRequest(ctx context.Context, user int64, key string) error {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return poolError
+	}
+	_, deleteError := pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE owner = $1 AND key = $2`, user, key)
+	return deleteError
+}
+
+// Available returns ErrUnavailable while the database is disconnected.
+func (p *PostgresIdentityDatabase) Available() error {
+	_, err := p.connection()
+	return err
+}
+
+// Check pings the database and checks that all of the migrations have been applied.
+func (p *PostgresIdentityDatabase) Check(ctx context.Context) error {
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return poolError
+	}
+	if err := pool.Ping(ctx); err != nil {
+		return err
+	}
+	return checkPostgresMigrations(ctx, pool)
+}
+
+// SetPollConcurrency changes the maximum number of concurrent requests to the accrual system,
+// the next poll makes at most concurrency requests at once.
+func (p *PostgresIdentityDatabase) SetPollConcurrency(concurrency int) {
+	p.pollConcurrency.Store(int64(concurrency))
+}
+
+// identity returns the identity of the id, which is known to exist.
+func (p *PostgresIdentityDatabase) identity(id int64, pool *pgxpool.Pool) PostgresIdentity {
+	identity := NewPostgresIdentity(id, pool, p.accrual, p.publisher)
+	identity.allowPrivateWebhooks = p.AllowPrivateWebhooks
+	return identity
+}
+
+func (p *PostgresIdentityDatabase) Run(ctx context.Context) error {
+	manager := runnable.NewManager()
+	manager.Add(runnable.Func(p.connect))
+	manager.Add(pollEvery(p.update, p.PollInterval))
+	return manager.Build().Run(ctx)
+}
+
+// connection returns the pool of connections to the database,
+// or ErrUnavailable if the database is unreachable.
+func (p *PostgresIdentityDatabase) connection() (*pgxpool.Pool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.pool == nil {
+		return nil, ErrUnavailable
+	}
+	return p.pool, nil
+}
+
+// connect opens the pool of connections, applies the migrations and watches
+// the database until the context is done.
+//
+// The pool connects on demand and replaces the broken connections by itself,
+// a failed attempt to migrate is retried after a backoff that doubles up to
+// maxReconnectBackoff.
+func (p *PostgresIdentityDatabase) connect(ctx context.Context) error {
+	config, parseConfigError := pgxpool.ParseConfig(p.dsn)
+	if parseConfigError != nil {
+		return parseConfigError
+	}
+	config.ConnConfig.Tracer = tracing.QueryTracer{}
+	pool, poolError := pgxpool.NewWithConfig(ctx, config)
+	if poolError != nil {
+		return poolError
+	}
+	defer pool.Close()
+
+	backoff := minReconnectBackoff
+	for {
+		migrateError := migratePostgres(ctx, pool)
+		if migrateError == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Warn("failed to connect to the database", "error", migrateError, "retry_in", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+
+	p.setPool(pool)
+	defer p.setPool(nil)
+	slog.Info("connected to the database")
+	p.watch(ctx, pool)
+	return nil
+}
+
+// watch pings the database every pingInterval until the context is done,
+// the database is unavailable while the pings fail.
+func (p *PostgresIdentityDatabase) watch(ctx context.Context, pool *pgxpool.Pool) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	reachable := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		pingError := pool.Ping(pingCtx)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case pingError != nil && reachable:
+			slog.Warn("lost the connection to the database", "error", pingError)
+			p.setPool(nil)
+		case pingError == nil && !reachable:
+			slog.Info("connected to the database again")
+			p.setPool(pool)
+		}
+		reachable = pingError == nil
+	}
+}
+
+func (p *PostgresIdentityDatabase) setPool(pool *pgxpool.Pool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pool = pool
 }
 
 func (p *PostgresIdentityDatabase) update(ctx context.Context) error {
-	p.ready.Wait()
-	rows, queryOrdersError := p.conn.Query(
+	pool, poolError := p.connection()
+	if poolError != nil {
+		return nil // paused until the database is connected again
+	}
+	start := time.Now()
+	rows, queryOrdersError := pool.Query(
 		ctx,
-		`SELECT id FROM orders WHERE status = $1 OR status = $2`,
+		`SELECT id, status FROM orders WHERE status = $1 OR status = $2`,
 		string(OrderStatusNew), string(OrderStatusProcessing),
 	)
 	if queryOrdersError != nil {
@@ -143,54 +485,54 @@ func (p *PostgresIdentityDatabase) update(ctx context.Context) error {
 	}
 
 	ids := make([]string, 0)
+	statuses := make([]OrderStatus, 0)
 	for rows.Next() {
 		if err := rows.Err(); err != nil {
 			return err
 		}
-		var id string
-		if err := rows.Scan(&id); err != nil {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
 			return err
 		}
 		ids = append(ids, id)
+		statuses = append(statuses, OrderStatus(status))
 	}
+	defer observePoll(start, statuses)
 
-	eg, egctx := errgroup.WithContext(ctx)
-	eg.SetLimit(len(ids))
-	for _, id := range ids {
-		eg.Go(func(ctx context.Context, id string) func() error {
-			return func() error {
-				orderInfo, orderInfoError := p.accrual.OrderInfo(ctx, id)
-				var status OrderStatus
-				if orderInfoError != nil {
-					if errors.Is(orderInfoError, accrual.ErrUnknownOrder) {
-						status = OrderStatusInvalid
-					} else {
-						return orderInfoError
-					}
-				} else {
-					status = MakeOrderStatus(orderInfo.Status)
-				}
-
-				_, err := p.conn.Exec(
-					ctx,
-					`UPDATE orders SET status = $1, accrual = $2 WHERE id = $3`,
-					string(status),
-					orderInfo.Accrual,
-					orderInfo.Order,
-				)
-				return err
+	return pollAccrual(ctx, p.accrual, int(p.pollConcurrency.Load()), ids, func(ctx context.Context, id string, status OrderStatus, accrual float64) error {
+		// The revision of the owner is advanced only if the order has changed.
+		now := time.Now()
+		row := pool.QueryRow(
+			ctx,
+			`
+			WITH updated AS (
+				UPDATE orders SET status = $1, accrual = $2, processed = $5 WHERE id = $3 AND (status <> $1 OR accrual <> $2)
+				RETURNING owner, time, processed
+			)
+			UPDATE identities SET revision = revision + 1, modified = $4
+			FROM updated WHERE identities.id = updated.owner
+			RETURNING updated.owner, updated.time, updated.processed
+			`,
+			string(status),
+			accrual,
+			id,
+			now.UnixMilli(),
+			processedMillis(status, now),
+		)
+		var owner, orderTime, processed int64
+		if err := row.Scan(&owner, &orderTime, &processed); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil // the order has not changed
 			}
-		}(egctx, id))
-	}
-
-	if err := eg.Wait(); err != nil {
-		tooManyRequestsError := new(accrual.TooManyRequestsError)
-		if errors.As(err, &tooManyRequestsError) {
-			time.Sleep(tooManyRequestsError.RetryAfter)
-			return nil
+			return err
 		}
-		return err
-	}
 
-	return nil
+		balance, balanceError := NewPostgresIdentity(owner, pool, p.accrual, p.publisher).Balance(ctx)
+		if balanceError != nil {
+			return balanceError
+		}
+		order := Order{ID: id, Status: status, Accrual: accrual, Time: time.UnixMilli(orderTime), Processed: makeProcessed(processed)}
+		publish(ctx, p.publisher, Event{User: owner, Order: &order, Balance: &balance})
+		return nil
+	})
 }
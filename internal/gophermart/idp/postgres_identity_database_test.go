@@ -0,0 +1,36 @@
+package idp_test
+
+import (
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"github.com/kerelape/gophermart/internal/gophermart/webhook/webhooktest"
+	"os"
+	"testing"
+)
+
+// envTestDatabase is the environment variable of the dsn of the PostgreSQL database
+// the tests run against, they are skipped if it is not set.
+const envTestDatabase = "GOPHERMART_TEST_DATABASE_URI"
+
+// openPostgres returns the Open of the test database, or skips the test;
+// the databases allow the private webhooks if allowPrivateWebhooks is set.
+func openPostgres(t *testing.T, allowPrivateWebhooks bool) idptest.Open {
+	dsn := os.Getenv(envTestDatabase)
+	if dsn == "" {
+		t.Skip(envTestDatabase + " is not set")
+	}
+	return func(_ *testing.T, accrual accrual.Accrual, publisher idp.Publisher) idptest.Database {
+		database := idp.NewPostgresIdentityDatabase(dsn, accrual, publisher)
+		database.AllowPrivateWebhooks = allowPrivateWebhooks
+		return database
+	}
+}
+
+func TestPostgresIdentityDatabase(t *testing.T) {
+	idptest.TestIdentityDatabase(t, openPostgres(t, false))
+}
+
+func TestPostgresIdentityDatabaseDispatcher(t *testing.T) {
+	webhooktest.TestDispatcher(t, openPostgres(t, true))
+}
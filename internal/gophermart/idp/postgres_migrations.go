@@ -0,0 +1,183 @@
+package idp
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresMigrations are the schema changes applied by PostgresIdentityDatabase,
+// the version of a migration is its index plus one.
+var postgresMigrations = [][]string{
+	// Initial schema.
+	{
+		`
+		CREATE TABLE IF NOT EXISTS identities(
+			username TEXT PRIMARY KEY UNIQUE,
+			password TEXT
+		)
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS orders(
+			id TEXT PRIMARY KEY UNIQUE,
+		    owner TEXT,
+		    time BIGINT,
+		    status TEXT,
+		    accrual DECIMAL
+		)
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS withdrawals(
+		    orderID TEXT UNIQUE PRIMARY KEY,
+			sum DECIMAL,
+			time BIGINT,
+		    owner TEXT
+		)
+		`,
+	},
+	// Surrogate user ids instead of usernames as owners.
+	{
+		`ALTER TABLE identities ADD COLUMN id BIGSERIAL UNIQUE`,
+		`ALTER TABLE orders ADD COLUMN owner_id BIGINT`,
+		`UPDATE orders SET owner_id = identities.id FROM identities WHERE orders.owner = identities.username`,
+		`ALTER TABLE orders DROP COLUMN owner`,
+		`ALTER TABLE orders RENAME COLUMN owner_id TO owner`,
+		`CREATE INDEX orders_owner ON orders(owner)`,
+		`ALTER TABLE withdrawals ADD COLUMN owner_id BIGINT`,
+		`UPDATE withdrawals SET owner_id = identities.id FROM identities WHERE withdrawals.owner = identities.username`,
+		`ALTER TABLE withdrawals DROP COLUMN owner`,
+		`ALTER TABLE withdrawals RENAME COLUMN owner_id TO owner`,
+		`CREATE INDEX withdrawals_owner ON withdrawals(owner)`,
+	},
+	// Webhooks.
+	{
+		`
+		CREATE TABLE webhooks(
+			id BIGSERIAL PRIMARY KEY,
+			owner BIGINT NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			time BIGINT NOT NULL
+		)
+		`,
+		`CREATE INDEX webhooks_owner ON webhooks(owner)`,
+		`
+		CREATE TABLE webhook_deliveries(
+			id BIGSERIAL PRIMARY KEY,
+			webhook BIGINT NOT NULL,
+			event TEXT NOT NULL,
+			payload BYTEA NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			next_attempt BIGINT NOT NULL,
+			response_status INTEGER NOT NULL,
+			error TEXT NOT NULL,
+			time BIGINT NOT NULL,
+			updated BIGINT NOT NULL
+		)
+		`,
+		`CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries(webhook)`,
+		`CREATE INDEX webhook_deliveries_due ON webhook_deliveries(status, next_attempt)`,
+	},
+	// Idempotency keys.
+	{
+		`
+		CREATE TABLE idempotency_keys(
+			owner BIGINT NOT NULL,
+			key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			status INTEGER NOT NULL,
+			content_type TEXT NOT NULL,
+			body BYTEA NOT NULL,
+			time BIGINT NOT NULL,
+			PRIMARY KEY(owner, key)
+		)
+		`,
+	},
+	// Revisions of the identities.
+	{
+		`ALTER TABLE identities ADD COLUMN revision BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE identities ADD COLUMN modified BIGINT NOT NULL DEFAULT 0`,
+	},
+	// Adjustments of the balances.
+	{
+		`
+		CREATE TABLE balance_adjustments(
+			id BIGSERIAL PRIMARY KEY,
+			owner BIGINT NOT NULL,
+			sum DECIMAL NOT NULL,
+			reason TEXT NOT NULL,
+			time BIGINT NOT NULL
+		)
+		`,
+		`CREATE INDEX balance_adjustments_owner ON balance_adjustments(owner)`,
+	},
+	// Deleted identities.
+	{
+		`ALTER TABLE identities ADD COLUMN deleted BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+	// Processing times of the orders, unknown for the orders processed before, so their upload times.
+	{
+		`ALTER TABLE orders ADD COLUMN processed BIGINT NOT NULL DEFAULT 0`,
+		`UPDATE orders SET processed = time WHERE status IN ('PROCESSED', 'INVALID')`,
+	},
+	// Owners of the orders and the withdrawals, the migration fails if some of them
+	// were left without an owner by the surrogate ids.
+	{
+		`ALTER TABLE orders ALTER COLUMN owner SET NOT NULL`,
+		`ALTER TABLE orders ADD CONSTRAINT orders_owner_fkey FOREIGN KEY(owner) REFERENCES identities(id)`,
+		`ALTER TABLE withdrawals ALTER COLUMN owner SET NOT NULL`,
+		`ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_owner_fkey FOREIGN KEY(owner) REFERENCES identities(id)`,
+	},
+	// Generations of the passwords, which revoke the tokens issued before a change.
+	{
+		`ALTER TABLE identities ADD COLUMN password_generation BIGINT NOT NULL DEFAULT 0`,
+	},
+}
+
+// migratePostgres applies the migrations that have not been applied yet.
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	transaction, transactionError := pool.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback(ctx)
+
+	if _, err := transaction.Exec(ctx, `CREATE TABLE IF NOT EXISTS migrations(version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+	// Prevent concurrently starting instances from applying the same migrations.
+	if _, err := transaction.Exec(ctx, `LOCK TABLE migrations IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := transaction.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM migrations`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(postgresMigrations); version++ {
+		for _, query := range postgresMigrations[version] {
+			if _, err := transaction.Exec(ctx, query); err != nil {
+				return err
+			}
+		}
+		if _, err := transaction.Exec(ctx, `INSERT INTO migrations(version) VALUES($1)`, version+1); err != nil {
+			return err
+		}
+	}
+
+	return transaction.Commit(ctx)
+}
+
+// checkPostgresMigrations returns an error if some of the migrations have not been applied.
+func checkPostgresMigrations(ctx context.Context, pool *pgxpool.Pool) error {
+	var version int
+	if err := pool.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version < len(postgresMigrations) {
+		return fmt.Errorf("the schema is at version %d, want %d", version, len(postgresMigrations))
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package idp
+
+import "time"
+
+// Revision identifies the state of the orders, the withdrawals and the balance of a user.
+type Revision struct {
+	// Number is increased every time the orders, the withdrawals or the balance change.
+	Number int64
+
+	// Time is when they last changed, it is zero if they have never changed.
+	Time time.Time
+}
+
+// makeRevision makes a Revision of the number and the time stored in a database.
+func makeRevision(number, modified int64) Revision {
+	revision := Revision{Number: number}
+	if modified != 0 {
+		revision.Time = time.UnixMilli(modified)
+	}
+	return revision
+}
@@ -0,0 +1,552 @@
+package idp
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/ShiraazMoollatjie/goluhn"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+type SQLiteIdentity struct {
+	id        int64
+	db        *sql.DB
+	publisher Publisher
+
+	// allowPrivateWebhooks lets the webhooks point at the private addresses,
+	// see SQLiteIdentityDatabase.AllowPrivateWebhooks.
+	allowPrivateWebhooks bool
+}
+
+// sqliteQuerier is implemented by both *sql.DB and *sql.Tx.
+type sqliteQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// sqliteExecer is implemented by both *sql.DB and *sql.Tx.
+type sqliteExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// NewSQLiteIdentity creates a new SQLiteIdentity.
+func NewSQLiteIdentity(id int64, db *sql.DB, publisher Publisher) SQLiteIdentity {
+	return SQLiteIdentity{
+		id:        id,
+		db:        db,
+		publisher: publisher,
+	}
+}
+
+func (s SQLiteIdentity) ID() int64 {
+	return s.id
+}
+
+func (s SQLiteIdentity) Username(ctx context.Context) (string, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT username FROM identities WHERE id = ?`, s.id)
+	var username string
+	if err := row.Scan(&username); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+func (s SQLiteIdentity) SetUsername(ctx context.Context, username string) error {
+	_, updateError := s.db.ExecContext(ctx, `UPDATE identities SET username = ? WHERE id = ?`, username, s.id)
+	if isSQLiteConstraintViolation(updateError) {
+		return ErrDuplicateUsername
+	}
+	return updateError
+}
+
+func (s SQLiteIdentity) AddOrder(ctx context.Context, id string) error {
+	order := Order{
+		ID:      id,
+		Time:    time.UnixMilli(time.Now().UnixMilli()),
+		Accrual: 0.0,
+		Status:  OrderStatusNew,
+	}
+
+	if err := goluhn.Validate(id); err != nil {
+		return ErrOrderInvalid
+	}
+
+	transaction, transactionError := s.db.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback()
+
+	_, insertError := transaction.ExecContext(
+		ctx,
+		`INSERT INTO orders(id, owner, time, status, accrual) VALUES(?, ?, ?, ?, ?)`,
+		order.ID,
+		s.id,
+		order.Time.UnixMilli(),
+		string(order.Status),
+		order.Accrual,
+	)
+
+	if insertError != nil {
+		duplicateRow := transaction.QueryRowContext(ctx, `SELECT owner FROM orders WHERE id = ?`, id)
+		var owner int64
+		if err := duplicateRow.Scan(&owner); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return insertError
+			}
+			return err
+		}
+		if owner == s.id {
+			return ErrOrderDuplicate
+		}
+		return ErrOrderUnowned
+	}
+	if err := touchSQLite(ctx, transaction, s.id, order.Time); err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+
+	publish(ctx, s.publisher, Event{User: s.id, Order: &order})
+	return nil
+}
+
+func (s SQLiteIdentity) Orders(ctx context.Context, query OrderQuery) ([]Order, error) {
+	return s.orders(ctx, s.db, query)
+}
+
+func (s SQLiteIdentity) Balance(ctx context.Context) (Balance, error) {
+	return s.balance(ctx, s.db)
+}
+
+func (s SQLiteIdentity) Withdraw(ctx context.Context, order string, amount float64) error {
+	if err := goluhn.Validate(order); err != nil {
+		return ErrOrderInvalid
+	}
+	if !ValidWithdrawalSum(amount) {
+		return ErrWithdrawalSumInvalid
+	}
+
+	// The transactions are started with BEGIN IMMEDIATE, so no other
+	// withdrawal can change the balance between the check and the insert.
+	transaction, transactionError := s.db.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback()
+
+	balance, balanceError := s.balance(ctx, transaction)
+	if balanceError != nil {
+		return balanceError
+	}
+	if balance.Current < amount {
+		return ErrBalanceTooLow
+	}
+
+	withdrawal := Withdrawal{
+		Order: order,
+		Sum:   amount,
+		Time:  time.UnixMilli(time.Now().UnixMilli()),
+	}
+	_, execError := transaction.ExecContext(
+		ctx,
+		`INSERT INTO withdrawals(orderID, sum, time, owner) VALUES(?, ?, ?, ?)`,
+		withdrawal.Order,
+		withdrawal.Sum,
+		withdrawal.Time.UnixMilli(),
+		s.id,
+	)
+	if isSQLiteConstraintViolation(execError) {
+		return ErrWithdrawalDuplicate
+	}
+	if execError != nil {
+		return execError
+	}
+	if err := touchSQLite(ctx, transaction, s.id, withdrawal.Time); err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+
+	balance.Current -= amount
+	balance.Withdrawn += amount
+	publish(ctx, s.publisher, Event{User: s.id, Withdrawal: &withdrawal, Balance: &balance})
+	return nil
+}
+
+func (s SQLiteIdentity) Withdrawals(ctx context.Context, query ListQuery) ([]Withdrawal, error) {
+	return s.withdrawals(ctx, s.db, query)
+}
+
+// adjustBalance stores the adjustment and publishes the new balance.
+func (s SQLiteIdentity) adjustBalance(ctx context.Context, sum float64, reason string) error {
+	// The transactions are started with BEGIN IMMEDIATE, so no withdrawal
+	// can change the balance between the check and the insert.
+	transaction, transactionError := s.db.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback()
+
+	balance, balanceError := s.balance(ctx, transaction)
+	if balanceError != nil {
+		return balanceError
+	}
+	if balance.Current+sum < 0 {
+		return ErrBalanceTooLow
+	}
+
+	now := time.Now()
+	if _, err := transaction.ExecContext(
+		ctx,
+		`INSERT INTO balance_adjustments(owner, sum, reason, time) VALUES(?, ?, ?, ?)`,
+		s.id,
+		sum,
+		reason,
+		now.UnixMilli(),
+	); err != nil {
+		return err
+	}
+	if err := touchSQLite(ctx, transaction, s.id, now); err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+
+	balance.Current += sum
+	publish(ctx, s.publisher, Event{User: s.id, Balance: &balance})
+	return nil
+}
+
+func (s SQLiteIdentity) adjustments(ctx context.Context) ([]Adjustment, error) {
+	result, queryError := s.db.QueryContext(
+		ctx,
+		`SELECT sum, reason, time FROM balance_adjustments WHERE owner = ? ORDER BY id`,
+		s.id,
+	)
+	if queryError != nil {
+		return nil, queryError
+	}
+	defer result.Close()
+
+	adjustments := make([]Adjustment, 0)
+	for result.Next() {
+		adjustment := Adjustment{}
+		var adjustmentTime int64
+		if err := result.Scan(&adjustment.Sum, &adjustment.Reason, &adjustmentTime); err != nil {
+			return nil, err
+		}
+		adjustment.Time = time.UnixMilli(adjustmentTime)
+		adjustments = append(adjustments, adjustment)
+	}
+	return adjustments, result.Err()
+}
+
+func (s SQLiteIdentity) Revision(ctx context.Context) (Revision, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT revision, modified FROM identities WHERE id = ?`, s.id)
+	var number, modified int64
+	if err := row.Scan(&number, &modified); err != nil {
+		return Revision{}, err
+	}
+	return makeRevision(number, modified), nil
+}
+
+func (s SQLiteIdentity) AddWebhook(ctx context.Context, url string) (Webhook, error) {
+	webhook, webhookError := newWebhook(url, time.Now(), s.allowPrivateWebhooks)
+	if webhookError != nil {
+		return Webhook{}, webhookError
+	}
+
+	result, insertError := s.db.ExecContext(
+		ctx,
+		`INSERT INTO webhooks(owner, url, secret, time) VALUES(?, ?, ?, ?)`,
+		s.id,
+		webhook.URL,
+		webhook.Secret,
+		webhook.Time.UnixMilli(),
+	)
+	if insertError != nil {
+		return Webhook{}, insertError
+	}
+	id, idError := result.LastInsertId()
+	if idError != nil {
+		return Webhook{}, idError
+	}
+	webhook.ID = id
+	return webhook, nil
+}
+
+func (s SQLiteIdentity) Webhooks(ctx context.Context) ([]Webhook, error) {
+	result, queryError := s.db.QueryContext(
+		ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE owner = ? ORDER BY id`,
+		s.id,
+	)
+	if queryError != nil {
+		return nil, queryError
+	}
+	defer result.Close()
+
+	webhooks := make([]Webhook, 0)
+	for result.Next() {
+		webhook, err := scanWebhook(result.Scan)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, result.Err()
+}
+
+func (s SQLiteIdentity) DeleteWebhook(ctx context.Context, id int64) error {
+	transaction, transactionError := s.db.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback()
+
+	if _, err := transaction.ExecContext(
+		ctx,
+		`DELETE FROM webhook_deliveries WHERE webhook IN (SELECT id FROM webhooks WHERE id = ? AND owner = ?)`,
+		id,
+		s.id,
+	); err != nil {
+		return err
+	}
+	result, deleteError := transaction.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ? AND owner = ?`, id, s.id)
+	if deleteError != nil {
+		return deleteError
+	}
+	deleted, rowsAffectedError := result.RowsAffected()
+	if rowsAffectedError != nil {
+		return rowsAffectedError
+	}
+	if deleted == 0 {
+		return ErrUnknownWebhook
+	}
+	return transaction.Commit()
+}
+
+func (s SQLiteIdentity) Deliveries(ctx context.Context, webhook int64, limit int) ([]Delivery, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM webhooks WHERE id = ? AND owner = ?`, webhook, s.id)
+	var found int
+	if err := row.Scan(&found); err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, ErrUnknownWebhook
+	}
+
+	statement := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+		JOIN webhooks ON webhooks.id = webhook_deliveries.webhook
+		WHERE webhooks.id = ? ORDER BY webhook_deliveries.id DESC`
+	args := []any{webhook}
+	if limit > 0 {
+		statement += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	result, queryError := s.db.QueryContext(ctx, statement, args...)
+	if queryError != nil {
+		return nil, queryError
+	}
+	defer result.Close()
+
+	deliveries := make([]Delivery, 0)
+	for result.Next() {
+		delivery, err := scanDelivery(result.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, result.Err()
+}
+
+func (s SQLiteIdentity) Delete(ctx context.Context) error {
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	transaction, transactionError := s.db.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback()
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`UPDATE identities SET username = ?, password = '', deleted = 1 WHERE id = ?`, []any{"deleted-" + hex.EncodeToString(suffix), s.id}},
+		{`DELETE FROM webhook_deliveries WHERE webhook IN (SELECT id FROM webhooks WHERE owner = ?)`, []any{s.id}},
+		{`DELETE FROM webhooks WHERE owner = ?`, []any{s.id}},
+		{`DELETE FROM idempotency_keys WHERE owner = ?`, []any{s.id}},
+	}
+	for _, statement := range statements {
+		if _, err := transaction.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return err
+		}
+	}
+	return transaction.Commit()
+}
+
+func (s SQLiteIdentity) ComparePassword(ctx context.Context, password string) (bool, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT password FROM identities WHERE id = ?`, s.id)
+
+	var encodedPasswordHash string
+	if err := row.Scan(&encodedPasswordHash); err != nil {
+		return false, err
+	}
+
+	passwordHash, decodePasswordHashError := base64.StdEncoding.DecodeString(encodedPasswordHash)
+	if decodePasswordHashError != nil {
+		return false, decodePasswordHashError
+	}
+
+	return bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) == nil, nil
+}
+
+func (s SQLiteIdentity) SetPassword(ctx context.Context, password string) error {
+	passwordHash, passwordHashError := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if passwordHashError != nil {
+		return passwordHashError
+	}
+
+	result, updateError := s.db.ExecContext(
+		ctx,
+		`UPDATE identities SET password = ?, password_generation = password_generation + 1 WHERE id = ?`,
+		base64.StdEncoding.EncodeToString(passwordHash),
+		s.id,
+	)
+	if updateError != nil {
+		return updateError
+	}
+	updated, rowsAffectedError := result.RowsAffected()
+	if rowsAffectedError != nil {
+		return rowsAffectedError
+	}
+	if updated == 0 {
+		return ErrUnknownIdentity
+	}
+	return nil
+}
+
+func (s SQLiteIdentity) PasswordGeneration(ctx context.Context) (int64, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT password_generation FROM identities WHERE id = ?`, s.id)
+	var generation int64
+	if err := row.Scan(&generation); err != nil {
+		return 0, err
+	}
+	return generation, nil
+}
+
+func (s SQLiteIdentity) orders(ctx context.Context, querier sqliteQuerier, query OrderQuery) ([]Order, error) {
+	statement, args := listQuerySQL("id,status,time,accrual,processed", "orders", "id", s.id, query.ListQuery, query.Statuses, sqlitePlaceholder)
+	result, queryError := querier.QueryContext(ctx, statement, args...)
+	if queryError != nil {
+		return nil, queryError
+	}
+	defer result.Close()
+
+	orders := make([]Order, 0)
+	for result.Next() {
+		order := Order{}
+		var status string
+		var orderTime, processed int64
+		if err := result.Scan(&order.ID, &status, &orderTime, &order.Accrual, &processed); err != nil {
+			return nil, err
+		}
+		order.Status = OrderStatus(status)
+		order.Time = time.UnixMilli(orderTime)
+		order.Processed = makeProcessed(processed)
+		orders = append(orders, order)
+	}
+
+	return orders, result.Err()
+}
+
+func (s SQLiteIdentity) withdrawals(ctx context.Context, querier sqliteQuerier, query ListQuery) ([]Withdrawal, error) {
+	statement, args := listQuerySQL("orderID,sum,time", "withdrawals", "orderID", s.id, query, nil, sqlitePlaceholder)
+	result, queryError := querier.QueryContext(ctx, statement, args...)
+	if queryError != nil {
+		return nil, queryError
+	}
+	defer result.Close()
+
+	withdrawals := make([]Withdrawal, 0)
+	for result.Next() {
+		withdrawal := Withdrawal{}
+		var withdrawalTime int64
+		if err := result.Scan(&withdrawal.Order, &withdrawal.Sum, &withdrawalTime); err != nil {
+			return nil, err
+		}
+		withdrawal.Time = time.UnixMilli(withdrawalTime)
+		withdrawals = append(withdrawals, withdrawal)
+	}
+
+	return withdrawals, result.Err()
+}
+
+func (s SQLiteIdentity) balance(ctx context.Context, querier sqliteQuerier) (Balance, error) {
+	orders, ordersError := s.orders(ctx, querier, OrderQuery{})
+	if ordersError != nil {
+		return Balance{}, ordersError
+	}
+
+	withdrawals, withdrawalsError := s.withdrawals(ctx, querier, ListQuery{})
+	if withdrawalsError != nil {
+		return Balance{}, withdrawalsError
+	}
+
+	balance := Balance{}
+	for _, order := range orders {
+		if order.Status == OrderStatusProcessed {
+			balance.Current += order.Accrual
+		}
+	}
+	for _, withdrawal := range withdrawals {
+		balance.Current -= withdrawal.Sum
+		balance.Withdrawn += withdrawal.Sum
+	}
+
+	adjustments, queryError := querier.QueryContext(ctx, `SELECT COALESCE(SUM(sum), 0) FROM balance_adjustments WHERE owner = ?`, s.id)
+	if queryError != nil {
+		return Balance{}, queryError
+	}
+	defer adjustments.Close()
+	var adjusted float64
+	for adjustments.Next() {
+		if err := adjustments.Scan(&adjusted); err != nil {
+			return Balance{}, err
+		}
+	}
+	if err := adjustments.Err(); err != nil {
+		return Balance{}, err
+	}
+	balance.Current += adjusted
+
+	return balance, nil
+}
+
+// sqlitePlaceholder returns the placeholder of a query argument.
+func sqlitePlaceholder(int) string {
+	return "?"
+}
+
+// touchSQLite advances the revision of the identity.
+func touchSQLite(ctx context.Context, execer sqliteExecer, owner int64, now time.Time) error {
+	_, updateError := execer.ExecContext(
+		ctx,
+		`UPDATE identities SET revision = revision + 1, modified = ? WHERE id = ?`,
+		now.UnixMilli(),
+		owner,
+	)
+	return updateError
+}
@@ -0,0 +1,476 @@
+package idp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/pior/runnable"
+	"golang.org/x/crypto/bcrypt"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// SQLiteIdentityDatabase is an IdentityDatabase stored in an SQLite file.
+type SQLiteIdentityDatabase struct {
+	path      string
+	accrual   accrual.Accrual
+	publisher Publisher
+
+	// PollInterval is how often the accrual system is polled for the orders being processed.
+	PollInterval time.Duration
+
+	// pollConcurrency is the maximum number of concurrent requests to the accrual system.
+	pollConcurrency atomic.Int64
+
+	// AllowPrivateWebhooks lets the webhooks point at the loopback, private
+	// and link-local addresses, e.g. at the receivers of the tests.
+	AllowPrivateWebhooks bool
+
+	db    *sql.DB
+	ready chan struct{}
+}
+
+// NewSQLiteIdentityDatabase creates a new SQLiteIdentityDatabase
+// stored in the file at path, the changes of the orders and the balances
+// are published to publisher unless it is nil.
+func NewSQLiteIdentityDatabase(path string, accrual accrual.Accrual, publisher Publisher) *SQLiteIdentityDatabase {
+	database := &SQLiteIdentityDatabase{
+		path:      path,
+		accrual:   accrual,
+		publisher: publisher,
+
+		PollInterval: defaultPollInterval,
+
+		db:    nil,
+		ready: make(chan struct{}),
+	}
+	database.pollConcurrency.Store(defaultPollConcurrency)
+	return database
+}
+
+func (s *SQLiteIdentityDatabase) Create(ctx context.Context, username, password string) error {
+	if err := s.Available(); err != nil {
+		return err
+	}
+	passwordHash, passwordHashError := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if passwordHashError != nil {
+		return passwordHashError
+	}
+	encodedPasswordHash := base64.StdEncoding.EncodeToString(passwordHash)
+
+	_, insertError := s.db.ExecContext(
+		ctx,
+		`INSERT INTO identities(username, password) VALUES(?, ?)`,
+		username,
+		encodedPasswordHash,
+	)
+	if isSQLiteConstraintViolation(insertError) {
+		return ErrDuplicateUsername
+	}
+	return insertError
+}
+
+func (s *SQLiteIdentityDatabase) Find(ctx context.Context, username string) (Identity, error) {
+	if err := s.Available(); err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRowContext(ctx, `SELECT id FROM identities WHERE username = ? AND NOT deleted`, username)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUnknownIdentity
+		}
+		return nil, err
+	}
+	return s.identity(id), nil
+}
+
+func (s *SQLiteIdentityDatabase) Identity(ctx context.Context, id int64) (Identity, error) {
+	if err := s.Available(); err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM identities WHERE id = ? AND NOT deleted`, id)
+	var found int
+	if err := row.Scan(&found); err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, ErrUnknownIdentity
+	}
+	return s.identity(id), nil
+}
+
+func (s *SQLiteIdentityDatabase) AdjustBalance(ctx context.Context, user int64, sum float64, reason string) error {
+	if _, err := s.Identity(ctx, user); err != nil {
+		return err
+	}
+	return s.identity(user).adjustBalance(ctx, sum, reason)
+}
+
+func (s *SQLiteIdentityDatabase) Adjustments(ctx context.Context, user int64) ([]Adjustment, error) {
+	if err := s.Available(); err != nil {
+		return nil, err
+	}
+	return s.identity(user).adjustments(ctx)
+}
+
+func (s *SQLiteIdentityDatabase) RecheckOrder(ctx context.Context, id string) error {
+	if err := s.Available(); err != nil {
+		return err
+	}
+	transaction, transactionError := s.db.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback()
+
+	var owner, orderTime int64
+	var status string
+	row := transaction.QueryRowContext(ctx, `SELECT owner, time, status FROM orders WHERE id = ?`, id)
+	if err := row.Scan(&owner, &orderTime, &status); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUnknownOrder
+		}
+		return err
+	}
+	if OrderStatus(status) == OrderStatusProcessed {
+		return ErrOrderProcessed
+	}
+	if _, err := transaction.ExecContext(
+		ctx,
+		`UPDATE orders SET status = ?, accrual = 0, processed = 0 WHERE id = ?`,
+		string(OrderStatusNew),
+		id,
+	); err != nil {
+		return err
+	}
+	if err := touchSQLite(ctx, transaction, owner, time.Now()); err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+
+	order := Order{ID: id, Status: OrderStatusNew, Accrual: 0, Time: time.UnixMilli(orderTime)}
+	publish(ctx, s.publisher, Event{User: owner, Order: &order})
+	return nil
+}
+
+func (s *SQLiteIdentityDatabase) EnqueueDeliveries(ctx context.Context, user int64, event string, payload []byte) error {
+	if err := s.Available(); err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	_, insertError := s.db.ExecContext(
+		ctx,
+		`INSERT INTO webhook_deliveries(webhook, event, payload, status, attempts, next_attempt, response_status, error, time, updated)
+		SELECT id, ?, ?, ?, 0, ?, 0, '', ?, ? FROM webhooks WHERE owner = ?`,
+		event,
+		payload,
+		string(DeliveryStatusPending),
+		now,
+		now,
+		now,
+		user,
+	)
+	return insertError
+}
+
+func (s *SQLiteIdentityDatabase) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	if err := s.Available(); err != nil {
+		return nil, err
+	}
+	result, queryError := s.db.QueryContext(
+		ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries
+		JOIN webhooks ON webhooks.id = webhook_deliveries.webhook
+		WHERE webhook_deliveries.status = ? AND webhook_deliveries.next_attempt <= ?
+		ORDER BY webhook_deliveries.next_attempt LIMIT ?`,
+		string(DeliveryStatusPending),
+		now.UnixMilli(),
+		limit,
+	)
+	if queryError != nil {
+		return nil, queryError
+	}
+	defer result.Close()
+
+	deliveries := make([]Delivery, 0)
+	for result.Next() {
+		delivery, err := scanDelivery(result.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, result.Err()
+}
+
+func (s *SQLiteIdentityDatabase) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	if err := s.Available(); err != nil {
+		return err
+	}
+	_, updateError := s.db.ExecContext(
+		ctx,
+		`UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, next_attempt = ?, response_status = ?, error = ?, updated = ?
+		WHERE id = ?`,
+		string(delivery.Status),
+		delivery.Attempts,
+		delivery.NextAttempt.UnixMilli(),
+		delivery.ResponseStatus,
+		delivery.Error,
+		delivery.UpdatedAt.UnixMilli(),
+		delivery.ID,
+	)
+	return updateError
+}
+
+func (s *SQLiteIdentityDatabase) BeginIdempotentRequest(
+	ctx context.Context,
+	user int64,
+	key, fingerprint string,
+	since time.Time,
+) (IdempotentRequest, bool, error) {
+	if err := s.Available(); err != nil {
+		return IdempotentRequest{}, false, err
+	}
+	transaction, transactionError := s.db.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return IdempotentRequest{}, false, transactionError
+	}
+	defer transaction.Rollback()
+
+	if _, err := transaction.ExecContext(
+		ctx,
+		`DELETE FROM idempotency_keys WHERE owner = ? AND time < ?`,
+		user,
+		since.UnixMilli(),
+	); err != nil {
+		return IdempotentRequest{}, false, err
+	}
+
+	now := time.Now().UnixMilli()
+	inserted, insertError := transaction.ExecContext(
+		ctx,
+		`INSERT INTO idempotency_keys(owner, key, `+idempotencyColumns+`) VALUES(?, ?, ?, 0, '', ?, ?)
+		ON CONFLICT(owner, key) DO NOTHING`,
+		user,
+		key,
+		fingerprint,
+		[]byte{},
+		now,
+	)
+	if insertError != nil {
+		return IdempotentRequest{}, false, insertError
+	}
+	affected, affectedError := inserted.RowsAffected()
+	if affectedError != nil {
+		return IdempotentRequest{}, false, affectedError
+	}
+	if affected > 0 {
+		if err := transaction.Commit(); err != nil {
+			return IdempotentRequest{}, false, err
+		}
+		return IdempotentRequest{Fingerprint: fingerprint, Time: time.UnixMilli(now)}, true, nil
+	}
+
+	row := transaction.QueryRowContext(
+		ctx,
+		`SELECT `+idempotencyColumns+` FROM idempotency_keys WHERE owner = ? AND key = ?`,
+		user,
+		key,
+	)
+	request, scanError := scanIdempotentRequest(row.Scan)
+	if scanError != nil {
+		return IdempotentRequest{}, false, scanError
+	}
+	return request, false, transaction.Commit()
+}
+
+func (s *SQLiteIdentityDatabase) CompleteIdempotentRequest(ctx context.Context, user int64, key string, response IdempotentResponse) error {
+	if err := s.Available(); err != nil {
+		return err
+	}
+	_, updateError := s.db.ExecContext(
+		ctx,
+		`UPDATE idempotency_keys SET status = ?, content_type = ?, body = ? WHERE owner = ? AND key = ?`,
+		response.Status,
+		response.ContentType,
+		append([]byte{}, response.Body...),
+		user,
+		key,
+	)
+	return updateError
+}
+
+func (s *SQLiteIdentityDatabase) CancelIdempotentRequest(ctx context.Context, user int64, key string) error {
+	if err := s.Available(); err != nil {
+		return err
+	}
+	_, deleteError := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE owner = ? AND key = ?`, user, key)
+	return deleteError
+}
+
+// Available returns ErrUnavailable until the database has connected and migrated.
+func (s *SQLiteIdentityDatabase) Available() error {
+	select {
+	case <-s.ready:
+		return nil
+	default:
+		return ErrUnavailable
+	}
+}
+
+// Check pings the database and checks that all of the migrations have been applied.
+func (s *SQLiteIdentityDatabase) Check(ctx context.Context) error {
+	if err := s.Available(); err != nil {
+		return err
+	}
+	if err := s.db.PingContext(ctx); err != nil {
+		return err
+	}
+	return checkSQLiteMigrations(ctx, s.db)
+}
+
+// SetPollConcurrency changes the maximum number of concurrent requests to the accrual system,
+// the next poll makes at most concurrency requests at once.
+func (s *SQLiteIdentityDatabase) SetPollConcurrency(concurrency int) {
+	s.pollConcurrency.Store(int64(concurrency))
+}
+
+// identity returns the identity of the id, which is known to exist.
+func (s *SQLiteIdentityDatabase) identity(id int64) SQLiteIdentity {
+	identity := NewSQLiteIdentity(id, s.db, s.publisher)
+	identity.allowPrivateWebhooks = s.AllowPrivateWebhooks
+	return identity
+}
+
+func (s *SQLiteIdentityDatabase) Run(ctx context.Context) error {
+	manager := runnable.NewManager()
+	manager.Add(runnable.Func(s.connect))
+	manager.Add(pollEvery(s.update, s.PollInterval))
+	return manager.Build().Run(ctx)
+}
+
+func (s *SQLiteIdentityDatabase) connect(ctx context.Context) error {
+	if s.db != nil {
+		return errors.New("connection is already initialized")
+	}
+
+	separator := "?"
+	if strings.Contains(s.path, "?") {
+		separator = "&"
+	}
+	db, openError := sql.Open(
+		"sqlite",
+		s.path+separator+"_txlock=immediate&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)",
+	)
+	if openError != nil {
+		return openError
+	}
+	// SQLite allows a single writer, sharing one connection serializes
+	// the transactions of this process instead of failing them with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(ctx, db); err != nil {
+		return errors.Join(err, db.Close())
+	}
+
+	s.db = db
+	close(s.ready)
+	<-ctx.Done()
+	return s.db.Close()
+}
+
+func (s *SQLiteIdentityDatabase) update(ctx context.Context) error {
+	if s.Available() != nil {
+		return nil // polled once connected
+	}
+	start := time.Now()
+	rows, queryOrdersError := s.db.QueryContext(
+		ctx,
+		`SELECT id, status FROM orders WHERE status = ? OR status = ?`,
+		string(OrderStatusNew), string(OrderStatusProcessing),
+	)
+	if queryOrdersError != nil {
+		return queryOrdersError
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	statuses := make([]OrderStatus, 0)
+	for rows.Next() {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		statuses = append(statuses, OrderStatus(status))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	defer observePoll(start, statuses)
+
+	return pollAccrual(ctx, s.accrual, int(s.pollConcurrency.Load()), ids, func(ctx context.Context, id string, status OrderStatus, accrual float64) error {
+		transaction, transactionError := s.db.BeginTx(ctx, nil)
+		if transactionError != nil {
+			return transactionError
+		}
+		defer transaction.Rollback()
+
+		now := time.Now()
+		row := transaction.QueryRowContext(
+			ctx,
+			`UPDATE orders SET status = ?, accrual = ?, processed = ? WHERE id = ? AND (status <> ? OR accrual <> ?) RETURNING owner, time, processed`,
+			string(status),
+			accrual,
+			processedMillis(status, now),
+			id,
+			string(status),
+			accrual,
+		)
+		var owner, orderTime, processed int64
+		if err := row.Scan(&owner, &orderTime, &processed); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil // the order has not changed
+			}
+			return err
+		}
+		if err := touchSQLite(ctx, transaction, owner, now); err != nil {
+			return err
+		}
+		if err := transaction.Commit(); err != nil {
+			return err
+		}
+
+		balance, balanceError := NewSQLiteIdentity(owner, s.db, s.publisher).Balance(ctx)
+		if balanceError != nil {
+			return balanceError
+		}
+		order := Order{ID: id, Status: status, Accrual: accrual, Time: time.UnixMilli(orderTime), Processed: makeProcessed(processed)}
+		publish(ctx, s.publisher, Event{User: owner, Order: &order, Balance: &balance})
+		return nil
+	})
+}
+
+// isSQLiteConstraintViolation reports whether err is a violation
+// of a unique or primary key constraint.
+func isSQLiteConstraintViolation(err error) bool {
+	sqliteError := new(sqlite.Error)
+	if !errors.As(err, &sqliteError) {
+		return false
+	}
+	switch sqliteError.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return true
+	}
+	return false
+}
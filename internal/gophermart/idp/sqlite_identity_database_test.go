@@ -0,0 +1,26 @@
+package idp_test
+
+import (
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"github.com/kerelape/gophermart/internal/gophermart/webhook/webhooktest"
+	"path/filepath"
+	"testing"
+)
+
+func openSQLite(t *testing.T, accrual accrual.Accrual, publisher idp.Publisher) idptest.Database {
+	return idp.NewSQLiteIdentityDatabase(filepath.Join(t.TempDir(), "gophermart.db"), accrual, publisher)
+}
+
+func TestSQLiteIdentityDatabase(t *testing.T) {
+	idptest.TestIdentityDatabase(t, openSQLite)
+}
+
+func TestSQLiteIdentityDatabaseDispatcher(t *testing.T) {
+	webhooktest.TestDispatcher(t, func(t *testing.T, accrual accrual.Accrual, publisher idp.Publisher) idptest.Database {
+		database := idp.NewSQLiteIdentityDatabase(filepath.Join(t.TempDir(), "gophermart.db"), accrual, publisher)
+		database.AllowPrivateWebhooks = true
+		return database
+	})
+}
@@ -0,0 +1,190 @@
+package idp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// sqliteMigrations are the schema changes applied by SQLiteIdentityDatabase,
+// the version of a migration is its index plus one.
+var sqliteMigrations = [][]string{
+	// Initial schema.
+	{
+		`
+		CREATE TABLE identities(
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			password TEXT NOT NULL
+		)
+		`,
+		`
+		CREATE TABLE orders(
+			id TEXT PRIMARY KEY,
+			owner INTEGER NOT NULL,
+			time INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			accrual REAL NOT NULL
+		)
+		`,
+		`CREATE INDEX orders_owner ON orders(owner)`,
+		`
+		CREATE TABLE withdrawals(
+			orderID TEXT PRIMARY KEY,
+			sum REAL NOT NULL,
+			time INTEGER NOT NULL,
+			owner INTEGER NOT NULL
+		)
+		`,
+		`CREATE INDEX withdrawals_owner ON withdrawals(owner)`,
+	},
+	// Webhooks.
+	{
+		`
+		CREATE TABLE webhooks(
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			owner INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			time INTEGER NOT NULL
+		)
+		`,
+		`CREATE INDEX webhooks_owner ON webhooks(owner)`,
+		`
+		CREATE TABLE webhook_deliveries(
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload BLOB NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			next_attempt INTEGER NOT NULL,
+			response_status INTEGER NOT NULL,
+			error TEXT NOT NULL,
+			time INTEGER NOT NULL,
+			updated INTEGER NOT NULL
+		)
+		`,
+		`CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries(webhook)`,
+		`CREATE INDEX webhook_deliveries_due ON webhook_deliveries(status, next_attempt)`,
+	},
+	// Idempotency keys.
+	{
+		`
+		CREATE TABLE idempotency_keys(
+			owner INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			status INTEGER NOT NULL,
+			content_type TEXT NOT NULL,
+			body BLOB NOT NULL,
+			time INTEGER NOT NULL,
+			PRIMARY KEY(owner, key)
+		)
+		`,
+	},
+	// Revisions of the identities.
+	{
+		`ALTER TABLE identities ADD COLUMN revision INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE identities ADD COLUMN modified INTEGER NOT NULL DEFAULT 0`,
+	},
+	// Adjustments of the balances.
+	{
+		`
+		CREATE TABLE balance_adjustments(
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			owner INTEGER NOT NULL,
+			sum REAL NOT NULL,
+			reason TEXT NOT NULL,
+			time INTEGER NOT NULL
+		)
+		`,
+		`CREATE INDEX balance_adjustments_owner ON balance_adjustments(owner)`,
+	},
+	// Deleted identities.
+	{
+		`ALTER TABLE identities ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0`,
+	},
+	// Processing times of the orders, unknown for the orders processed before, so their upload times.
+	{
+		`ALTER TABLE orders ADD COLUMN processed INTEGER NOT NULL DEFAULT 0`,
+		`UPDATE orders SET processed = time WHERE status IN ('PROCESSED', 'INVALID')`,
+	},
+	// Owners of the orders and the withdrawals, SQLite cannot add a foreign key
+	// to a table, so the tables are copied and the migration fails if some of the owners are unknown.
+	{
+		`
+		CREATE TABLE orders_owned(
+			id TEXT PRIMARY KEY,
+			owner INTEGER NOT NULL REFERENCES identities(id),
+			time INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			accrual REAL NOT NULL,
+			processed INTEGER NOT NULL DEFAULT 0
+		)
+		`,
+		`INSERT INTO orders_owned(id, owner, time, status, accrual, processed) SELECT id, owner, time, status, accrual, processed FROM orders`,
+		`DROP TABLE orders`,
+		`ALTER TABLE orders_owned RENAME TO orders`,
+		`CREATE INDEX orders_owner ON orders(owner)`,
+		`
+		CREATE TABLE withdrawals_owned(
+			orderID TEXT PRIMARY KEY,
+			sum REAL NOT NULL,
+			time INTEGER NOT NULL,
+			owner INTEGER NOT NULL REFERENCES identities(id)
+		)
+		`,
+		`INSERT INTO withdrawals_owned(orderID, sum, time, owner) SELECT orderID, sum, time, owner FROM withdrawals`,
+		`DROP TABLE withdrawals`,
+		`ALTER TABLE withdrawals_owned RENAME TO withdrawals`,
+		`CREATE INDEX withdrawals_owner ON withdrawals(owner)`,
+	},
+	// Generations of the passwords, which revoke the tokens issued before a change.
+	{
+		`ALTER TABLE identities ADD COLUMN password_generation INTEGER NOT NULL DEFAULT 0`,
+	},
+}
+
+// migrateSQLite applies the migrations that have not been applied yet.
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	transaction, transactionError := db.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+	defer transaction.Rollback()
+
+	if _, err := transaction.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS migrations(version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := transaction.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM migrations`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		for _, query := range sqliteMigrations[version] {
+			if _, err := transaction.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+		if _, err := transaction.ExecContext(ctx, `INSERT INTO migrations(version) VALUES(?)`, version+1); err != nil {
+			return err
+		}
+	}
+
+	return transaction.Commit()
+}
+
+// checkSQLiteMigrations returns an error if some of the migrations have not been applied.
+func checkSQLiteMigrations(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version < len(sqliteMigrations) {
+		return fmt.Errorf("the schema is at version %d, want %d", version, len(sqliteMigrations))
+	}
+	return nil
+}
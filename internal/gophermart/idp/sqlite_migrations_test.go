@@ -0,0 +1,61 @@
+package idp
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteMigrationOfUnknownOwners(t *testing.T) {
+	ctx := context.Background()
+	db, openError := sql.Open("sqlite", filepath.Join(t.TempDir(), "gophermart.db")+"?_pragma=foreign_keys(1)")
+	if openError != nil {
+		t.Fatal(openError)
+	}
+	defer db.Close()
+
+	// The schema before the foreign keys of the owners, which are added by version 8.
+	migrations := sqliteMigrations
+	sqliteMigrations = migrations[:7]
+	migrateError := migrateSQLite(ctx, db)
+	sqliteMigrations = migrations
+	if migrateError != nil {
+		t.Fatal(migrateError)
+	}
+
+	statements := []string{
+		`INSERT INTO identities(id, username, password) VALUES(1, 'alice', '')`,
+		`INSERT INTO orders(id, owner, time, status, accrual) VALUES('12345678903', 1, 0, 'NEW', 0)`,
+		`INSERT INTO orders(id, owner, time, status, accrual) VALUES('2377225624', 2, 0, 'NEW', 0)`,
+	}
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrateSQLite(ctx, db); err == nil {
+		t.Fatal("migrateSQLite() with an order of an unknown owner = nil, want an error")
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM orders WHERE owner = 2`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateSQLite(ctx, db); err != nil {
+		t.Fatalf("migrateSQLite() = %v, want nil", err)
+	}
+	if err := checkSQLiteMigrations(ctx, db); err != nil {
+		t.Fatalf("checkSQLiteMigrations() = %v, want nil", err)
+	}
+
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM orders WHERE owner = 1`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("orders of the known owner after the migration = %d, want 1", count)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO withdrawals(orderID, sum, time, owner) VALUES('2377225624', 1, 0, 2)`); err == nil {
+		t.Fatal("withdrawal of an unknown owner was inserted, want a foreign key error")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"github.com/kerelape/gophermart/internal/accrual"
+	"math"
 	"time"
 )
 
@@ -17,24 +18,74 @@ var (
 	// ErrOrderUnowned is returned when the user attempted to add an order that another user already has.
 	ErrOrderUnowned = errors.New("unowned order")
 
+	// ErrBalanceTooLow is returned when the user does not have enough points to withdraw.
 	ErrBalanceTooLow = errors.New("balance too low")
+
+	// ErrWithdrawalDuplicate is returned when points have already been withdrawn towards the order.
+	ErrWithdrawalDuplicate = errors.New("duplicate withdrawal")
+
+	// ErrWithdrawalSumInvalid is returned when the sum of a withdrawal is not a positive finite number.
+	ErrWithdrawalSumInvalid = errors.New("invalid withdrawal sum")
 )
 
 // User represents a Gophermart client.
 type User interface {
+	// ID returns the immutable identifier of the user.
+	ID() int64
+
+	// Username returns the username of the user.
+	Username(ctx context.Context) (string, error)
+
+	// SetUsername changes the username of the user.
+	SetUsername(ctx context.Context, username string) error
+
 	// AddOrder adds an order to the user.
+	//
+	// It returns ErrOrderInvalid if id is not a valid Luhn number,
+	// ErrOrderDuplicate if the user has already added the order
+	// and ErrOrderUnowned if another user has.
 	AddOrder(ctx context.Context, id string) error
 
-	Orders(ctx context.Context) ([]Order, error)
+	// Orders returns the orders added by the user that are selected by the query.
+	Orders(ctx context.Context, query OrderQuery) ([]Order, error)
 
 	// Balance returns current balance status.
 	Balance(ctx context.Context) (Balance, error)
 
 	// Withdraw withdraws amount towards order.
+	//
+	// It returns ErrOrderInvalid if order is not a valid Luhn number,
+	// ErrWithdrawalSumInvalid if amount is not positive,
+	// ErrBalanceTooLow if the current balance is less than amount
+	// and ErrWithdrawalDuplicate if points have already been withdrawn towards order.
+	// Concurrent withdrawals never make the balance negative.
 	Withdraw(ctx context.Context, order string, amount float64) error
 
-	// Withdrawals returns withdrawals history.
-	Withdrawals(ctx context.Context) ([]Withdrawal, error)
+	// Withdrawals returns the withdrawals history selected by the query.
+	Withdrawals(ctx context.Context, query ListQuery) ([]Withdrawal, error)
+
+	// Revision returns the current revision of the orders, the withdrawals and the balance.
+	Revision(ctx context.Context) (Revision, error)
+
+	// AddWebhook registers a webhook with a random secret,
+	// it returns ErrWebhookURLInvalid if url is not an absolute http(s) URL.
+	AddWebhook(ctx context.Context, url string) (Webhook, error)
+
+	// Webhooks returns the webhooks of the user.
+	Webhooks(ctx context.Context) ([]Webhook, error)
+
+	// DeleteWebhook deletes the webhook and its deliveries,
+	// it returns ErrUnknownWebhook if the user has no such webhook.
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	// Deliveries returns the deliveries to the webhook, newest first,
+	// no more than limit unless it is zero.
+	// It returns ErrUnknownWebhook if the user has no such webhook.
+	Deliveries(ctx context.Context, webhook int64, limit int) ([]Delivery, error)
+
+	// Delete anonymises the user, keeping its orders and withdrawals
+	// so that the accounting stays consistent, and deletes its webhooks.
+	Delete(ctx context.Context) error
 }
 
 type Withdrawal struct {
@@ -43,11 +94,43 @@ type Withdrawal struct {
 	Time  time.Time
 }
 
+// ValidWithdrawalSum reports whether sum can be withdrawn, that is it is a positive finite number of points.
+func ValidWithdrawalSum(sum float64) bool {
+	return sum > 0 && !math.IsInf(sum, 1)
+}
+
+// Adjustment is a change of the balance made by an operator.
+type Adjustment struct {
+	Sum    float64
+	Reason string
+	Time   time.Time
+}
+
 type Order struct {
 	ID      string
 	Status  OrderStatus
 	Accrual float64
 	Time    time.Time
+
+	// Processed is when the order got its final status, it is zero until then.
+	Processed time.Time
+}
+
+// processedMillis returns the processing time stored in a database for an order
+// that got the status at now, 0 unless the status is final.
+func processedMillis(status OrderStatus, now time.Time) int64 {
+	if !status.IsFinal() {
+		return 0
+	}
+	return now.UnixMilli()
+}
+
+// makeProcessed makes the processing time of an order of the milliseconds stored in a database.
+func makeProcessed(processed int64) time.Time {
+	if processed == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(processed)
 }
 
 type OrderStatus string
@@ -0,0 +1,121 @@
+package idp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnknownWebhook is returned when the user has no webhook with the requested id.
+	ErrUnknownWebhook = errors.New("unknown webhook")
+
+	// ErrWebhookURLInvalid is returned when a webhook URL is not an absolute http(s) URL,
+	// or its host is a loopback, private, link-local or unspecified address.
+	ErrWebhookURLInvalid = errors.New("invalid webhook url")
+)
+
+// Webhook is a URL the events of its user are delivered to.
+type Webhook struct {
+	ID  int64
+	URL string
+
+	// Secret is the key of the HMAC-SHA256 signatures of the deliveries.
+	Secret string
+
+	Time time.Time
+}
+
+type DeliveryStatus string
+
+var (
+	DeliveryStatusPending   = DeliveryStatus("PENDING")
+	DeliveryStatusDelivered = DeliveryStatus("DELIVERED")
+	DeliveryStatusFailed    = DeliveryStatus("FAILED")
+)
+
+// Delivery is a delivery of an event to a webhook.
+type Delivery struct {
+	ID      int64
+	Webhook Webhook
+
+	// Event is the type of the delivered event.
+	Event   string
+	Payload []byte
+
+	Status      DeliveryStatus
+	Attempts    int
+	NextAttempt time.Time
+
+	// ResponseStatus is the HTTP status of the last attempt, zero if there was no response.
+	ResponseStatus int
+
+	// Error describes why the last attempt failed.
+	Error string
+
+	Time      time.Time
+	UpdatedAt time.Time
+}
+
+// WebhookQueue is the queue of the webhook deliveries of all users.
+type WebhookQueue interface {
+	// EnqueueDeliveries creates a pending delivery of the event to every webhook of the user.
+	EnqueueDeliveries(ctx context.Context, user int64, event string, payload []byte) error
+
+	// DueDeliveries returns up to limit pending deliveries, the next attempt of which is due by now.
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]Delivery, error)
+
+	// UpdateDelivery stores the status, the attempts, the next attempt,
+	// the response status, the error and the update time of the delivery.
+	UpdateDelivery(ctx context.Context, delivery Delivery) error
+}
+
+// PublicAddress reports whether the webhooks may be delivered to ip, that is it is not
+// a loopback, private, link-local, multicast or unspecified address.
+func PublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() &&
+		!ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// newWebhook validates the URL and generates a secret for a new webhook,
+// the URL may point at a private address only if allowPrivate is set.
+//
+// The host names are not resolved here, the dispatcher checks the addresses it connects to.
+func newWebhook(rawURL string, now time.Time, allowPrivate bool) (Webhook, error) {
+	parsed, parseError := url.Parse(rawURL)
+	if parseError != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return Webhook{}, ErrWebhookURLInvalid
+	}
+	if !allowPrivate {
+		host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return Webhook{}, ErrWebhookURLInvalid
+		}
+		if ip, err := netip.ParseAddr(host); err == nil && !PublicAddress(ip) {
+			return Webhook{}, ErrWebhookURLInvalid
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Webhook{}, err
+	}
+
+	return Webhook{
+		URL:    parsed.String(),
+		Secret: hex.EncodeToString(secret),
+		Time:   time.UnixMilli(now.UnixMilli()),
+	}, nil
+}
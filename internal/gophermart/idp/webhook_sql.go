@@ -0,0 +1,44 @@
+package idp
+
+import "time"
+
+// webhookColumns are the columns of the webhooks table scanned by scanWebhook.
+const webhookColumns = `webhooks.id, webhooks.url, webhooks.secret, webhooks.time`
+
+// deliveryColumns are the columns of the joined webhook_deliveries
+// and webhooks tables scanned by scanDelivery.
+const deliveryColumns = `webhook_deliveries.id, webhook_deliveries.event, webhook_deliveries.payload,
+	webhook_deliveries.status, webhook_deliveries.attempts, webhook_deliveries.next_attempt,
+	webhook_deliveries.response_status, webhook_deliveries.error,
+	webhook_deliveries.time, webhook_deliveries.updated, ` + webhookColumns
+
+func scanWebhook(scan func(dest ...any) error) (Webhook, error) {
+	webhook := Webhook{}
+	var webhookTime int64
+	if err := scan(&webhook.ID, &webhook.URL, &webhook.Secret, &webhookTime); err != nil {
+		return Webhook{}, err
+	}
+	webhook.Time = time.UnixMilli(webhookTime)
+	return webhook, nil
+}
+
+func scanDelivery(scan func(dest ...any) error) (Delivery, error) {
+	delivery := Delivery{}
+	var status string
+	var nextAttempt, deliveryTime, updatedAt, webhookTime int64
+	if err := scan(
+		&delivery.ID, &delivery.Event, &delivery.Payload,
+		&status, &delivery.Attempts, &nextAttempt,
+		&delivery.ResponseStatus, &delivery.Error,
+		&deliveryTime, &updatedAt,
+		&delivery.Webhook.ID, &delivery.Webhook.URL, &delivery.Webhook.Secret, &webhookTime,
+	); err != nil {
+		return Delivery{}, err
+	}
+	delivery.Status = DeliveryStatus(status)
+	delivery.NextAttempt = time.UnixMilli(nextAttempt)
+	delivery.Time = time.UnixMilli(deliveryTime)
+	delivery.UpdatedAt = time.UnixMilli(updatedAt)
+	delivery.Webhook.Time = time.UnixMilli(webhookTime)
+	return delivery, nil
+}
@@ -0,0 +1,201 @@
+package gophermart
+
+import (
+	"context"
+	"errors"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/metrics"
+	"time"
+)
+
+// instrumentedDatabase records the durations of the operations of the database
+// and the outcomes of the withdrawals in the metrics.
+type instrumentedDatabase struct {
+	identityDatabase
+}
+
+func (d instrumentedDatabase) Create(ctx context.Context, username, password string) (err error) {
+	defer observe("create", time.Now(), &err)
+	return d.identityDatabase.Create(ctx, username, password)
+}
+
+func (d instrumentedDatabase) Find(ctx context.Context, username string) (_ idp.Identity, err error) {
+	defer observe("find", time.Now(), &err)
+	identity, findError := d.identityDatabase.Find(ctx, username)
+	if findError != nil {
+		return nil, findError
+	}
+	return instrumentedIdentity{identity}, nil
+}
+
+func (d instrumentedDatabase) Identity(ctx context.Context, id int64) (_ idp.Identity, err error) {
+	defer observe("identity", time.Now(), &err)
+	identity, identityError := d.identityDatabase.Identity(ctx, id)
+	if identityError != nil {
+		return nil, identityError
+	}
+	return instrumentedIdentity{identity}, nil
+}
+
+func (d instrumentedDatabase) RecheckOrder(ctx context.Context, id string) (err error) {
+	defer observe("recheck_order", time.Now(), &err)
+	return d.identityDatabase.RecheckOrder(ctx, id)
+}
+
+func (d instrumentedDatabase) AdjustBalance(ctx context.Context, user int64, sum float64, reason string) (err error) {
+	defer observe("adjust_balance", time.Now(), &err)
+	return d.identityDatabase.AdjustBalance(ctx, user, sum, reason)
+}
+
+func (d instrumentedDatabase) Adjustments(ctx context.Context, user int64) (_ []idp.Adjustment, err error) {
+	defer observe("adjustments", time.Now(), &err)
+	return d.identityDatabase.Adjustments(ctx, user)
+}
+
+func (d instrumentedDatabase) EnqueueDeliveries(ctx context.Context, user int64, event string, payload []byte) (err error) {
+	defer observe("enqueue_deliveries", time.Now(), &err)
+	return d.identityDatabase.EnqueueDeliveries(ctx, user, event, payload)
+}
+
+func (d instrumentedDatabase) DueDeliveries(ctx context.Context, now time.Time, limit int) (_ []idp.Delivery, err error) {
+	defer observe("due_deliveries", time.Now(), &err)
+	return d.identityDatabase.DueDeliveries(ctx, now, limit)
+}
+
+func (d instrumentedDatabase) UpdateDelivery(ctx context.Context, delivery idp.Delivery) (err error) {
+	defer observe("update_delivery", time.Now(), &err)
+	return d.identityDatabase.UpdateDelivery(ctx, delivery)
+}
+
+func (d instrumentedDatabase) BeginIdempotentRequest(
+	ctx context.Context,
+	user int64,
+	key, fingerprint string,
+	since time.Time,
+) (_ idp.IdempotentRequest, _ bool, err error) {
+	defer observe("begin_idempotent_request", time.Now(), &err)
+	return d.identityDatabase.BeginIdempotentRequest(ctx, user, key, fingerprint, since)
+}
+
+func (d instrumentedDatabase) CompleteIdempotentRequest(ctx context.Context, user int64, key string, response idp.IdempotentResponse) (err error) {
+	defer observe("complete_idempotent_request", time.Now(), &err)
+	return d.identityDatabase.CompleteIdempotentRequest(ctx, user, key, response)
+}
+
+func (d instrumentedDatabase) CancelIdempotentRequest(ctx context.Context, user int64, key string) (err error) {
+	defer observe("cancel_idempotent_request", time.Now(), &err)
+	return d.identityDatabase.CancelIdempotentRequest(ctx, user, key)
+}
+
+// instrumentedIdentity records the durations of the operations of the identity.
+type instrumentedIdentity struct {
+	idp.Identity
+}
+
+func (i instrumentedIdentity) Username(ctx context.Context) (_ string, err error) {
+	defer observe("username", time.Now(), &err)
+	return i.Identity.Username(ctx)
+}
+
+func (i instrumentedIdentity) SetUsername(ctx context.Context, username string) (err error) {
+	defer observe("set_username", time.Now(), &err)
+	return i.Identity.SetUsername(ctx, username)
+}
+
+func (i instrumentedIdentity) AddOrder(ctx context.Context, id string) (err error) {
+	defer observe("add_order", time.Now(), &err)
+	return i.Identity.AddOrder(ctx, id)
+}
+
+func (i instrumentedIdentity) Orders(ctx context.Context, query idp.OrderQuery) (_ []idp.Order, err error) {
+	defer observe("orders", time.Now(), &err)
+	return i.Identity.Orders(ctx, query)
+}
+
+func (i instrumentedIdentity) Balance(ctx context.Context) (_ idp.Balance, err error) {
+	defer observe("balance", time.Now(), &err)
+	return i.Identity.Balance(ctx)
+}
+
+func (i instrumentedIdentity) Withdraw(ctx context.Context, order string, amount float64) (err error) {
+	defer observe("withdraw", time.Now(), &err)
+	err = i.Identity.Withdraw(ctx, order, amount)
+	metrics.Withdrawals.WithLabelValues(withdrawalOutcome(err)).Inc()
+	return err
+}
+
+func (i instrumentedIdentity) Withdrawals(ctx context.Context, query idp.ListQuery) (_ []idp.Withdrawal, err error) {
+	defer observe("withdrawals", time.Now(), &err)
+	return i.Identity.Withdrawals(ctx, query)
+}
+
+func (i instrumentedIdentity) Revision(ctx context.Context) (_ idp.Revision, err error) {
+	defer observe("revision", time.Now(), &err)
+	return i.Identity.Revision(ctx)
+}
+
+func (i instrumentedIdentity) AddWebhook(ctx context.Context, url string) (_ idp.Webhook, err error) {
+	defer observe("add_webhook", time.Now(), &err)
+	return i.Identity.AddWebhook(ctx, url)
+}
+
+func (i instrumentedIdentity) Webhooks(ctx context.Context) (_ []idp.Webhook, err error) {
+	defer observe("webhooks", time.Now(), &err)
+	return i.Identity.Webhooks(ctx)
+}
+
+func (i instrumentedIdentity) DeleteWebhook(ctx context.Context, id int64) (err error) {
+	defer observe("delete_webhook", time.Now(), &err)
+	return i.Identity.DeleteWebhook(ctx, id)
+}
+
+func (i instrumentedIdentity) Deliveries(ctx context.Context, webhook int64, limit int) (_ []idp.Delivery, err error) {
+	defer observe("deliveries", time.Now(), &err)
+	return i.Identity.Deliveries(ctx, webhook, limit)
+}
+
+func (i instrumentedIdentity) Delete(ctx context.Context) (err error) {
+	defer observe("delete", time.Now(), &err)
+	return i.Identity.Delete(ctx)
+}
+
+func (i instrumentedIdentity) ComparePassword(ctx context.Context, password string) (_ bool, err error) {
+	defer observe("compare_password", time.Now(), &err)
+	return i.Identity.ComparePassword(ctx, password)
+}
+
+func (i instrumentedIdentity) SetPassword(ctx context.Context, password string) (err error) {
+	defer observe("set_password", time.Now(), &err)
+	return i.Identity.SetPassword(ctx, password)
+}
+
+func (i instrumentedIdentity) PasswordGeneration(ctx context.Context) (_ int64, err error) {
+	defer observe("password_generation", time.Now(), &err)
+	return i.Identity.PasswordGeneration(ctx)
+}
+
+// observe records the duration of the operation started at start,
+// err is a pointer so that it can be deferred before the error is known.
+func observe(operation string, start time.Time, err *error) {
+	outcome := "ok"
+	if *err != nil {
+		outcome = "error"
+	}
+	metrics.DatabaseOperationDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+func withdrawalOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, idp.ErrBalanceTooLow):
+		return "balance_too_low"
+	case errors.Is(err, idp.ErrWithdrawalDuplicate):
+		return "duplicate"
+	case errors.Is(err, idp.ErrOrderInvalid):
+		return "invalid_order"
+	case errors.Is(err, idp.ErrWithdrawalSumInvalid):
+		return "invalid_sum"
+	}
+	return "error"
+}
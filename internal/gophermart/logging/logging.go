@@ -0,0 +1,101 @@
+// Package logging sets up the structured logs of gophermart and carries
+// the loggers of the requests, with the request IDs, in the contexts.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/exp/slog"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	// FormatJSON writes a JSON object per record.
+	FormatJSON = "json"
+
+	// FormatText writes key=value pairs per record.
+	FormatText = "text"
+)
+
+// HeaderRequestID is the header the request IDs are taken from and sent in.
+const HeaderRequestID = "X-Request-ID"
+
+type contextKey string
+
+const (
+	contextKeyLogger    = contextKey("logging.logger")
+	contextKeyRequestID = contextKey("logging.request_id")
+)
+
+// New creates a new logger writing the records of the level and above to out in the format.
+func New(out io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(out, options)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(out, options)), nil
+	}
+	return nil, fmt.Errorf("unsupported log format %q", format)
+}
+
+// FromContext returns the logger of the context, or the default logger if there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if holder, ok := ctx.Value(contextKeyLogger).(*atomic.Pointer[slog.Logger]); ok {
+		return holder.Load()
+	}
+	return slog.Default()
+}
+
+// With returns a context with a logger that adds the attributes to every record.
+//
+// If the context already has a logger, it is replaced in place, so that
+// the middlewares that have set the logger up see the attributes added
+// by the inner ones (e.g. the authenticated user).
+func With(ctx context.Context, args ...any) context.Context {
+	if holder, ok := ctx.Value(contextKeyLogger).(*atomic.Pointer[slog.Logger]); ok {
+		holder.Store(holder.Load().With(args...))
+		return ctx
+	}
+	holder := new(atomic.Pointer[slog.Logger])
+	holder.Store(slog.Default().With(args...))
+	return context.WithValue(ctx, contextKeyLogger, holder)
+}
+
+// WithRequestID returns a context with the request ID,
+// the ID is added to the records of the logger of the context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, contextKeyRequestID, id)
+	return With(ctx, slog.String("request_id", id))
+}
+
+// RequestID returns the request ID of the context, or an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyRequestID).(string)
+	return id
+}
+
+// NewRequestID generates a random request ID.
+func NewRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// ValidRequestID reports whether an ID sent by a client is safe to log and send on.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+// Middleware gives every request a logger with its request ID,
+// taken from the X-Request-ID header or generated, and the ID of its trace,
+// and logs the request once it is handled. The ID is sent back in the X-Request-ID header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		start := time.Now()
+		id := in.Header.Get(HeaderRequestID)
+		if !ValidRequestID(id) {
+			id = NewRequestID()
+		}
+		ctx := WithRequestID(in.Context(), id)
+		if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+			ctx = With(ctx, "trace_id", span.TraceID().String())
+		}
+		out.Header().Set(HeaderRequestID, id)
+
+		writer := middleware.NewWrapResponseWriter(out, in.ProtoMajor)
+		next.ServeHTTP(writer, in.WithContext(ctx))
+
+		status := writer.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		FromContext(ctx).LogAttrs(
+			ctx,
+			level,
+			"request",
+			slog.String("method", in.Method),
+			slog.String("path", in.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", writer.BytesWritten()),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote", in.RemoteAddr),
+		)
+	})
+}
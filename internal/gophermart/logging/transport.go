@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+// Transport returns a http.RoundTripper that sends the request ID of the context
+// of the requests in the X-Request-ID header and logs the requests made through next.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(out *http.Request) (*http.Response, error) {
+		ctx := out.Context()
+		if id := RequestID(ctx); id != "" {
+			out = out.Clone(ctx)
+			out.Header.Set(HeaderRequestID, id)
+		}
+
+		start := time.Now()
+		in, err := next.RoundTrip(out)
+		logger := FromContext(ctx).With(
+			slog.String("method", out.Method),
+			slog.String("url", out.URL.String()),
+			slog.Duration("duration", time.Since(start)),
+		)
+		if err != nil {
+			logger.Warn("outgoing request failed", slog.Any("error", err))
+			return nil, err
+		}
+		logger.Debug("outgoing request", slog.Int("status", in.StatusCode))
+		return in, nil
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(out *http.Request) (*http.Response, error) {
+	return f(out)
+}
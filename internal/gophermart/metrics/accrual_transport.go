@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+)
+
+// AccrualTransport returns a http.RoundTripper that records the requests
+// made through next to the accrual system in AccrualRequestDuration.
+func AccrualTransport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(out *http.Request) (*http.Response, error) {
+		start := time.Now()
+		in, err := next.RoundTrip(out)
+		AccrualRequestDuration.WithLabelValues(accrualOutcome(in, err)).Observe(time.Since(start).Seconds())
+		return in, err
+	})
+}
+
+func accrualOutcome(in *http.Response, err error) string {
+	if err != nil {
+		return "error"
+	}
+	switch in.StatusCode {
+	case http.StatusOK:
+		return "ok"
+	case http.StatusNoContent:
+		return "unknown_order"
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	}
+	return "error"
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(out *http.Request) (*http.Response, error) {
+	return f(out)
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTP is a middleware that records the requests in HTTPRequests and HTTPRequestDuration,
+// labelled with the route patterns matched by chi so that the paths with
+// parameters do not make a label each.
+func HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		start := time.Now()
+		writer := middleware.NewWrapResponseWriter(out, in.ProtoMajor)
+		next.ServeHTTP(writer, in)
+
+		route := "unmatched"
+		if routeContext := chi.RouteContext(in.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+			route = routeContext.RoutePattern()
+		}
+		status := writer.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		HTTPRequests.WithLabelValues(in.Method, route, strconv.Itoa(status)).Inc()
+		HTTPRequestDuration.WithLabelValues(in.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
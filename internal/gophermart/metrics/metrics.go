@@ -0,0 +1,95 @@
+// Package metrics defines the Prometheus metrics of gophermart,
+// they are registered with the default registry and served by Handler.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "gophermart"
+
+var (
+	// HTTPRequests counts the handled HTTP requests by method, route and status.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of the handled HTTP requests.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes the time spent handling the HTTP requests by method and route.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time spent handling the HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// AccrualRequestDuration observes the requests to the accrual system by outcome.
+	AccrualRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the requests to the accrual system by outcome (ok, unknown_order, too_many_requests, error).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	// AccrualRetryAfterSeconds sums the time waited at the request of the accrual system.
+	AccrualRetryAfterSeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "retry_after_seconds_total",
+		Help:      "Time waited because the accrual system answered with 429 Too Many Requests.",
+	})
+
+	// PendingOrders is the number of the orders waiting for the accrual system by status.
+	PendingOrders = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "orders",
+		Name:      "pending",
+		Help:      "Number of the orders with a non-final status, as of the last poll.",
+	}, []string{"status"})
+
+	// PollDuration observes the iterations of the accrual poll loop.
+	PollDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "orders",
+		Name:      "poll_duration_seconds",
+		Help:      "Duration of the iterations of the loop polling the accrual system.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	})
+
+	// Withdrawals counts the withdrawals by outcome.
+	Withdrawals = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "withdrawals_total",
+		Help:      "Number of the withdrawals by outcome (ok, balance_too_low, duplicate, invalid_order, error).",
+	}, []string{"outcome"})
+
+	// BrokerDroppedEvents counts the events dropped for the subscribers lagging behind
+	// by subscription (user, all).
+	BrokerDroppedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "broker",
+		Name:      "dropped_events_total",
+		Help:      "Number of the events dropped for the subscribers lagging behind by subscription (user, all).",
+	}, []string{"subscription"})
+
+	// DatabaseOperationDuration observes the identity database operations by operation and outcome.
+	DatabaseOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of the identity database operations by outcome (ok, error).",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "outcome"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
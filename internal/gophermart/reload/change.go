@@ -0,0 +1,15 @@
+// Package reload describes the changes of the configuration made by a reload.
+package reload
+
+// Change is a setting that differs in the reloaded configuration.
+type Change struct {
+	Setting string `json:"setting"`
+
+	// Old and New are the values of the setting, they are empty for the secrets.
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+
+	// Applied reports whether the new value is in effect,
+	// the settings that are not applied take effect after a restart.
+	Applied bool `json:"applied"`
+}
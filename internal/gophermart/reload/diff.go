@@ -0,0 +1,34 @@
+package reload
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Diff returns the changes of the fields of the struct from old to new, in the order of the fields.
+//
+// The fields are described by their tags: reload:"live" marks the fields applied
+// without a restart and secret:"true" the fields the values of which are not reported.
+func Diff(old, new any) []Change {
+	oldValue, newValue := reflect.ValueOf(old), reflect.ValueOf(new)
+	changes := make([]Change, 0)
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		oldField, newField := oldValue.Field(i).Interface(), newValue.Field(i).Interface()
+		if reflect.DeepEqual(oldField, newField) {
+			continue
+		}
+		change := Change{
+			Setting: field.Name,
+			Applied: field.Tag.Get("reload") == "live",
+		}
+		if field.Tag.Get("secret") != "true" {
+			change.Old, change.New = fmt.Sprint(oldField), fmt.Sprint(newField)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
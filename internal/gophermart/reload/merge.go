@@ -0,0 +1,16 @@
+package reload
+
+import "reflect"
+
+// Merge returns old with the fields marked reload:"live" taken from new,
+// which is the configuration in effect after new has been applied.
+func Merge[T any](old, new T) T {
+	merged := old
+	mergedValue, newValue := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(new)
+	for i := 0; i < mergedValue.NumField(); i++ {
+		if mergedValue.Type().Field(i).Tag.Get("reload") == "live" {
+			mergedValue.Field(i).Set(newValue.Field(i))
+		}
+	}
+	return merged
+}
@@ -0,0 +1,79 @@
+package gophermart
+
+import (
+	"context"
+	"github.com/kerelape/gophermart/internal/gophermart/logging"
+	"github.com/kerelape/gophermart/internal/gophermart/reload"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// reloader reloads the configuration from source on SIGHUP and on the requests
+// to the admin endpoint, and applies the live settings with apply.
+//
+// The settings that are not live are reported as changed on every reload
+// until the process is restarted.
+type reloader struct {
+	source func() (Config, error)
+	apply  func(config Config)
+
+	mutex  *sync.Mutex
+	config Config
+}
+
+func newReloader(config Config, source func() (Config, error), apply func(config Config)) *reloader {
+	return &reloader{
+		source: source,
+		apply:  apply,
+
+		mutex:  &sync.Mutex{},
+		config: config,
+	}
+}
+
+func (r *reloader) Run(ctx context.Context) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-signals:
+			// The failures are logged by reload, the current configuration stays in effect.
+			_, _ = r.reload(ctx)
+		}
+	}
+}
+
+// reload loads the configuration, applies its live settings and logs the changes.
+func (r *reloader) reload(ctx context.Context) ([]reload.Change, error) {
+	logger := logging.FromContext(ctx)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	config, sourceError := r.source()
+	if sourceError != nil {
+		logger.Error("failed to reload the configuration", "error", sourceError)
+		return nil, sourceError
+	}
+	changes := reload.Diff(r.config, config)
+	r.apply(config)
+	r.config = reload.Merge(r.config, config)
+
+	for _, change := range changes {
+		attributes := []any{"setting", change.Setting}
+		if change.Old != "" || change.New != "" {
+			attributes = append(attributes, "old", change.Old, "new", change.New)
+		}
+		if change.Applied {
+			logger.Info("setting reloaded", attributes...)
+		} else {
+			logger.Warn("setting changed, restart to apply it", attributes...)
+		}
+	}
+	logger.Info("configuration reloaded", "changes", len(changes))
+	return changes, nil
+}
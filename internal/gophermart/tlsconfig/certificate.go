@@ -0,0 +1,117 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"golang.org/x/exp/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultCheckInterval is how often the certificate files are checked for changes by default.
+const defaultCheckInterval = 10 * time.Second
+
+// Certificate is a certificate loaded from a certificate file and a key file,
+// which is reloaded when either of the files changes on disk.
+//
+// A certificate that fails to load (e.g. while the files are being replaced
+// one by one) is logged and the previous one is served until the next check.
+type Certificate struct {
+	certFile string
+	keyFile  string
+
+	// CheckInterval is how often the files are checked for changes.
+	CheckInterval time.Duration
+
+	mutex       *sync.RWMutex
+	certificate *tls.Certificate
+	modified    time.Time
+}
+
+// NewCertificate creates a new Certificate and loads it from the files.
+func NewCertificate(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{
+		certFile: certFile,
+		keyFile:  keyFile,
+
+		CheckInterval: defaultCheckInterval,
+
+		mutex: &sync.RWMutex{},
+	}
+	modified, modifiedError := c.lastModified()
+	if modifiedError != nil {
+		return nil, modifiedError
+	}
+	if err := c.load(modified); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, see tls.Config.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.certificate, nil
+}
+
+// Run reloads the certificate every CheckInterval if the files have been modified.
+func (c *Certificate) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.check()
+		}
+	}
+}
+
+func (c *Certificate) check() {
+	modified, modifiedError := c.lastModified()
+	if modifiedError != nil {
+		slog.Error("failed to check the TLS certificate", "file", c.certFile, "error", modifiedError)
+		return
+	}
+	c.mutex.RLock()
+	unchanged := modified.Equal(c.modified)
+	c.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+	if err := c.load(modified); err != nil {
+		slog.Error("failed to reload the TLS certificate", "file", c.certFile, "error", err)
+		return
+	}
+	slog.Info("TLS certificate reloaded", "file", c.certFile)
+}
+
+func (c *Certificate) load(modified time.Time) error {
+	certificate, loadError := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if loadError != nil {
+		return loadError
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.certificate = &certificate
+	c.modified = modified
+	return nil
+}
+
+// lastModified returns the latest modification time of the files.
+func (c *Certificate) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, statError := os.Stat(file)
+		if statError != nil {
+			return time.Time{}, statError
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
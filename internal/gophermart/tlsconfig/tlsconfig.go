@@ -0,0 +1,87 @@
+// Package tlsconfig makes the TLS configurations of the servers of gophermart
+// from the certificate files, which are reloaded when they change on disk.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// versions maps the names of the TLS versions to their identifiers.
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// New creates a new TLS configuration serving the certificate.
+//
+// The connections of the versions lower than minVersion are refused, cipherSuites are
+// the cipher suites of TLS 1.0-1.2 (those of TLS 1.3 are not configurable), the default
+// ones are used if it is empty.
+func New(certificate *Certificate, minVersion uint16, cipherSuites []uint16) *tls.Config {
+	return &tls.Config{
+		GetCertificate: certificate.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+	}
+}
+
+// ParseVersion parses a TLS version, "1.0", "1.1", "1.2" or "1.3".
+func ParseVersion(s string) (uint16, error) {
+	version, ok := versions[strings.TrimSpace(s)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites parses a comma separated list of the names of the cipher suites
+// (e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), the insecure ones are refused.
+func ParseCipherSuites(list string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	suites := make([]uint16, 0)
+	errs := make([]error, 0)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := known[name]
+		switch {
+		case ok:
+			suites = append(suites, id)
+		case insecure[name]:
+			errs = append(errs, fmt.Errorf("insecure cipher suite %q", name))
+		default:
+			errs = append(errs, fmt.Errorf("unknown cipher suite %q", name))
+		}
+	}
+	return suites, errors.Join(errs...)
+}
+
+// LoadCertPool loads the PEM encoded certificates of the file into a new pool.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	content, readError := os.ReadFile(file)
+	if readError != nil {
+		return nil, readError
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,32 @@
+package tracing
+
+import (
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Middleware starts a server span for every request, continuing the trace
+// of the W3C traceparent header if there is one.
+//
+// The spans are named after the route patterns matched by chi,
+// so that the paths with parameters do not make a name each.
+func Middleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		next.ServeHTTP(out, in)
+		if routeContext := chi.RouteContext(in.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+			span := trace.SpanFromContext(in.Context())
+			span.SetName(in.Method + " " + routeContext.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(routeContext.RoutePattern()))
+		}
+	})
+	return otelhttp.NewHandler(
+		named,
+		"http",
+		otelhttp.WithSpanNameFormatter(func(_ string, in *http.Request) string {
+			return in.Method
+		}),
+	)
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// QueryTracer is a pgx.QueryTracer that starts a client span for every query,
+// named after the SQL command, with the statement without its arguments.
+//
+// The queries are traced only as a part of a trace, so that the background
+// work that is not traced itself does not make a trace per query.
+type QueryTracer struct{}
+
+type contextKey string
+
+// contextKeyQuerySpan keeps the span of the query, so that TraceQueryEnd
+// does not end the span of the caller if the query has not been traced.
+const contextKeyQuerySpan = contextKey("tracing.query_span")
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	ctx, span := Start(
+		ctx,
+		command(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBStatement(data.SQL)),
+	)
+	return context.WithValue(ctx, contextKeyQuerySpan, span)
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span, ok := ctx.Value(contextKeyQuerySpan).(trace.Span)
+	if !ok {
+		return
+	}
+	if data.Err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	End(span, data.Err)
+}
+
+// command returns the first word of the sql in upper case (e.g. SELECT or WITH).
+func command(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "QUERY"
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,96 @@
+// Package tracing sets up the OpenTelemetry traces of gophermart
+// and instruments the HTTP servers and clients and the PostgreSQL queries.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+)
+
+const (
+	// ExporterNone does not export the spans, the trace context is still propagated.
+	ExporterNone = "none"
+
+	// ExporterStdout writes the spans to the standard output, for local debugging.
+	ExporterStdout = "stdout"
+
+	// ExporterOTLP sends the spans to an OTLP collector over gRPC,
+	// configured by the standard OTEL_EXPORTER_OTLP_* environment variables.
+	ExporterOTLP = "otlp"
+)
+
+// instrumentationName is the name of the tracer of gophermart.
+const instrumentationName = "github.com/kerelape/gophermart"
+
+// serviceName is the default name of the service, OTEL_SERVICE_NAME overrides it.
+const serviceName = "gophermart"
+
+// Setup installs the global tracer provider exporting the spans with the exporter
+// and the W3C trace context and baggage propagators.
+//
+// The returned function flushes the spans that have not been exported yet
+// and shuts the provider down.
+func Setup(ctx context.Context, exporter string, stdout io.Writer) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		stdoutExporter, stdoutError := stdouttrace.New(stdouttrace.WithWriter(stdout))
+		if stdoutError != nil {
+			return nil, stdoutError
+		}
+		spanExporter = stdoutExporter
+	case ExporterOTLP:
+		otlpExporter, otlpError := otlptracegrpc.New(ctx)
+		if otlpError != nil {
+			return nil, otlpError
+		}
+		spanExporter = otlpExporter
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter %q", exporter)
+	}
+
+	serviceResource, resourceError := resource.New(
+		ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if resourceError != nil {
+		return nil, resourceError
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(serviceResource),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name as a child of the span of the context, if there is one.
+func Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, options...)
+}
+
+// End records err in the span, if it is not nil, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
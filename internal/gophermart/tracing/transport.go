@@ -0,0 +1,17 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"net/http"
+)
+
+// Transport returns a http.RoundTripper that starts a client span for every request
+// made through next and sends the trace context in the W3C traceparent header.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(
+		next,
+		otelhttp.WithSpanNameFormatter(func(_ string, out *http.Request) string {
+			return "HTTP " + out.Method
+		}),
+	)
+}
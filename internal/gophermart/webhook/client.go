@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddress is returned when a webhook resolves to an address that is not public.
+var errPrivateAddress = errors.New("the webhook address is not public")
+
+// Client returns the client of the deliveries, which connects only to the public addresses
+// (see idp.PublicAddress) and does not follow the redirects, so that a webhook cannot
+// reach the internal services however its host resolves.
+func Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   controlPublic,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// controlPublic refuses to connect to the address resolved by the dialer unless it is public.
+func controlPublic(_, address string, _ syscall.RawConn) error {
+	addrPort, parseError := netip.ParseAddrPort(address)
+	if parseError != nil {
+		return parseError
+	}
+	if !idp.PublicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(out http.ResponseWriter, _ *http.Request) {
+		out.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := Client(time.Second).Get(server.URL)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Fatalf("Get(%s) = %v, want %v", server.URL, err, errPrivateAddress)
+	}
+}
@@ -0,0 +1,199 @@
+// Package webhook delivers the events of the users to their webhooks.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/pior/runnable"
+	"golang.org/x/exp/slog"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// dueLimit is the maximum number of deliveries attempted at once.
+	dueLimit = 100
+
+	// responseLimit is how much of a response body is read before the connection is reused.
+	responseLimit = 64 << 10
+)
+
+// Dispatcher queues the events published to the broker for the webhooks
+// of their users and delivers them, retrying the failed deliveries
+// with an exponential backoff.
+//
+// The deliveries that fail MaxAttempts times are marked failed
+// and logged as dead letters. A delivery may be attempted again after
+// it has been received, so the receivers should use the event id
+// to drop the duplicates.
+type Dispatcher struct {
+	queue  idp.WebhookQueue
+	broker idp.Broker
+	client *http.Client
+
+	// MaxAttempts is the number of attempts to deliver an event.
+	MaxAttempts int
+
+	// Backoff is the delay before the second attempt, it doubles after every failed attempt.
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum delay between the attempts.
+	MaxBackoff time.Duration
+
+	// concurrency is the maximum number of concurrent delivery requests.
+	concurrency atomic.Int64
+}
+
+// New creates a new Dispatcher.
+func New(queue idp.WebhookQueue, broker idp.Broker, client *http.Client) *Dispatcher {
+	dispatcher := &Dispatcher{
+		queue:  queue,
+		broker: broker,
+		client: client,
+
+		MaxAttempts: 8,
+		Backoff:     10 * time.Second,
+		MaxBackoff:  time.Hour,
+	}
+	dispatcher.concurrency.Store(8)
+	return dispatcher
+}
+
+// SetConcurrency changes the maximum number of concurrent delivery requests,
+// the next deliveries are made with at most concurrency requests at once.
+func (d *Dispatcher) SetConcurrency(concurrency int) {
+	d.concurrency.Store(int64(concurrency))
+}
+
+func (d *Dispatcher) Run(ctx context.Context) error {
+	manager := runnable.NewManager()
+	manager.Add(runnable.Func(d.enqueue))
+	manager.Add(runnable.Every(runnable.Func(d.deliver), time.Second))
+	return manager.Build().Run(ctx)
+}
+
+// enqueue queues the deliveries of the published events.
+func (d *Dispatcher) enqueue(ctx context.Context) error {
+	events := d.broker.SubscribeAll(ctx)
+	for event := range events {
+		messages, messagesError := messages(event, time.Now())
+		if messagesError != nil {
+			slog.Error("failed to encode webhook event", "user", event.User, "error", messagesError)
+			continue
+		}
+		for _, m := range messages {
+			if err := d.queue.EnqueueDeliveries(ctx, m.user, m.event, m.payload); err != nil {
+				slog.Error("failed to enqueue webhook deliveries", "event", m.event, "user", m.user, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// deliver attempts the deliveries that are due.
+//
+// The errors of the queue are logged instead of stopping the dispatcher,
+// the deliveries are attempted again once the database recovers.
+func (d *Dispatcher) deliver(ctx context.Context) error {
+	deliveries, deliveriesError := d.queue.DueDeliveries(ctx, time.Now(), dueLimit)
+	if errors.Is(deliveriesError, idp.ErrUnavailable) {
+		return nil // delivered once the database is available
+	}
+	if deliveriesError != nil {
+		slog.Error("failed to fetch due webhook deliveries", "error", deliveriesError)
+		return nil
+	}
+
+	eg, egctx := errgroup.WithContext(ctx)
+	eg.SetLimit(int(d.concurrency.Load()))
+	for _, delivery := range deliveries {
+		delivery := delivery
+		eg.Go(func() error {
+			if err := d.attempt(egctx, delivery); err != nil {
+				slog.Error("failed to store webhook delivery", "delivery", delivery.ID, "error", err)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// attempt sends the delivery and stores the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, delivery idp.Delivery) error {
+	status, sendError := d.send(ctx, delivery)
+	if ctx.Err() != nil {
+		return nil // shutting down, the delivery will be attempted on the next start
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.UpdatedAt = now
+	switch {
+	case sendError == nil:
+		delivery.Status = idp.DeliveryStatusDelivered
+		delivery.Error = ""
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = idp.DeliveryStatusFailed
+		delivery.Error = sendError.Error()
+		slog.Warn(
+			"webhook delivery failed for good",
+			"delivery", delivery.ID,
+			"event", delivery.Event,
+			"url", delivery.Webhook.URL,
+			"attempts", delivery.Attempts,
+			"error", sendError,
+		)
+	default:
+		delivery.Error = sendError.Error()
+		delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+	}
+	return d.queue.UpdateDelivery(ctx, delivery)
+}
+
+// send posts the signed payload of the delivery to the webhook
+// and returns the response status.
+func (d *Dispatcher) send(ctx context.Context, delivery idp.Delivery) (int, error) {
+	request, requestError := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(delivery.Payload))
+	if requestError != nil {
+		return 0, requestError
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "gophermart-webhook")
+	request.Header.Set(HeaderEvent, delivery.Event)
+	request.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HeaderSignature, Sign(delivery.Webhook.Secret, timestamp, delivery.Payload))
+
+	response, responseError := d.client.Do(request)
+	if responseError != nil {
+		return 0, responseError
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, responseLimit))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected response status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// backoff returns the delay after the failed attempt.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		return d.MaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"time"
+)
+
+// The types of the delivered events.
+const (
+	// EventOrderUpdated is delivered when an order is added or its status changes.
+	EventOrderUpdated = "order.updated"
+
+	// EventWithdrawalCreated is delivered when the user withdraws points.
+	EventWithdrawalCreated = "withdrawal.created"
+)
+
+// message is an event ready to be delivered.
+type message struct {
+	user    int64
+	event   string
+	payload []byte
+}
+
+// messages converts the event to the messages to deliver, the payload of a message is
+//
+//	{
+//		"id": "2c5f6b1e9a0d4c7f8e3b1a2d4c6e8f0a",
+//		"type": "order.updated",
+//		"created_at": "2020-12-10T15:15:45+03:00",
+//		"data": {"number": "9278923470", "status": "PROCESSED", "accrual": 500, "uploaded_at": "2020-12-10T15:15:45+03:00"},
+//		"balance": {"current": 500.5, "withdrawn": 42}
+//	}
+//
+// or the same with the "withdrawal.created" type and {"order", "sum", "processed_at"} as the data.
+// The balance is present if it has changed.
+func messages(event idp.Event, now time.Time) ([]message, error) {
+	var balance map[string]any
+	if event.Balance != nil {
+		balance = map[string]any{
+			"current":   event.Balance.Current,
+			"withdrawn": event.Balance.Withdrawn,
+		}
+	}
+
+	data := make(map[string]map[string]any)
+	if o := event.Order; o != nil {
+		order := map[string]any{
+			"number":      o.ID,
+			"status":      string(o.Status),
+			"uploaded_at": o.Time.Format(time.RFC3339),
+		}
+		if o.Accrual > 0 {
+			order["accrual"] = o.Accrual
+		}
+		data[EventOrderUpdated] = order
+	}
+	if w := event.Withdrawal; w != nil {
+		data[EventWithdrawalCreated] = map[string]any{
+			"order":        w.Order,
+			"sum":          w.Sum,
+			"processed_at": w.Time.Format(time.RFC3339),
+		}
+	}
+
+	result := make([]message, 0, len(data))
+	for _, eventType := range []string{EventOrderUpdated, EventWithdrawalCreated} {
+		eventData, ok := data[eventType]
+		if !ok {
+			continue
+		}
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		payload := map[string]any{
+			"id":         hex.EncodeToString(id),
+			"type":       eventType,
+			"created_at": now.Format(time.RFC3339),
+			"data":       eventData,
+		}
+		if balance != nil {
+			payload["balance"] = balance
+		}
+		encoded, encodeError := json.Marshal(payload)
+		if encodeError != nil {
+			return nil, encodeError
+		}
+		result = append(result, message{user: event.User, event: eventType, payload: encoded})
+	}
+	return result, nil
+}
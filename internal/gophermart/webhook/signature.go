@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// The headers of a delivery request.
+const (
+	// HeaderEvent is the type of the delivered event.
+	HeaderEvent = "X-Gophermart-Event"
+
+	// HeaderDelivery is the id of the delivery, it is the same for every attempt.
+	HeaderDelivery = "X-Gophermart-Delivery"
+
+	// HeaderTimestamp is the Unix time of the attempt in seconds.
+	HeaderTimestamp = "X-Gophermart-Timestamp"
+
+	// HeaderSignature is the signature of the attempt made by Sign.
+	HeaderSignature = "X-Gophermart-Signature"
+)
+
+// Sign returns the signature of an attempt to deliver body at timestamp:
+// "sha256=" followed by the hex encoded HMAC-SHA256 of "<timestamp>.<body>"
+// keyed by the secret of the webhook.
+//
+// Signing the timestamp lets the receivers reject replayed deliveries.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body delivered at timestamp.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,131 @@
+// Package webhooktest implements a local webhook receiver for tests
+// and a suite that runs webhook.Dispatcher against an idptest.Database.
+//
+//	receiver := webhooktest.NewReceiver(t)
+//	webhook, _ := user.AddWebhook(ctx, receiver.URL())
+//	...
+//	for _, delivery := range receiver.Await(t, 1, 10*time.Second) {
+//		if !delivery.Verify(webhook.Secret) {
+//			t.Errorf("delivery %s is not signed with the secret", delivery.ID)
+//		}
+//	}
+package webhooktest
+
+import (
+	"github.com/kerelape/gophermart/internal/gophermart/webhook"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Delivery is a delivery request accepted by a Receiver.
+type Delivery struct {
+	ID        string
+	Event     string
+	Timestamp int64
+	Signature string
+	Body      []byte
+}
+
+// Verify reports whether the delivery is signed with the secret.
+func (d Delivery) Verify(secret string) bool {
+	return webhook.Verify(secret, d.Timestamp, d.Body, d.Signature)
+}
+
+// Receiver is an httptest server that accepts webhook deliveries.
+type Receiver struct {
+	server *httptest.Server
+
+	mutex      *sync.Mutex
+	failures   int
+	attempts   int
+	deliveries []Delivery
+}
+
+// NewReceiver starts a new Receiver that is closed when the test ends.
+func NewReceiver(t *testing.T) *Receiver {
+	r := &Receiver{
+		mutex:      &sync.Mutex{},
+		deliveries: make([]Delivery, 0),
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// URL returns the URL to register as a webhook.
+func (r *Receiver) URL() string {
+	return r.server.URL
+}
+
+// Fail makes the receiver answer the next n requests with 500 Internal Server Error.
+func (r *Receiver) Fail(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failures = n
+}
+
+// Attempts returns the number of the received requests, including the failed ones.
+func (r *Receiver) Attempts() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.attempts
+}
+
+// Deliveries returns the accepted deliveries.
+func (r *Receiver) Deliveries() []Delivery {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Delivery(nil), r.deliveries...)
+}
+
+// Await waits until the receiver accepts n deliveries and returns them.
+func (r *Receiver) Await(t *testing.T, n int, timeout time.Duration) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		deliveries := r.Deliveries()
+		if len(deliveries) >= n {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d deliveries are accepted in %s, want %d", len(deliveries), timeout, n)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (r *Receiver) serveHTTP(out http.ResponseWriter, in *http.Request) {
+	body, readError := io.ReadAll(in.Body)
+	if readError != nil {
+		http.Error(out, readError.Error(), http.StatusBadRequest)
+		return
+	}
+	timestamp, timestampError := strconv.ParseInt(in.Header.Get(webhook.HeaderTimestamp), 10, 64)
+	if timestampError != nil {
+		http.Error(out, "bad timestamp", http.StatusBadRequest)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.attempts++
+	if r.failures > 0 {
+		r.failures--
+		http.Error(out, "failure requested by the test", http.StatusInternalServerError)
+		return
+	}
+	r.deliveries = append(r.deliveries, Delivery{
+		ID:        in.Header.Get(webhook.HeaderDelivery),
+		Event:     in.Header.Get(webhook.HeaderEvent),
+		Timestamp: timestamp,
+		Signature: in.Header.Get(webhook.HeaderSignature),
+		Body:      body,
+	})
+	out.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,244 @@
+package webhooktest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kerelape/gophermart/internal/accrual"
+	"github.com/kerelape/gophermart/internal/gophermart/idp"
+	"github.com/kerelape/gophermart/internal/gophermart/idp/idptest"
+	"github.com/kerelape/gophermart/internal/gophermart/webhook"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// deliveryTimeout is how long the suite waits for the dispatcher to deliver an event.
+	deliveryTimeout = 10 * time.Second
+
+	// maxAttempts is the number of attempts the dispatcher makes to deliver an event.
+	maxAttempts = 3
+)
+
+// TestDispatcher checks that webhook.Dispatcher delivers the events
+// to the webhooks stored in the databases returned by open.
+//
+// The suite publishes the events itself, so the databases are given
+// an accrual system that does not know any orders. The receivers listen
+// on the loopback, so the databases must allow the private webhooks.
+func TestDispatcher(t *testing.T, open idptest.Open) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, database idptest.Database, broker idp.Broker)
+	}{
+		{"Deliver", testDeliver},
+		{"Retry", testRetry},
+		{"DeadLetter", testDeadLetter},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			system := httptest.NewServer(http.HandlerFunc(func(out http.ResponseWriter, _ *http.Request) {
+				out.WriteHeader(http.StatusNoContent)
+			}))
+			t.Cleanup(system.Close)
+			broker := &subscriptionBroker{Broker: idp.NewMemoryBroker(), subscribed: make(chan struct{})}
+			database := open(t, accrual.New(system.URL, system.Client()), broker)
+
+			dispatcher := webhook.New(database, broker, &http.Client{Timeout: 5 * time.Second})
+			dispatcher.Backoff = 10 * time.Millisecond
+			dispatcher.MaxBackoff = 10 * time.Millisecond
+			dispatcher.MaxAttempts = maxAttempts
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 2)
+			go func() {
+				done <- database.Run(ctx)
+			}()
+			go func() {
+				done <- dispatcher.Run(ctx)
+			}()
+			t.Cleanup(func() {
+				cancel()
+				for i := 0; i < 2; i++ {
+					if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+						t.Errorf("stopped with an error: %v", err)
+					}
+				}
+			})
+
+			idptest.AwaitAvailable(t, database)
+			select {
+			case <-broker.subscribed:
+			case <-time.After(deliveryTimeout):
+				t.Fatalf("the dispatcher has not subscribed to the broker in %s", deliveryTimeout)
+			}
+			test.test(t, database, broker)
+		})
+	}
+}
+
+func testDeliver(t *testing.T, database idptest.Database, broker idp.Broker) {
+	ctx := context.Background()
+	identity, receiver, hook := createSubscriber(t, database)
+	_, otherReceiver, _ := createSubscriber(t, database)
+
+	now := time.Now()
+	order := idp.Order{ID: "12345678903", Status: idp.OrderStatusProcessed, Accrual: 500, Time: now}
+	credited := idp.Balance{Current: 500}
+	broker.Publish(ctx, idp.Event{User: identity.ID(), Order: &order, Balance: &credited})
+	withdrawal := idp.Withdrawal{Order: "2377225624", Sum: 100, Time: now}
+	balance := idp.Balance{Current: 400, Withdrawn: 100}
+	broker.Publish(ctx, idp.Event{User: identity.ID(), Withdrawal: &withdrawal, Balance: &balance})
+
+	deliveries := receiver.Await(t, 2, deliveryTimeout)
+	events := make(map[string]payload)
+	for _, delivery := range deliveries {
+		if !delivery.Verify(hook.Secret) {
+			t.Fatalf("delivery %s of %s is not signed with the secret of the webhook", delivery.ID, delivery.Event)
+		}
+		var p payload
+		if err := json.Unmarshal(delivery.Body, &p); err != nil {
+			t.Fatalf("delivery %s is not a JSON object: %v", delivery.ID, err)
+		}
+		if p.Type != delivery.Event || p.ID == "" {
+			t.Fatalf("delivery %s of %s has type %q and id %q", delivery.ID, delivery.Event, p.Type, p.ID)
+		}
+		events[delivery.Event] = p
+	}
+
+	if p, ok := events[webhook.EventOrderUpdated]; !ok || p.Data["number"] != order.ID || p.Data["status"] != "PROCESSED" {
+		t.Fatalf("%s = %+v, want the processed order", webhook.EventOrderUpdated, p)
+	}
+	if p, ok := events[webhook.EventWithdrawalCreated]; !ok || p.Data["order"] != withdrawal.Order || p.Balance == nil || *p.Balance != balance {
+		t.Fatalf("%s = %+v, want the withdrawal and the balance", webhook.EventWithdrawalCreated, p)
+	}
+
+	history := awaitDeliveries(t, identity, hook.ID, func(deliveries []idp.Delivery) bool {
+		for _, delivery := range deliveries {
+			if delivery.Status != idp.DeliveryStatusDelivered {
+				return false
+			}
+		}
+		return len(deliveries) == 2
+	})
+	for _, delivery := range history {
+		if delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusNoContent {
+			t.Fatalf("Deliveries() = %+v, want delivered at the first attempt", delivery)
+		}
+	}
+
+	if attempts := otherReceiver.Attempts(); attempts != 0 {
+		t.Fatalf("the webhook of another identity received %d requests, want 0", attempts)
+	}
+}
+
+func testRetry(t *testing.T, database idptest.Database, broker idp.Broker) {
+	identity, receiver, hook := createSubscriber(t, database)
+	receiver.Fail(2)
+
+	publishOrder(identity, broker)
+
+	deliveries := receiver.Await(t, 1, deliveryTimeout)
+	if attempts := receiver.Attempts(); attempts != 3 {
+		t.Fatalf("the receiver got %d requests, want 3", attempts)
+	}
+	history := awaitDeliveries(t, identity, hook.ID, func(deliveries []idp.Delivery) bool {
+		return len(deliveries) == 1 && deliveries[0].Status == idp.DeliveryStatusDelivered
+	})
+	if history[0].Attempts != 3 || strconv.FormatInt(history[0].ID, 10) != deliveries[0].ID {
+		t.Fatalf("Deliveries() = %+v, want delivered at the third attempt", history[0])
+	}
+}
+
+func testDeadLetter(t *testing.T, database idptest.Database, broker idp.Broker) {
+	identity, receiver, hook := createSubscriber(t, database)
+	receiver.Fail(1000)
+
+	publishOrder(identity, broker)
+
+	history := awaitDeliveries(t, identity, hook.ID, func(deliveries []idp.Delivery) bool {
+		return len(deliveries) == 1 && deliveries[0].Status == idp.DeliveryStatusFailed
+	})
+	if history[0].Attempts != maxAttempts || history[0].ResponseStatus != http.StatusInternalServerError || history[0].Error == "" {
+		t.Fatalf("Deliveries() = %+v, want failed after %d attempts", history[0], maxAttempts)
+	}
+	time.Sleep(time.Second) // the dispatcher must not attempt a failed delivery again
+	if attempts := receiver.Attempts(); attempts != maxAttempts {
+		t.Fatalf("the receiver got %d requests, want %d", attempts, maxAttempts)
+	}
+}
+
+// payload is the JSON payload of a delivery.
+type payload struct {
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Data    map[string]any `json:"data"`
+	Balance *idp.Balance   `json:"balance"`
+}
+
+// subscriptionBroker is an idp.Broker that reports the first subscription to all events,
+// so that the suite does not publish events before the dispatcher listens.
+type subscriptionBroker struct {
+	idp.Broker
+
+	once       sync.Once
+	subscribed chan struct{}
+}
+
+func (b *subscriptionBroker) SubscribeAll(ctx context.Context) <-chan idp.Event {
+	events := b.Broker.SubscribeAll(ctx)
+	b.once.Do(func() {
+		close(b.subscribed)
+	})
+	return events
+}
+
+// createSubscriber creates an identity with a webhook that points to a new Receiver.
+func createSubscriber(t *testing.T, database idp.IdentityDatabase) (idp.Identity, *Receiver, idp.Webhook) {
+	t.Helper()
+	ctx := context.Background()
+	username := "webhooktest-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := database.Create(ctx, username, "password"); err != nil {
+		t.Fatalf("Create() = %v, want nil", err)
+	}
+	identity, findError := database.Find(ctx, username)
+	if findError != nil {
+		t.Fatalf("Find() = %v, want nil", findError)
+	}
+	receiver := NewReceiver(t)
+	hook, addError := identity.AddWebhook(ctx, receiver.URL())
+	if addError != nil {
+		t.Fatalf("AddWebhook() = %v, want nil", addError)
+	}
+	return identity, receiver, hook
+}
+
+// publishOrder publishes a new order of the identity.
+func publishOrder(identity idp.Identity, broker idp.Broker) {
+	order := idp.Order{ID: "12345678903", Status: idp.OrderStatusNew, Time: time.Now()}
+	broker.Publish(context.Background(), idp.Event{User: identity.ID(), Order: &order})
+}
+
+// awaitDeliveries waits until the deliveries to the webhook satisfy done and returns them.
+func awaitDeliveries(t *testing.T, identity idp.Identity, webhook int64, done func([]idp.Delivery) bool) []idp.Delivery {
+	t.Helper()
+	deadline := time.Now().Add(deliveryTimeout)
+	for {
+		deliveries, deliveriesError := identity.Deliveries(context.Background(), webhook, 0)
+		if deliveriesError != nil {
+			t.Fatalf("Deliveries() = %v, want nil", deliveriesError)
+		}
+		if done(deliveries) {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Deliveries() = %+v after %s", deliveries, deliveryTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}